@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"main/internal/devcert"
+)
+
+func main() {
+	dir := flag.String("dir", "", "directory the CA and certificates are cached in (default: user cache directory)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: devcert [-dir dir] [host ...]\n\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "Creates a local CA and a certificate for the hosts, %v by default.\n\n", devcert.DefaultHosts)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *dir == "" {
+		d, err := devcert.DefaultDir()
+		if err != nil {
+			log.Fatalf("error locating cache directory: %v", err)
+		}
+		*dir = d
+	}
+	hosts := flag.Args()
+	if len(hosts) == 0 {
+		hosts = devcert.DefaultHosts
+	}
+
+	ca, err := devcert.LoadCA(*dir)
+	if err != nil {
+		log.Fatalf("error loading CA: %v", err)
+	}
+	files, err := ca.Issue(*dir, hosts...)
+	if err != nil {
+		log.Fatalf("error issuing certificate: %v", err)
+	}
+
+	fmt.Printf("CA certificate: %s\n", ca.CertFile)
+	fmt.Printf("Certificate:    %s\n", files.CertFile)
+	fmt.Printf("Key:            %s\n", files.KeyFile)
+	fmt.Printf("\nServe it with:\n  go run ./cmd/httpserver -tls-cert %s -tls-key %s\n", files.CertFile, files.KeyFile)
+	fmt.Printf("and trust it with, for example:\n  curl --cacert %s https://localhost:42443/\n", ca.CertFile)
+}
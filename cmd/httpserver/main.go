@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"main/internal/compress"
+	"main/internal/devcert"
+	"main/internal/ratelimit"
+	"main/internal/request"
+	"main/internal/response"
+	"main/internal/server"
+	"main/internal/sse"
+	"main/internal/websocket"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	port    = 42069
+	tlsPort = 42443
+)
+
+// maxUploadSize caps decompressed request bodies.
+const maxUploadSize = 32 << 20
+
+// Each client IP may make bursts of up to rateBurst requests, refilled
+// at rateLimit per second.
+const (
+	rateLimit = 20
+	rateBurst = 40
+)
+
+// Connection limits: beyond maxConns, new connections queue in the
+// listen backlog.
+const (
+	maxConns           = 1024
+	maxConnsPerIP      = 32
+	maxRequestsPerConn = 1000
+)
+
+func main() {
+	certFile := flag.String("tls-cert", "", "PEM certificate chain; also serves HTTPS on port 42443")
+	keyFile := flag.String("tls-key", "", "PEM private key for -tls-cert")
+	devTLS := flag.Bool("dev-tls", false, "serve HTTPS on port 42443 with a development certificate for localhost")
+	flag.Parse()
+
+	if *devTLS && *certFile == "" {
+		dir, err := devcert.DefaultDir()
+		if err != nil {
+			log.Fatalf("Error locating certificate cache: %v", err)
+		}
+		files, err := devcert.Ensure(dir, devcert.DefaultHosts...)
+		if err != nil {
+			log.Fatalf("Error creating development certificate: %v", err)
+		}
+		*certFile, *keyFile = files.CertFile, files.KeyFile
+	}
+
+	limiter := ratelimit.NewLimiter(rateLimit, rateBurst)
+	defer limiter.Close()
+	h := ratelimit.Middleware(compress.Middleware(handler), limiter)
+	opts := []server.Option{
+		server.WithRequestOptions(request.WithDecompression(maxUploadSize)),
+		server.WithHTTP2(),
+		server.WithMaxConnections(maxConns),
+		server.WithMaxConnectionsPerIP(maxConnsPerIP),
+		server.WithMaxRequestsPerConn(maxRequestsPerConn),
+	}
+
+	plain, err := server.Serve(port, h, opts...)
+	if err != nil {
+		log.Fatalf("Error starting server: %v", err)
+	}
+	defer plain.Close()
+	log.Println("Server started on port", port)
+
+	if *certFile != "" {
+		certs, err := server.LoadCertificates(server.CertFiles{CertFile: *certFile, KeyFile: *keyFile})
+		if err != nil {
+			log.Fatalf("Error loading certificate: %v", err)
+		}
+		secure, err := server.ServeTLS(tlsPort, h, certs, opts...)
+		if err != nil {
+			log.Fatalf("Error starting TLS server: %v", err)
+		}
+		defer secure.Close()
+		log.Println("TLS server started on port", tlsPort)
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	<-sigChan
+	log.Println("Server gracefully stopped")
+}
+
+func handler(w response.Writer, req *request.Request) {
+	switch req.RequestLine.RequestTarget {
+	case "/upload":
+		// Reading the body answers Expect: 100-continue.
+		body, err := io.ReadAll(req.Body)
+		if errors.Is(err, request.ErrDecompressedTooLarge) {
+			_ = response.Error(w, response.StatusRequestEntityTooLarge, "Body too large\n")
+			return
+		}
+		if err != nil {
+			_ = response.Error(w, response.StatusBadRequest, "Error reading body\n")
+			return
+		}
+		_ = response.Error(w, response.StatusOK, fmt.Sprintf("Received %d bytes\n", len(body)))
+	case "/ws":
+		echo(w, req)
+	case "/events":
+		clock(w, req)
+	default:
+		_ = response.Error(w, response.StatusOK, "All good, frfr\n")
+	}
+}
+
+// echo sends every WebSocket message back to its sender.
+var echo = websocket.Handler(func(conn *websocket.Conn, req *request.Request) {
+	for {
+		typ, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if err := conn.WriteMessage(typ, msg); err != nil {
+			return
+		}
+	}
+})
+
+// clock sends the time every second until the client goes away.
+var clock = sse.Handler(func(s *sse.Stream, req *request.Request) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for id := 1; ; id++ {
+		select {
+		case now := <-ticker.C:
+			if s.Send(sse.Event{ID: strconv.Itoa(id), Event: "tick", Data: now.Format(time.RFC3339)}) != nil {
+				return
+			}
+		case <-s.Context().Done():
+			return
+		}
+	}
+})
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"main/internal/request"
 	"net"
@@ -34,6 +35,12 @@ func main() {
 			for key := range req.Headers {
 				fmt.Printf("- %s: %s\n", key, req.Headers[key])
 			}
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				log.Fatalf("error reading body: %v\n", err)
+			}
+			fmt.Println("Body:")
+			fmt.Printf("%s\n", body)
 			fmt.Println("")
 			fmt.Printf("Channel closed\n\n")
 		}
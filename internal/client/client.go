@@ -0,0 +1,278 @@
+package client
+
+import (
+	"bufio"
+	"io"
+	"main/internal/request"
+	"main/internal/response"
+	"net"
+	"sync"
+	"time"
+)
+
+const userAgent = "tcptohttp"
+
+const (
+	defaultDialTimeout    = 10 * time.Second
+	defaultIdleTimeout    = 90 * time.Second
+	defaultMaxIdlePerHost = 2
+	bufferSize            = 8192
+)
+
+// Client sends requests over keep-alive connections, keeping a few idle
+// connections per host for reuse. It is safe for concurrent use.
+type Client struct {
+	dialTimeout    time.Duration
+	headerTimeout  time.Duration
+	idleTimeout    time.Duration
+	maxIdlePerHost int
+
+	mu   sync.Mutex
+	idle map[string][]*persistConn
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithDialTimeout bounds how long connecting to a server may take.
+func WithDialTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.dialTimeout = d
+	}
+}
+
+// WithResponseHeaderTimeout bounds how long the server may take to send
+// the head of its response once the request has been written. Zero, the
+// default, waits forever.
+func WithResponseHeaderTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.headerTimeout = d
+	}
+}
+
+// WithIdleTimeout sets how long an unused connection is kept.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.idleTimeout = d
+	}
+}
+
+// WithMaxIdlePerHost sets how many unused connections are kept per host.
+// Zero disables connection reuse.
+func WithMaxIdlePerHost(n int) Option {
+	return func(c *Client) {
+		c.maxIdlePerHost = n
+	}
+}
+
+// New returns a Client configured by opts.
+func New(opts ...Option) *Client {
+	c := &Client{
+		dialTimeout:    defaultDialTimeout,
+		idleTimeout:    defaultIdleTimeout,
+		maxIdlePerHost: defaultMaxIdlePerHost,
+		idle:           make(map[string][]*persistConn),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Get sends a GET request for url.
+func (c *Client) Get(url string) (*response.Response, error) {
+	req, err := NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Post sends body as a POST request to url.
+func (c *Client) Post(url, contentType string, body io.Reader) (*response.Response, error) {
+	req, err := NewRequest("POST", url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Headers.Set("Content-Type", contentType)
+	return c.Do(req)
+}
+
+// Do sends req and returns the response once its head has arrived. The
+// caller must close the response body, which returns the connection to
+// the pool once the body has been read to the end.
+func (c *Client) Do(req *Request) (*response.Response, error) {
+	addr := req.addr()
+	pc, reused, err := c.getConn(addr)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.roundTrip(pc, req)
+	if err != nil && reused && req.replayable() && !pc.received {
+		// The server may have closed the idle connection just as it was
+		// picked. It may also have acted on the request before closing,
+		// so only requests that can be repeated are tried again.
+		pc.conn.Close()
+		if pc, err = c.dial(addr); err != nil {
+			return nil, err
+		}
+		resp, err = c.roundTrip(pc, req)
+	}
+	if err != nil {
+		pc.conn.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *Client) roundTrip(pc *persistConn, req *Request) (*response.Response, error) {
+	if err := req.write(pc.bw); err != nil {
+		return nil, err
+	}
+	if c.headerTimeout > 0 {
+		if err := pc.conn.SetReadDeadline(time.Now().Add(c.headerTimeout)); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := pc.br.Peek(1); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	pc.received = true
+	resp, err := readResponse(pc.br, req.Method)
+	if err != nil {
+		return nil, err
+	}
+	if err := pc.conn.SetReadDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+
+	// A body without Content-Length or chunked framing runs until the
+	// server closes the connection.
+	closeDelimited := resp.Body != request.NoBody &&
+		resp.Headers.Get("Transfer-Encoding") == "" && resp.Headers.Get("Content-Length") == ""
+	reusable := c.maxIdlePerHost > 0 &&
+		resp.StatusLine.HttpVersion == "1.1" &&
+		resp.StatusLine.StatusCode != response.StatusSwitchingProtocols &&
+		!closeDelimited &&
+		!resp.Headers.HasToken("Connection", "close") &&
+		!req.Headers.HasToken("Connection", "close")
+	resp.Body = &bodyCloser{ReadCloser: resp.Body, pc: pc, client: c, addr: req.addr(), reusable: reusable}
+	return resp, nil
+}
+
+// persistConn is a connection that may carry several requests.
+type persistConn struct {
+	conn     net.Conn
+	br       *bufio.Reader
+	bw       *bufio.Writer
+	idleAt   time.Time
+	received bool
+}
+
+func (c *Client) getConn(addr string) (pc *persistConn, reused bool, err error) {
+	c.mu.Lock()
+	for conns := c.idle[addr]; len(conns) > 0; conns = c.idle[addr] {
+		pc := conns[len(conns)-1]
+		c.idle[addr] = conns[:len(conns)-1]
+		if time.Since(pc.idleAt) < c.idleTimeout {
+			c.mu.Unlock()
+			pc.received = false
+			return pc, true, nil
+		}
+		pc.conn.Close()
+	}
+	c.mu.Unlock()
+
+	pc, err = c.dial(addr)
+	return pc, false, err
+}
+
+func (c *Client) dial(addr string) (*persistConn, error) {
+	conn, err := net.DialTimeout("tcp", addr, c.dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &persistConn{
+		conn: conn,
+		br:   bufio.NewReaderSize(conn, bufferSize),
+		bw:   bufio.NewWriterSize(conn, bufferSize),
+	}, nil
+}
+
+// putConn returns pc to the idle pool, or closes it if the pool for addr
+// is full.
+func (c *Client) putConn(addr string, pc *persistConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.idle[addr]) >= c.maxIdlePerHost {
+		pc.conn.Close()
+		return
+	}
+	pc.idleAt = time.Now()
+	c.idle[addr] = append(c.idle[addr], pc)
+}
+
+// CloseIdleConnections closes every connection not currently in use.
+func (c *Client) CloseIdleConnections() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for addr, conns := range c.idle {
+		for _, pc := range conns {
+			pc.conn.Close()
+		}
+		delete(c.idle, addr)
+	}
+}
+
+// bodyCloser hands the connection back to the pool once the response
+// body has been read to EOF, or drained by Close.
+type bodyCloser struct {
+	io.ReadCloser
+	pc       *persistConn
+	client   *Client
+	addr     string
+	reusable bool
+	done     bool
+}
+
+func (b *bodyCloser) Read(p []byte) (int, error) {
+	if b.done {
+		return 0, io.EOF
+	}
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		b.release(err == io.EOF)
+	}
+	return n, err
+}
+
+// Close drains what is left of a reusable body so the connection can
+// carry the next request. Bodies too large to drain, and connections that
+// cannot be reused anyway, are closed instead.
+func (b *bodyCloser) Close() error {
+	if b.done {
+		return nil
+	}
+	if !b.reusable {
+		b.release(false)
+		return nil
+	}
+	err := b.ReadCloser.Close()
+	b.release(err == nil)
+	return err
+}
+
+func (b *bodyCloser) release(clean bool) {
+	if b.done {
+		return
+	}
+	b.done = true
+	if clean && b.reusable {
+		b.client.putConn(b.addr, b.pc)
+		return
+	}
+	b.pc.conn.Close()
+}
@@ -0,0 +1,253 @@
+package client
+
+import (
+	"bufio"
+	"io"
+	"main/internal/headers"
+	"main/internal/request"
+	"main/internal/response"
+	"main/internal/server"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startServer returns the base URL of a server running handler.
+func startServer(t *testing.T, handler server.Handler, opts ...server.Option) string {
+	t.Helper()
+	s, err := server.Serve(0, handler, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	port := s.Addr().(*net.TCPAddr).Port
+	return "http://127.0.0.1:" + strconv.Itoa(port)
+}
+
+// remoteAddrHandler replies with the client's address, which tells the
+// tests whether a connection was reused.
+func remoteAddrHandler(w response.Writer, req *request.Request) {
+	_, _ = io.Copy(io.Discard, req.Body)
+	_ = response.Error(w, response.StatusOK, req.RemoteAddr)
+}
+
+func readAll(t *testing.T, resp *response.Response) string {
+	t.Helper()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	return string(body)
+}
+
+func TestClientKeepAlive(t *testing.T) {
+	base := startServer(t, remoteAddrHandler)
+	c := New()
+	defer c.CloseIdleConnections()
+
+	// Test: Sequential requests reuse the connection
+	resp, err := c.Get(base + "/")
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusOK, resp.StatusLine.StatusCode)
+	first := readAll(t, resp)
+	resp, err = c.Post(base+"/", "text/plain", strings.NewReader("body"))
+	require.NoError(t, err)
+	assert.Equal(t, first, readAll(t, resp))
+
+	// Test: Closing an unread body drains it and keeps the connection
+	resp, err = c.Get(base + "/")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	resp, err = c.Get(base + "/")
+	require.NoError(t, err)
+	assert.Equal(t, first, readAll(t, resp))
+
+	// Test: Concurrent requests use separate connections
+	a, err := c.Get(base + "/")
+	require.NoError(t, err)
+	b, err := c.Get(base + "/")
+	require.NoError(t, err)
+	assert.NotEqual(t, readAll(t, a), readAll(t, b))
+
+	// Test: Connection: close on the request is honoured
+	req, err := NewRequest("GET", base+"/", nil)
+	require.NoError(t, err)
+	req.Headers.Set("Connection", "close")
+	resp, err = c.Do(req)
+	require.NoError(t, err)
+	readAll(t, resp)
+	c.CloseIdleConnections()
+	resp, err = c.Get(base + "/")
+	require.NoError(t, err)
+	assert.NotEqual(t, first, readAll(t, resp))
+}
+
+func TestClientNoReuse(t *testing.T) {
+	base := startServer(t, remoteAddrHandler)
+
+	// Test: With no idle connections allowed every request dials
+	c := New(WithMaxIdlePerHost(0))
+	resp, err := c.Get(base + "/")
+	require.NoError(t, err)
+	first := readAll(t, resp)
+	resp, err = c.Get(base + "/")
+	require.NoError(t, err)
+	assert.NotEqual(t, first, readAll(t, resp))
+}
+
+func TestClientChunked(t *testing.T) {
+	base := startServer(t, func(w response.Writer, req *request.Request) {
+		body, _ := io.ReadAll(req.Body)
+		h := headers.NewHeaders()
+		h.Set("Transfer-Encoding", "chunked")
+		h.Set("Trailer", "X-Length")
+		_ = w.WriteStatusLine(response.StatusOK)
+		_ = w.WriteHeaders(h)
+		_, _ = w.WriteChunkedBody(body)
+		_, _ = w.WriteChunkedBody([]byte("!"))
+		_, _ = w.WriteChunkedBodyDone()
+		_ = w.WriteTrailers(headers.Headers{"x-length": strconv.Itoa(len(body))})
+	})
+	c := New()
+	defer c.CloseIdleConnections()
+
+	// Test: Chunked request and response bodies, with trailers
+	resp, err := c.Post(base+"/", "text/plain", io.NopCloser(strings.NewReader("streamed")))
+	require.NoError(t, err)
+	assert.Equal(t, "streamed!", readAll(t, resp))
+	assert.Equal(t, "8", resp.Trailers.Get("X-Length"))
+
+	// Test: HEAD responses have no body
+	base = startServer(t, remoteAddrHandler, server.WithRequestOptions(request.WithMethods("HEAD")))
+	resp, err = c.Do(mustRequest(t, "HEAD", base+"/"))
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusOK, resp.StatusLine.StatusCode)
+	assert.Empty(t, readAll(t, resp))
+	resp, err = c.Get(base + "/")
+	require.NoError(t, err)
+	assert.NotEmpty(t, readAll(t, resp))
+}
+
+func mustRequest(t *testing.T, method, url string) *Request {
+	t.Helper()
+	req, err := NewRequest(method, url, nil)
+	require.NoError(t, err)
+	return req
+}
+
+// rawServer runs serve on every connection accepted by a bare listener.
+func rawServer(t *testing.T, serve func(net.Conn)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				serve(conn)
+			}()
+		}
+	}()
+	return "http://" + ln.Addr().String()
+}
+
+func TestClientCloseDelimited(t *testing.T) {
+	conns := make(chan struct{}, 10)
+	base := rawServer(t, func(conn net.Conn) {
+		conns <- struct{}{}
+		br := bufio.NewReader(conn)
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil || line == "\r\n" {
+				break
+			}
+		}
+		_, _ = io.WriteString(conn, "HTTP/1.1 200 OK\r\n\r\nuntil close")
+	})
+	c := New()
+	defer c.CloseIdleConnections()
+
+	// Test: Bodies without framing end at EOF and are not pooled
+	resp, err := c.Get(base + "/")
+	require.NoError(t, err)
+	assert.Equal(t, "until close", readAll(t, resp))
+	resp, err = c.Get(base + "/")
+	require.NoError(t, err)
+	assert.Equal(t, "until close", readAll(t, resp))
+	assert.Len(t, conns, 2)
+}
+
+func TestClientStaleConnection(t *testing.T) {
+	served := make(chan struct{}, 10)
+	base := rawServer(t, func(conn net.Conn) {
+		// Answer a single request, then close as if idle too long.
+		br := bufio.NewReader(conn)
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil || line == "\r\n" {
+				break
+			}
+		}
+		_, _ = io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+		served <- struct{}{}
+	})
+	c := New()
+	defer c.CloseIdleConnections()
+
+	// Test: A pooled connection closed by the server is retried once
+	resp, err := c.Get(base + "/")
+	require.NoError(t, err)
+	assert.Equal(t, "ok", readAll(t, resp))
+	<-served
+	time.Sleep(20 * time.Millisecond)
+	resp, err = c.Get(base + "/")
+	require.NoError(t, err)
+	assert.Equal(t, "ok", readAll(t, resp))
+	<-served
+
+	// Test: A POST is not, since the server may have acted on it
+	time.Sleep(20 * time.Millisecond)
+	req, err := NewRequest("POST", base+"/", nil)
+	require.NoError(t, err)
+	_, err = c.Do(req)
+	assert.Error(t, err)
+
+	// Test: Unless it carries an idempotency key
+	resp, err = c.Get(base + "/")
+	require.NoError(t, err)
+	assert.Equal(t, "ok", readAll(t, resp))
+	<-served
+	time.Sleep(20 * time.Millisecond)
+	req.Headers.Set("Idempotency-Key", "a1")
+	resp, err = c.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", readAll(t, resp))
+}
+
+func TestClientTimeouts(t *testing.T) {
+	base := rawServer(t, func(conn net.Conn) {
+		_, _ = io.Copy(io.Discard, conn)
+	})
+
+	// Test: A server that never answers trips the header timeout
+	c := New(WithResponseHeaderTimeout(50 * time.Millisecond))
+	_, err := c.Get(base + "/")
+	var ne net.Error
+	require.ErrorAs(t, err, &ne)
+	assert.True(t, ne.Timeout())
+
+	// Test: Dial errors are returned
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	ln.Close()
+	_, err = New(WithDialTimeout(time.Second)).Get("http://" + addr + "/")
+	assert.Error(t, err)
+}
@@ -0,0 +1,135 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"main/internal/headers"
+	"main/internal/request"
+	"maps"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Request is an outgoing request.
+type Request struct {
+	Method  string
+	URL     *url.URL
+	Headers headers.Headers
+	// Body is nil for requests without one.
+	Body io.Reader
+	// ContentLength is the length of Body, or -1 if it is unknown, in
+	// which case the body is sent chunked.
+	ContentLength int64
+}
+
+// NewRequest returns a request for rawURL, an absolute http URL. The
+// length of body is filled in when body is a bytes.Reader,
+// strings.Reader or bytes.Buffer.
+func NewRequest(method, rawURL string, body io.Reader) (*Request, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" || u.Host == "" {
+		return nil, fmt.Errorf("unsupported URL: %q", rawURL)
+	}
+	if !headers.IsToken(method) {
+		return nil, fmt.Errorf("invalid method: %q", method)
+	}
+
+	req := &Request{
+		Method:  method,
+		URL:     u,
+		Headers: headers.NewHeaders(),
+		Body:    body,
+	}
+	switch b := body.(type) {
+	case nil:
+		req.ContentLength = 0
+	case *bytes.Reader:
+		req.ContentLength = int64(b.Len())
+	case *strings.Reader:
+		req.ContentLength = int64(b.Len())
+	case *bytes.Buffer:
+		req.ContentLength = int64(b.Len())
+	default:
+		req.ContentLength = -1
+	}
+	return req, nil
+}
+
+// replayable reports whether req can be sent again after a failure
+// that left its outcome unknown: it has no body to rewind, and its
+// method is idempotent or it carries an Idempotency-Key.
+func (r *Request) replayable() bool {
+	if r.Body != nil {
+		return false
+	}
+	switch r.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return r.Headers.Get("Idempotency-Key") != ""
+}
+
+// addr returns the host:port to connect to for req.
+func (r *Request) addr() string {
+	if r.URL.Port() != "" {
+		return r.URL.Host
+	}
+	return net.JoinHostPort(r.URL.Hostname(), "80")
+}
+
+// write serializes req to bw and flushes it. Host, framing and
+// User-Agent fields are filled in unless already set.
+func (r *Request) write(bw *bufio.Writer) error {
+	h := maps.Clone(r.Headers)
+	if h == nil {
+		h = headers.NewHeaders()
+	}
+	if h.Get("Host") == "" {
+		h.Set("Host", r.URL.Host)
+	}
+	if h.Get("User-Agent") == "" {
+		h.Set("User-Agent", userAgent)
+	}
+	h.Delete("Transfer-Encoding")
+	h.Delete("Content-Length")
+	chunked := r.Body != nil && r.ContentLength < 0
+	switch {
+	case chunked:
+		h.Set("Transfer-Encoding", "chunked")
+	case r.Body != nil || r.Method == "POST" || r.Method == "PUT":
+		h.Set("Content-Length", strconv.FormatInt(r.ContentLength, 10))
+	}
+
+	if _, err := fmt.Fprintf(bw, "%s %s HTTP/1.1\r\n", r.Method, r.URL.RequestURI()); err != nil {
+		return err
+	}
+	if _, err := h.WriteTo(bw); err != nil {
+		return err
+	}
+
+	switch {
+	case chunked:
+		if _, err := io.Copy(request.NewChunkedWriter(bw), r.Body); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(bw, "0\r\n\r\n"); err != nil {
+			return err
+		}
+	case r.Body != nil:
+		n, err := io.Copy(bw, io.LimitReader(r.Body, r.ContentLength))
+		if err != nil {
+			return err
+		}
+		if n != r.ContentLength {
+			return fmt.Errorf("request body is %d bytes, ContentLength is %d", n, r.ContentLength)
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,99 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeRequest(t *testing.T, req *Request) string {
+	t.Helper()
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	require.NoError(t, req.write(bw))
+	return buf.String()
+}
+
+func TestNewRequest(t *testing.T) {
+	// Test: Known body types get a length, others are sent chunked
+	req, err := NewRequest("POST", "http://example.com/a", strings.NewReader("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), req.ContentLength)
+	req, err = NewRequest("POST", "http://example.com/a", io.NopCloser(strings.NewReader("hello")))
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), req.ContentLength)
+
+	// Test: The port defaults to 80
+	assert.Equal(t, "example.com:80", req.addr())
+	req, err = NewRequest("GET", "http://example.com:8080/", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "example.com:8080", req.addr())
+
+	// Test: Only absolute http URLs and token methods are accepted
+	_, err = NewRequest("GET", "https://example.com/", nil)
+	assert.Error(t, err)
+	_, err = NewRequest("GET", "/relative", nil)
+	assert.Error(t, err)
+	_, err = NewRequest("BAD METHOD", "http://example.com/", nil)
+	assert.Error(t, err)
+}
+
+func TestRequestReplayable(t *testing.T) {
+	newReq := func(method string, body io.Reader) *Request {
+		req, err := NewRequest(method, "http://example.com/", body)
+		require.NoError(t, err)
+		return req
+	}
+
+	// Test: Idempotent methods without a body can be sent again
+	for _, method := range []string{"GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE"} {
+		assert.True(t, newReq(method, nil).replayable(), method)
+	}
+
+	// Test: Other methods only with an idempotency key
+	req := newReq("POST", nil)
+	assert.False(t, req.replayable())
+	assert.False(t, newReq("PATCH", nil).replayable())
+	req.Headers.Set("Idempotency-Key", "a1")
+	assert.True(t, req.replayable())
+
+	// Test: Never with a body, which has already been consumed
+	assert.False(t, newReq("PUT", strings.NewReader("x")).replayable())
+}
+
+func TestRequestWrite(t *testing.T) {
+	// Test: Bodiless GET with defaulted Host and User-Agent
+	req, err := NewRequest("GET", "http://example.com:8080/path?q=1", nil)
+	require.NoError(t, err)
+	assert.Equal(t,
+		"GET /path?q=1 HTTP/1.1\r\nhost: example.com:8080\r\nuser-agent: tcptohttp\r\n\r\n",
+		writeRequest(t, req))
+
+	// Test: Bodies with a known length
+	req, err = NewRequest("POST", "http://example.com/", strings.NewReader("hello"))
+	require.NoError(t, err)
+	req.Headers.Set("User-Agent", "custom")
+	out := writeRequest(t, req)
+	assert.Contains(t, out, "content-length: 5\r\n")
+	assert.Contains(t, out, "user-agent: custom\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nhello"))
+
+	// Test: Bodies of unknown length are chunked
+	req, err = NewRequest("POST", "http://example.com/", io.NopCloser(strings.NewReader("hello")))
+	require.NoError(t, err)
+	out = writeRequest(t, req)
+	assert.Contains(t, out, "transfer-encoding: chunked\r\n")
+	assert.NotContains(t, out, "content-length")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n5\r\nhello\r\n0\r\n\r\n"))
+
+	// Test: A body shorter than ContentLength is an error
+	req, err = NewRequest("POST", "http://example.com/", strings.NewReader("hi"))
+	require.NoError(t, err)
+	req.ContentLength = 5
+	assert.Error(t, req.write(bufio.NewWriter(io.Discard)))
+}
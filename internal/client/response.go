@@ -0,0 +1,21 @@
+package client
+
+import (
+	"bufio"
+	"main/internal/response"
+)
+
+// readResponse reads the final response to a request made with method,
+// skipping interim 1xx responses other than 101 Switching Protocols.
+func readResponse(br *bufio.Reader, method string) (*response.Response, error) {
+	for {
+		resp, err := response.ResponseFromReader(br, response.WithRequestMethod(method))
+		if err != nil {
+			return nil, err
+		}
+		code := resp.StatusLine.StatusCode
+		if code >= 200 || code == response.StatusSwitchingProtocols {
+			return resp, nil
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package client
+
+import (
+	"bufio"
+	"io"
+	"main/internal/response"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadResponse(t *testing.T) {
+	// Test: Interim responses are skipped
+	br := bufio.NewReader(strings.NewReader("HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 103 Early Hints\r\nLink: </a>\r\n\r\nHTTP/1.1 201 Created\r\nContent-Length: 2\r\n\r\nok"))
+	resp, err := readResponse(br, "POST")
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCreated, resp.StatusLine.StatusCode)
+	assert.Empty(t, resp.Headers.Get("Link"))
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(body))
+
+	// Test: 101 is final, the connection belongs to the new protocol
+	br = bufio.NewReader(strings.NewReader("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\n\r\n"))
+	resp, err = readResponse(br, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusSwitchingProtocols, resp.StatusLine.StatusCode)
+}
@@ -0,0 +1,356 @@
+package compress
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"main/internal/headers"
+	"main/internal/request"
+	"main/internal/response"
+	"main/internal/server"
+	"maps"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const defaultMinSize = 1024
+
+// defaultExcludedTypes are media types that are already compressed. A
+// trailing "/" matches the whole top-level type.
+var defaultExcludedTypes = []string{
+	"image/",
+	"audio/",
+	"video/",
+	"font/woff",
+	"font/woff2",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zip",
+	"application/zstd",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+	"application/pdf",
+}
+
+type config struct {
+	minSize       int64
+	level         int
+	excludedTypes []string
+}
+
+// Option configures the compression middleware.
+type Option func(*config)
+
+// WithMinSize sets the smallest Content-Length worth compressing. Bodies
+// of unknown length are always compressed.
+func WithMinSize(n int64) Option {
+	return func(c *config) {
+		c.minSize = n
+	}
+}
+
+// WithLevel sets the gzip/zlib compression level.
+func WithLevel(level int) Option {
+	return func(c *config) {
+		c.level = level
+	}
+}
+
+// WithExcludedTypes replaces the list of media types that are never
+// compressed. An entry ending in "/" matches every subtype.
+func WithExcludedTypes(types ...string) Option {
+	return func(c *config) {
+		c.excludedTypes = types
+	}
+}
+
+// Middleware compresses responses of next with gzip or deflate, whichever
+// the client's Accept-Encoding prefers.
+func Middleware(next server.Handler, opts ...Option) server.Handler {
+	cfg := &config{
+		minSize:       defaultMinSize,
+		level:         gzip.DefaultCompression,
+		excludedTypes: defaultExcludedTypes,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	return func(w response.Writer, req *request.Request) {
+		cw := &compressWriter{
+			Writer:   w,
+			cfg:      cfg,
+			encoding: negotiate(req.Headers.Get("Accept-Encoding")),
+			head:     req.RequestLine.Method == "HEAD",
+		}
+		next(cw, req)
+		if cw.enc != nil && cw.buffered && cw.remaining > 0 && !cw.head {
+			// The handler wrote less than its Content-Length. Ending the
+			// chunked stream would pass the body off as complete, so the
+			// connection is dropped instead.
+			cw.dropEncoder()
+			panic(server.ErrAbortHandler)
+		}
+		_ = cw.finish()
+	}
+}
+
+// negotiate picks gzip or deflate from an Accept-Encoding value, or ""
+// when the client accepts neither. Ties go to gzip.
+func negotiate(accept string) string {
+	best, bestQ := "", 0.0
+	wildcard := -1.0
+	q := map[string]float64{}
+	for _, item := range strings.Split(accept, ",") {
+		coding, params, _ := strings.Cut(item, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		weight := 1.0
+		for _, param := range strings.Split(params, ";") {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(name, "q") {
+				if v, err := strconv.ParseFloat(value, 64); err == nil {
+					weight = v
+				}
+			}
+		}
+		if coding == "*" {
+			wildcard = weight
+		} else if coding != "" {
+			q[coding] = weight
+		}
+	}
+	for _, coding := range []string{"gzip", "deflate"} {
+		weight, listed := q[coding]
+		if !listed {
+			weight = max(wildcard, 0)
+		}
+		if weight > bestQ {
+			best, bestQ = coding, weight
+		}
+	}
+	return best
+}
+
+var (
+	gzipPools sync.Map // level -> *sync.Pool of *gzip.Writer
+	zlibPools sync.Map // level -> *sync.Pool of *zlib.Writer
+)
+
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+func getEncoder(encoding string, level int, w io.Writer) (encoder, *sync.Pool, error) {
+	pools := &gzipPools
+	if encoding == "deflate" {
+		pools = &zlibPools
+	}
+	v, _ := pools.LoadOrStore(level, &sync.Pool{})
+	pool := v.(*sync.Pool)
+	if enc, ok := pool.Get().(encoder); ok {
+		enc.Reset(w)
+		return enc, pool, nil
+	}
+
+	var enc encoder
+	var err error
+	if encoding == "deflate" {
+		enc, err = zlib.NewWriterLevel(w, level)
+	} else {
+		enc, err = gzip.NewWriterLevel(w, level)
+	}
+	return enc, pool, err
+}
+
+// compressWriter wraps the handler's response.Writer. Compressed bodies
+// are always sent chunked, since their length is not known up front.
+type compressWriter struct {
+	response.Writer
+	cfg      *config
+	encoding string
+
+	status response.StatusCode
+	head   bool
+	enc    encoder
+	pool   *sync.Pool
+	// buffered is set when a Content-Length body is being re-framed as
+	// chunked; remaining counts down the bytes the handler declared.
+	buffered  bool
+	remaining int64
+}
+
+func (w *compressWriter) WriteStatusLine(code response.StatusCode) error {
+	w.status = code
+	return w.Writer.WriteStatusLine(code)
+}
+
+func (w *compressWriter) WriteHeaders(h headers.Headers) error {
+	if !w.eligible(h) {
+		return w.Writer.WriteHeaders(h)
+	}
+
+	h = maps.Clone(h)
+	if !h.HasToken("Vary", "Accept-Encoding") {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	if w.encoding == "" {
+		return w.Writer.WriteHeaders(h)
+	}
+
+	if !strings.EqualFold(h.Get("Transfer-Encoding"), "chunked") {
+		w.buffered = true
+		w.remaining = -1
+		if v := h.Get("Content-Length"); v != "" {
+			w.remaining, _ = strconv.ParseInt(v, 10, 64)
+		}
+		h.Delete("Content-Length")
+		h.Set("Transfer-Encoding", "chunked")
+	}
+	h.Set("Content-Encoding", w.encoding)
+	// The encoded body is a different representation, so it must not
+	// share a strong validator with the identity one.
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+
+	enc, pool, err := getEncoder(w.encoding, w.cfg.level, response.NewChunkedBodyWriter(w.Writer))
+	if err != nil {
+		return err
+	}
+	w.enc, w.pool = enc, pool
+	return w.Writer.WriteHeaders(h)
+}
+
+// eligible reports whether the response could be compressed at all,
+// independent of what this client accepts. Partial content is left
+// alone, since its Content-Range counts bytes of the identity body.
+func (w *compressWriter) eligible(h headers.Headers) bool {
+	if w.status < 200 || w.status == response.StatusNoContent || w.status == response.StatusNotModified {
+		return false
+	}
+	if w.status == response.StatusPartialContent || h.Get("Content-Range") != "" {
+		return false
+	}
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	if v := h.Get("Content-Length"); v != "" && h.Get("Transfer-Encoding") == "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err != nil || n < w.cfg.minSize {
+			return false
+		}
+	}
+	mediaType, _, _ := strings.Cut(h.Get("Content-Type"), ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	for _, excluded := range w.cfg.excludedTypes {
+		if mediaType == excluded || strings.HasSuffix(excluded, "/") && strings.HasPrefix(mediaType, excluded) {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *compressWriter) WriteBody(p []byte) (int, error) {
+	if w.enc == nil {
+		return w.Writer.WriteBody(p)
+	}
+	if !w.buffered {
+		return 0, response.ErrWriteOrder
+	}
+	if w.remaining >= 0 && int64(len(p)) > w.remaining {
+		return 0, response.ErrBodyTooLong
+	}
+	n, err := w.enc.Write(p)
+	if w.remaining >= 0 {
+		w.remaining -= int64(n)
+	}
+	if err == nil && w.remaining == 0 {
+		err = w.finish()
+	}
+	return n, err
+}
+
+func (w *compressWriter) WriteChunkedBody(p []byte) (int, error) {
+	if w.enc == nil {
+		return w.Writer.WriteChunkedBody(p)
+	}
+	if w.buffered {
+		return 0, response.ErrWriteOrder
+	}
+	n, err := w.enc.Write(p)
+	if err != nil {
+		return n, err
+	}
+	// Keep the handler's chunk boundaries as flush points so streamed
+	// responses are not held back by the compressor.
+	return n, w.enc.Flush()
+}
+
+func (w *compressWriter) WriteChunkedBodyDone() (int, error) {
+	if w.enc == nil {
+		return w.Writer.WriteChunkedBodyDone()
+	}
+	if w.buffered {
+		return 0, response.ErrWriteOrder
+	}
+	if err := w.closeEncoder(); err != nil {
+		return 0, err
+	}
+	return w.Writer.WriteChunkedBodyDone()
+}
+
+// Hijack passes the connection through when the underlying writer can
+// hand it over, so upgrades such as WebSocket work behind the
+// middleware. A compressed body already started is abandoned.
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.Writer.(response.Hijacker)
+	if !ok {
+		return nil, nil, response.ErrNotHijackable
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	if w.enc != nil {
+		w.dropEncoder()
+	}
+	return conn, rw, nil
+}
+
+// finish ends a compressed body the handler left open. For bodies that
+// were chunked to begin with, the server's own finishing writes the last
+// chunk.
+func (w *compressWriter) finish() error {
+	if w.enc == nil {
+		return nil
+	}
+	if err := w.closeEncoder(); err != nil {
+		return err
+	}
+	if w.buffered {
+		_, err := w.Writer.WriteChunkedBodyDone()
+		return err
+	}
+	return nil
+}
+
+// closeEncoder flushes the compressed stream and returns the encoder to
+// its pool. Later writes fall through to the underlying writer, which
+// rejects them once the body is done.
+func (w *compressWriter) closeEncoder() error {
+	err := w.enc.Close()
+	w.dropEncoder()
+	return err
+}
+
+// dropEncoder returns the encoder to its pool without writing whatever
+// it still holds.
+func (w *compressWriter) dropEncoder() {
+	w.enc.Reset(io.Discard)
+	w.pool.Put(w.enc)
+	w.enc = nil
+}
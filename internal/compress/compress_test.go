@@ -0,0 +1,275 @@
+package compress
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"main/internal/headers"
+	"main/internal/request"
+	"main/internal/response"
+	"main/internal/server"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var payload = strings.Repeat(`{"name":"gopher","likes":"compression"},`, 100)
+
+// serve runs handler behind the middleware for a request with the given
+// Accept-Encoding and parses what it wrote.
+func serve(t *testing.T, handler server.Handler, acceptEncoding string, opts ...Option) *http.Response {
+	t.Helper()
+	raw := "GET / HTTP/1.1\r\n"
+	if acceptEncoding != "" {
+		raw += "Accept-Encoding: " + acceptEncoding + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	w := response.NewConnWriter(&buf)
+	Middleware(handler, opts...)(w, req)
+	require.NoError(t, w.Finish())
+
+	resp, err := http.ReadResponse(bufio.NewReader(&buf), nil)
+	require.NoError(t, err)
+	return resp
+}
+
+func jsonHandler(body string) server.Handler {
+	return func(w response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(len(body))
+		h.Set("Content-Type", "application/json")
+		_ = w.WriteStatusLine(response.StatusOK)
+		_ = w.WriteHeaders(h)
+		_, _ = w.WriteBody([]byte(body))
+	}
+}
+
+func gunzip(t *testing.T, r io.Reader) string {
+	t.Helper()
+	zr, err := gzip.NewReader(r)
+	require.NoError(t, err)
+	data, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestCompressBufferedBody(t *testing.T) {
+	// Test: gzip replaces Content-Length with chunked framing
+	resp := serve(t, jsonHandler(payload), "gzip, deflate")
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	assert.Equal(t, int64(-1), resp.ContentLength)
+	assert.Equal(t, payload, gunzip(t, resp.Body))
+
+	// Test: deflate when preferred
+	resp = serve(t, jsonHandler(payload), "gzip;q=0.5, deflate")
+	assert.Equal(t, "deflate", resp.Header.Get("Content-Encoding"))
+	zr, err := zlib.NewReader(resp.Body)
+	require.NoError(t, err)
+	data, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, payload, string(data))
+
+	// Test: Client without Accept-Encoding still gets Vary
+	resp = serve(t, jsonHandler(payload), "")
+	assert.Equal(t, "", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+	assert.Equal(t, int64(len(payload)), resp.ContentLength)
+	data, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, payload, string(data))
+
+	// Test: Small bodies are left alone
+	resp = serve(t, jsonHandler(`{"ok":true}`), "gzip")
+	assert.Equal(t, "", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "", resp.Header.Get("Vary"))
+	assert.Equal(t, int64(11), resp.ContentLength)
+
+	// Test: MinSize is configurable
+	resp = serve(t, jsonHandler(`{"ok":true}`), "gzip", WithMinSize(0))
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, `{"ok":true}`, gunzip(t, resp.Body))
+}
+
+func TestCompressShortBody(t *testing.T) {
+	short := func(w response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(len(payload))
+		h.Set("Content-Type", "application/json")
+		_ = w.WriteStatusLine(response.StatusOK)
+		_ = w.WriteHeaders(h)
+		_, _ = w.WriteBody([]byte(payload[:100]))
+	}
+
+	// Test: A body shorter than its Content-Length aborts the handler
+	// rather than ending the chunked stream
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nAccept-Encoding: gzip\r\n\r\n"))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	w := response.NewConnWriter(&buf)
+	assert.PanicsWithValue(t, server.ErrAbortHandler, func() { Middleware(short)(w, req) })
+	assert.NotContains(t, buf.String(), "\r\n0\r\n\r\n")
+
+	// Test: HEAD responses have no body to fall short
+	req, err = request.RequestFromReader(strings.NewReader("HEAD / HTTP/1.1\r\nAccept-Encoding: gzip\r\n\r\n"), request.WithMethods("HEAD"))
+	require.NoError(t, err)
+	buf.Reset()
+	w = response.NewConnWriter(&buf)
+	w.SuppressBody()
+	assert.NotPanics(t, func() { Middleware(short)(w, req) })
+	require.NoError(t, w.Finish())
+	assert.Contains(t, buf.String(), "content-encoding: gzip\r\n")
+}
+
+func TestCompressChunkedBody(t *testing.T) {
+	// Test: Chunked bodies are compressed and terminated by the middleware
+	handler := func(w response.Writer, req *request.Request) {
+		h := headers.NewHeaders()
+		h.Set("Transfer-Encoding", "chunked")
+		h.Set("Content-Type", "text/plain")
+		_ = w.WriteStatusLine(response.StatusOK)
+		_ = w.WriteHeaders(h)
+		for i := 0; i < 10; i++ {
+			_, _ = w.WriteChunkedBody([]byte(payload[:100]))
+		}
+	}
+	resp := serve(t, handler, "gzip")
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, strings.Repeat(payload[:100], 10), gunzip(t, resp.Body))
+
+	// Test: Explicit WriteChunkedBodyDone
+	handler = func(w response.Writer, req *request.Request) {
+		h := headers.NewHeaders()
+		h.Set("Transfer-Encoding", "chunked")
+		_ = w.WriteStatusLine(response.StatusOK)
+		_ = w.WriteHeaders(h)
+		_, _ = w.WriteChunkedBody([]byte("streamed"))
+		_, _ = w.WriteChunkedBodyDone()
+	}
+	resp = serve(t, handler, "*")
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "streamed", gunzip(t, resp.Body))
+}
+
+func TestCompressSkips(t *testing.T) {
+	// Test: Already-compressed content types
+	handler := func(w response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(len(payload))
+		h.Set("Content-Type", "image/png")
+		_ = w.WriteStatusLine(response.StatusOK)
+		_ = w.WriteHeaders(h)
+		_, _ = w.WriteBody([]byte(payload))
+	}
+	resp := serve(t, handler, "gzip")
+	assert.Equal(t, "", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, int64(len(payload)), resp.ContentLength)
+
+	// Test: Existing Content-Encoding
+	handler = func(w response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(len(payload))
+		h.Set("Content-Encoding", "br")
+		_ = w.WriteStatusLine(response.StatusOK)
+		_ = w.WriteHeaders(h)
+		_, _ = w.WriteBody([]byte(payload))
+	}
+	resp = serve(t, handler, "gzip")
+	assert.Equal(t, "br", resp.Header.Get("Content-Encoding"))
+
+	// Test: Responses without a body
+	handler = func(w response.Writer, req *request.Request) {
+		_ = w.WriteStatusLine(response.StatusNoContent)
+		_ = w.WriteHeaders(headers.NewHeaders())
+	}
+	resp = serve(t, handler, "gzip")
+	assert.Equal(t, 204, resp.StatusCode)
+	assert.Equal(t, "", resp.Header.Get("Content-Encoding"))
+
+	// Test: Partial content keeps its byte ranges
+	handler = func(w response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(len(payload))
+		h.Set("Content-Range", "bytes 0-"+strconv.Itoa(len(payload)-1)+"/"+strconv.Itoa(2*len(payload)))
+		_ = w.WriteStatusLine(response.StatusPartialContent)
+		_ = w.WriteHeaders(h)
+		_, _ = w.WriteBody([]byte(payload))
+	}
+	resp = serve(t, handler, "gzip")
+	assert.Equal(t, 206, resp.StatusCode)
+	assert.Equal(t, "", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, int64(len(payload)), resp.ContentLength)
+}
+
+func TestCompressETag(t *testing.T) {
+	withETag := func(etag string) server.Handler {
+		return func(w response.Writer, req *request.Request) {
+			h := response.GetDefaultHeaders(len(payload))
+			h.Set("ETag", etag)
+			_ = w.WriteStatusLine(response.StatusOK)
+			_ = w.WriteHeaders(h)
+			_, _ = w.WriteBody([]byte(payload))
+		}
+	}
+
+	// Test: A strong ETag is weakened when the body is encoded
+	resp := serve(t, withETag(`"v1"`), "gzip")
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, `W/"v1"`, resp.Header.Get("ETag"))
+
+	// Test: Weak ETags and identity responses keep theirs
+	resp = serve(t, withETag(`W/"v1"`), "gzip")
+	assert.Equal(t, `W/"v1"`, resp.Header.Get("ETag"))
+	resp = serve(t, withETag(`"v1"`), "")
+	assert.Equal(t, `"v1"`, resp.Header.Get("ETag"))
+}
+
+func TestCompressHijack(t *testing.T) {
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nAccept-Encoding: gzip\r\n\r\n"))
+	require.NoError(t, err)
+	hijack := func(w response.Writer, req *request.Request) {
+		hj, ok := w.(response.Hijacker)
+		if !assert.True(t, ok) {
+			return
+		}
+		conn, rw, err := hj.Hijack()
+		if err != nil {
+			_ = response.Error(w, response.StatusInternalServerError, err.Error())
+			return
+		}
+		_, _ = rw.WriteString("raw bytes")
+		_ = rw.Flush()
+		conn.Close()
+	}
+
+	// Test: The connection passes through the middleware untouched
+	serverConn, clientConn := net.Pipe()
+	w := response.NewServerConnWriter(serverConn, bufio.NewReader(serverConn))
+	go Middleware(hijack)(w, req)
+	got, err := io.ReadAll(clientConn)
+	require.NoError(t, err)
+	assert.Equal(t, "raw bytes", string(got))
+	assert.True(t, w.Hijacked())
+
+	// Test: Writers that cannot be hijacked still say so
+	resp := serve(t, hijack, "gzip")
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+}
+
+func TestNegotiate(t *testing.T) {
+	assert.Equal(t, "gzip", negotiate("gzip"))
+	assert.Equal(t, "gzip", negotiate("deflate, gzip"))
+	assert.Equal(t, "deflate", negotiate("deflate"))
+	assert.Equal(t, "deflate", negotiate("gzip;q=0.2, deflate;q=0.8"))
+	assert.Equal(t, "", negotiate("gzip;q=0, deflate;q=0"))
+	assert.Equal(t, "", negotiate("br, identity"))
+	assert.Equal(t, "", negotiate(""))
+	assert.Equal(t, "gzip", negotiate("br, *"))
+	assert.Equal(t, "deflate", negotiate("gzip;q=0, *"))
+}
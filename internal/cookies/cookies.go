@@ -0,0 +1,213 @@
+package cookies
+
+import (
+	"fmt"
+	"main/internal/headers"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type SameSite int
+
+const (
+	// SameSiteDefault leaves the attribute out and lets the browser decide.
+	SameSiteDefault SameSite = iota
+	SameSiteLax
+	SameSiteStrict
+	SameSiteNone
+)
+
+func (s SameSite) String() string {
+	switch s {
+	case SameSiteLax:
+		return "Lax"
+	case SameSiteStrict:
+		return "Strict"
+	case SameSiteNone:
+		return "None"
+	default:
+		return ""
+	}
+}
+
+// Cookie is a single cookie. Parse only fills in Name and Value; the
+// remaining fields are attributes sent with Set-Cookie.
+type Cookie struct {
+	Name  string
+	Value string
+
+	Path    string
+	Domain  string
+	Expires time.Time
+	// MaxAge of 0 omits the attribute, and a negative MaxAge deletes the
+	// cookie by sending Max-Age=0.
+	MaxAge      int
+	Secure      bool
+	HttpOnly    bool
+	SameSite    SameSite
+	Partitioned bool
+}
+
+// Parse returns the cookies sent in the Cookie field of h, in order.
+// Pairs with an invalid name or value are skipped.
+func Parse(h headers.Headers) []Cookie {
+	return ParseString(h.Get("Cookie"))
+}
+
+// ParseString parses the value of a Cookie field, e.g. "a=1; b=2".
+func ParseString(line string) []Cookie {
+	var cookies []Cookie
+	for _, pair := range strings.Split(line, ";") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, value, found := strings.Cut(pair, "=")
+		if !found || !headers.IsToken(name) {
+			continue
+		}
+		value, ok := unquote(value)
+		if !ok || !validValue(value) {
+			continue
+		}
+		cookies = append(cookies, Cookie{Name: name, Value: value})
+	}
+	return cookies
+}
+
+// Set validates c and adds it to h as a Set-Cookie field. Repeated calls
+// add separate Set-Cookie lines.
+func Set(h headers.Headers, c *Cookie) error {
+	v, err := c.SetCookieValue()
+	if err != nil {
+		return err
+	}
+	h.Add("Set-Cookie", v)
+	return nil
+}
+
+// SetCookieValue renders c as the value of a Set-Cookie field.
+func (c *Cookie) SetCookieValue() (string, error) {
+	if err := c.Validate(); err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	b.WriteString(c.Name)
+	b.WriteByte('=')
+	b.WriteString(c.Value)
+	if c.Path != "" {
+		b.WriteString("; Path=")
+		b.WriteString(c.Path)
+	}
+	if c.Domain != "" {
+		b.WriteString("; Domain=")
+		b.WriteString(strings.TrimPrefix(c.Domain, "."))
+	}
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=")
+		b.WriteString(c.Expires.UTC().Format(TimeFormat))
+	}
+	if c.MaxAge > 0 {
+		b.WriteString("; Max-Age=")
+		b.WriteString(strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		b.WriteString("; Max-Age=0")
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	if c.SameSite != SameSiteDefault {
+		b.WriteString("; SameSite=")
+		b.WriteString(c.SameSite.String())
+	}
+	if c.Partitioned {
+		b.WriteString("; Partitioned")
+	}
+	return b.String(), nil
+}
+
+// TimeFormat is the IMF-fixdate layout used by Expires.
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// Validate checks c against the RFC 6265 Set-Cookie grammar.
+func (c *Cookie) Validate() error {
+	if !headers.IsToken(c.Name) {
+		return fmt.Errorf("invalid cookie name: %q", c.Name)
+	}
+	if !validValue(c.Value) {
+		return fmt.Errorf("invalid cookie value for %s: %q", c.Name, c.Value)
+	}
+	if !validPath(c.Path) {
+		return fmt.Errorf("invalid cookie path for %s: %q", c.Name, c.Path)
+	}
+	if c.Domain != "" && !validDomain(strings.TrimPrefix(c.Domain, ".")) {
+		return fmt.Errorf("invalid cookie domain for %s: %q", c.Name, c.Domain)
+	}
+	if !c.Expires.IsZero() && c.Expires.Year() < 1601 {
+		return fmt.Errorf("invalid cookie expiry for %s: %v", c.Name, c.Expires)
+	}
+	if c.SameSite < SameSiteDefault || c.SameSite > SameSiteNone {
+		return fmt.Errorf("invalid SameSite value for %s: %d", c.Name, int(c.SameSite))
+	}
+	if c.SameSite == SameSiteNone && !c.Secure {
+		return fmt.Errorf("cookie %s: SameSite=None requires Secure", c.Name)
+	}
+	if c.Partitioned && !c.Secure {
+		return fmt.Errorf("cookie %s: Partitioned requires Secure", c.Name)
+	}
+	return nil
+}
+
+func unquote(v string) (string, bool) {
+	if len(v) > 0 && v[0] == '"' {
+		if len(v) < 2 || v[len(v)-1] != '"' {
+			return "", false
+		}
+		return v[1 : len(v)-1], true
+	}
+	return v, true
+}
+
+// validValue reports whether v is made of cookie-octets: visible US-ASCII
+// excluding DQUOTE, comma, semicolon and backslash.
+func validValue(v string) bool {
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if c < 0x21 || c > 0x7e || c == '"' || c == ',' || c == ';' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+func validPath(p string) bool {
+	for i := 0; i < len(p); i++ {
+		if c := p[i]; c < 0x20 || c >= 0x7f || c == ';' {
+			return false
+		}
+	}
+	return true
+}
+
+func validDomain(d string) bool {
+	if d == "" || len(d) > 255 {
+		return false
+	}
+	for _, label := range strings.Split(d, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,100 @@
+package cookies
+
+import (
+	"main/internal/headers"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	// Test: Multiple pairs
+	h := headers.NewHeaders()
+	h["cookie"] = "session=abc123; theme=dark"
+	cookies := Parse(h)
+	require.Len(t, cookies, 2)
+	assert.Equal(t, Cookie{Name: "session", Value: "abc123"}, cookies[0])
+	assert.Equal(t, Cookie{Name: "theme", Value: "dark"}, cookies[1])
+
+	// Test: No Cookie field
+	assert.Empty(t, Parse(headers.NewHeaders()))
+
+	// Test: Quoted and empty values
+	cookies = ParseString(`a="quoted"; b=`)
+	require.Len(t, cookies, 2)
+	assert.Equal(t, "quoted", cookies[0].Value)
+	assert.Equal(t, "", cookies[1].Value)
+
+	// Test: Invalid pairs are skipped
+	cookies = ParseString("ok=1; bad name=2; noequals; x=has space; y=\"open; z=3")
+	require.Len(t, cookies, 2)
+	assert.Equal(t, "ok", cookies[0].Name)
+	assert.Equal(t, "z", cookies[1].Name)
+}
+
+func TestSetCookieValue(t *testing.T) {
+	// Test: Name and value only
+	c := &Cookie{Name: "id", Value: "42"}
+	v, err := c.SetCookieValue()
+	require.NoError(t, err)
+	assert.Equal(t, "id=42", v)
+
+	// Test: All attributes
+	c = &Cookie{
+		Name:        "session",
+		Value:       "abc",
+		Path:        "/",
+		Domain:      ".example.com",
+		Expires:     time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC),
+		MaxAge:      3600,
+		Secure:      true,
+		HttpOnly:    true,
+		SameSite:    SameSiteNone,
+		Partitioned: true,
+	}
+	v, err = c.SetCookieValue()
+	require.NoError(t, err)
+	assert.Equal(t, "session=abc; Path=/; Domain=example.com; Expires=Wed, 21 Oct 2015 07:28:00 GMT; Max-Age=3600; Secure; HttpOnly; SameSite=None; Partitioned", v)
+
+	// Test: Negative MaxAge deletes
+	c = &Cookie{Name: "id", MaxAge: -1, SameSite: SameSiteStrict}
+	v, err = c.SetCookieValue()
+	require.NoError(t, err)
+	assert.Equal(t, "id=; Max-Age=0; SameSite=Strict", v)
+}
+
+func TestValidate(t *testing.T) {
+	invalid := []*Cookie{
+		{Name: "", Value: "x"},
+		{Name: "bad name", Value: "x"},
+		{Name: "a", Value: "semi;colon"},
+		{Name: "a", Value: "comma,"},
+		{Name: "a", Value: "ünicode"},
+		{Name: "a", Path: "/x;y"},
+		{Name: "a", Domain: "bad_domain.com"},
+		{Name: "a", Domain: "-example.com"},
+		{Name: "a", Expires: time.Date(1600, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Name: "a", SameSite: SameSiteNone},
+		{Name: "a", Partitioned: true},
+		{Name: "a", SameSite: SameSite(9)},
+	}
+	for _, c := range invalid {
+		assert.Error(t, c.Validate(), "%+v", c)
+	}
+
+	assert.NoError(t, (&Cookie{Name: "a", Value: "b", Domain: "sub.example.com", Path: "/x y"}).Validate())
+}
+
+func TestSet(t *testing.T) {
+	// Test: Separate Set-Cookie lines
+	h := headers.NewHeaders()
+	require.NoError(t, Set(h, &Cookie{Name: "a", Value: "1", Expires: time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC)}))
+	require.NoError(t, Set(h, &Cookie{Name: "b", Value: "2", HttpOnly: true}))
+	assert.Equal(t, []string{"a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT", "b=2; HttpOnly"}, h.Values("Set-Cookie"))
+
+	// Test: Invalid cookies are not added
+	require.Error(t, Set(h, &Cookie{Name: "bad name"}))
+	assert.Len(t, h.Values("Set-Cookie"), 2)
+}
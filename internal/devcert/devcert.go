@@ -0,0 +1,227 @@
+package devcert
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"main/internal/server"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	caCertFile = "ca.crt"
+	caKeyFile  = "ca.key"
+
+	caValidity = 10 * 365 * 24 * time.Hour
+	// leafValidity stays under the 825 days some clients accept for
+	// server certificates.
+	leafValidity = 800 * 24 * time.Hour
+	// renewBefore is how close to expiry a cached certificate is replaced.
+	renewBefore = 30 * 24 * time.Hour
+)
+
+// DefaultHosts are the names a development server is reached by.
+var DefaultHosts = []string{"localhost", "127.0.0.1", "::1"}
+
+// DefaultDir is where certificates are cached unless told otherwise: a
+// directory in the user's cache directory.
+func DefaultDir() (string, error) {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cache, "tcptohttp", "devcert"), nil
+}
+
+// CA is a private certificate authority for local HTTPS testing. It is
+// created once and cached on disk, so trusting it once covers every
+// certificate it issues later.
+type CA struct {
+	Cert *x509.Certificate
+	key  crypto.Signer
+	// CertFile is the path of the CA certificate, which is what clients
+	// need to trust.
+	CertFile string
+}
+
+// LoadCA loads the CA cached in dir, creating dir and the CA if needed.
+func LoadCA(dir string) (*CA, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	certPath := filepath.Join(dir, caCertFile)
+	keyPath := filepath.Join(dir, caKeyFile)
+
+	pair, err := tls.LoadX509KeyPair(certPath, keyPath)
+	switch {
+	case err == nil:
+		signer, ok := pair.PrivateKey.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported CA key in %s", keyPath)
+		}
+		return &CA{Cert: pair.Leaf, key: signer, CertFile: certPath}, nil
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, fmt.Errorf("error loading CA: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"tcptohttp development CA"}, CommonName: "tcptohttp development CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := writePair(certPath, keyPath, der, key); err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &CA{Cert: cert, key: key, CertFile: certPath}, nil
+}
+
+// Issue returns the files of a certificate for hosts, which may be DNS
+// names, wildcards such as "*.example.test", or IP addresses. A cached
+// certificate is reused while it is signed by ca, covers every host and
+// is not close to expiry; otherwise a new one is written over it.
+func (ca *CA) Issue(dir string, hosts ...string) (server.CertFiles, error) {
+	if len(hosts) == 0 {
+		return server.CertFiles{}, errors.New("no hosts given")
+	}
+	name := fileName(hosts)
+	files := server.CertFiles{
+		CertFile: filepath.Join(dir, name+".crt"),
+		KeyFile:  filepath.Join(dir, name+".key"),
+	}
+	if ca.cached(files, hosts) {
+		return files, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return server.CertFiles{}, err
+	}
+	serial, err := serialNumber()
+	if err != nil {
+		return server.CertFiles{}, err
+	}
+	now := time.Now()
+	notAfter := now.Add(leafValidity)
+	if notAfter.After(ca.Cert.NotAfter) {
+		notAfter = ca.Cert.NotAfter
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"tcptohttp development certificate"}},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.Cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return server.CertFiles{}, err
+	}
+	if err := writePair(files.CertFile, files.KeyFile, der, key); err != nil {
+		return server.CertFiles{}, err
+	}
+	return files, nil
+}
+
+// Ensure loads or creates the CA in dir and returns a certificate for
+// hosts signed by it, ready for server.LoadCertificates.
+func Ensure(dir string, hosts ...string) (server.CertFiles, error) {
+	ca, err := LoadCA(dir)
+	if err != nil {
+		return server.CertFiles{}, err
+	}
+	return ca.Issue(dir, hosts...)
+}
+
+// cached reports whether files hold a usable certificate for hosts.
+func (ca *CA) cached(files server.CertFiles, hosts []string) bool {
+	pair, err := tls.LoadX509KeyPair(files.CertFile, files.KeyFile)
+	if err != nil {
+		return false
+	}
+	leaf := pair.Leaf
+	if time.Until(leaf.NotAfter) < renewBefore || leaf.CheckSignatureFrom(ca.Cert) != nil {
+		return false
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			if !slices.ContainsFunc(leaf.IPAddresses, ip.Equal) {
+				return false
+			}
+		} else if !slices.Contains(leaf.DNSNames, h) {
+			return false
+		}
+	}
+	return true
+}
+
+// fileName names the files of a certificate after its first host, with
+// a count of the others, as in "localhost+2".
+func fileName(hosts []string) string {
+	name := strings.NewReplacer("*", "_wildcard", ":", "_", "/", "_").Replace(hosts[0])
+	if len(hosts) > 1 {
+		name += "+" + strconv.Itoa(len(hosts)-1)
+	}
+	return name
+}
+
+func serialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// writePair writes a DER certificate and its key as PEM. The key is only
+// readable by the owner.
+func writePair(certPath, keyPath string, der []byte, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return err
+	}
+	return os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644)
+}
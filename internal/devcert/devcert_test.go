@@ -0,0 +1,103 @@
+package devcert
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"main/internal/request"
+	"main/internal/response"
+	"main/internal/server"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func loadLeaf(t *testing.T, files server.CertFiles) *x509.Certificate {
+	t.Helper()
+	pair, err := tls.LoadX509KeyPair(files.CertFile, files.KeyFile)
+	require.NoError(t, err)
+	return pair.Leaf
+}
+
+func TestEnsure(t *testing.T) {
+	dir := t.TempDir()
+
+	// Test: The CA and a leaf for DNS names and IPs are created
+	files, err := Ensure(dir, "localhost", "*.example.test", "127.0.0.1", "::1")
+	require.NoError(t, err)
+	assert.FileExists(t, files.CertFile)
+	assert.Equal(t, "localhost+3", fileName([]string{"localhost", "*.example.test", "127.0.0.1", "::1"}))
+	leaf := loadLeaf(t, files)
+	assert.Equal(t, []string{"localhost", "*.example.test"}, leaf.DNSNames)
+	assert.Len(t, leaf.IPAddresses, 2)
+
+	ca, err := LoadCA(dir)
+	require.NoError(t, err)
+	assert.True(t, ca.Cert.IsCA)
+	require.NoError(t, leaf.CheckSignatureFrom(ca.Cert))
+
+	// Test: Private keys are only readable by the owner
+	info, err := os.Stat(files.KeyFile)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	// Test: Cached certificates are reused
+	again, err := Ensure(dir, "localhost", "*.example.test", "127.0.0.1", "::1")
+	require.NoError(t, err)
+	assert.Equal(t, files, again)
+	assert.Equal(t, leaf.SerialNumber, loadLeaf(t, again).SerialNumber)
+
+	// Test: A cached certificate signed by another CA is replaced
+	other := t.TempDir()
+	foreign, err := Ensure(other, "localhost")
+	require.NoError(t, err)
+	cert, err := os.ReadFile(foreign.CertFile)
+	require.NoError(t, err)
+	key, err := os.ReadFile(foreign.KeyFile)
+	require.NoError(t, err)
+	mine, err := ca.Issue(dir, "localhost")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(mine.CertFile, cert, 0o644))
+	require.NoError(t, os.WriteFile(mine.KeyFile, key, 0o600))
+	mine, err = ca.Issue(dir, "localhost")
+	require.NoError(t, err)
+	require.NoError(t, loadLeaf(t, mine).CheckSignatureFrom(ca.Cert))
+
+	// Test: Hosts are required
+	_, err = Ensure(dir)
+	assert.Error(t, err)
+}
+
+func TestEnsureServesTLS(t *testing.T) {
+	dir := t.TempDir()
+	files, err := Ensure(dir, "localhost", "127.0.0.1")
+	require.NoError(t, err)
+	certs, err := server.LoadCertificates(files)
+	require.NoError(t, err)
+	s, err := server.ServeTLS(0, func(w response.Writer, req *request.Request) {
+		_ = response.Error(w, response.StatusOK, "trusted")
+	}, certs)
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: Clients trusting the CA accept the server
+	ca, err := LoadCA(dir)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Cert)
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(s.Addr().(*net.TCPAddr).Port))
+	conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: pool})
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nConnection: close\r\n\r\n")
+	require.NoError(t, err)
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "trusted")
+}
@@ -0,0 +1,408 @@
+package fileserver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"main/internal/headers"
+	"main/internal/request"
+	"main/internal/response"
+	"main/internal/server"
+	"mime"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeFormat is the IMF-fixdate format of Last-Modified and the
+// conditional request headers.
+const timeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// sniffLen is how much of a file is inspected to guess its type.
+const sniffLen = 512
+
+type config struct {
+	listDirs bool
+	index    string
+}
+
+// Option configures the file server.
+type Option func(*config)
+
+// WithDirectoryListing renders an HTML index of directories that have no
+// index file. Without it such directories are answered with 404.
+func WithDirectoryListing() Option {
+	return func(c *config) {
+		c.listDirs = true
+	}
+}
+
+// WithIndex sets the file served for a directory, "index.html" by
+// default. An empty name disables index files.
+func WithIndex(name string) Option {
+	return func(c *config) {
+		c.index = name
+	}
+}
+
+// Handler serves the files of fsys, mapping the request path onto it.
+// Only GET and HEAD are answered; the server must be configured with
+// request.WithMethods("HEAD") for the latter to reach it.
+func Handler(fsys fs.FS, opts ...Option) server.Handler {
+	cfg := &config{index: "index.html"}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	return func(w response.Writer, req *request.Request) {
+		method := req.RequestLine.Method
+		if method != "GET" && method != "HEAD" {
+			writeError(w, response.StatusMethodNotAllowed, headers.Headers{"allow": "GET, HEAD"})
+			return
+		}
+
+		urlPath, query, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+		name, ok := fsPath(urlPath)
+		if !ok {
+			writeError(w, response.StatusBadRequest, nil)
+			return
+		}
+		serve(w, req, fsys, cfg, name, urlPath, query)
+	}
+}
+
+// fsPath turns a request path into an fs.FS name. Paths with a ".."
+// segment are refused outright rather than cleaned, so a request can
+// never name anything outside the root.
+func fsPath(urlPath string) (string, bool) {
+	p, err := url.PathUnescape(urlPath)
+	if err != nil || !strings.HasPrefix(p, "/") || strings.ContainsAny(p, "\x00\\") {
+		return "", false
+	}
+	for _, seg := range strings.Split(p, "/") {
+		if seg == ".." {
+			return "", false
+		}
+	}
+	name := strings.TrimPrefix(path.Clean(p), "/")
+	if name == "" {
+		name = "."
+	}
+	return name, fs.ValidPath(name)
+}
+
+func serve(w response.Writer, req *request.Request, fsys fs.FS, cfg *config, name, urlPath, query string) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		writeError(w, openErrorStatus(err), nil)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		writeError(w, openErrorStatus(err), nil)
+		return
+	}
+
+	if info.IsDir() {
+		if !strings.HasSuffix(urlPath, "/") {
+			// Relative to the directory's parent, so a path such as
+			// "//evil.example" cannot become a redirect to another host.
+			target := path.Base(urlPath) + "/"
+			if query != "" {
+				target += "?" + query
+			}
+			redirect(w, target)
+			return
+		}
+		if cfg.index != "" {
+			index := path.Join(name, cfg.index)
+			if fi, err := fs.Stat(fsys, index); err == nil && !fi.IsDir() {
+				serve(w, req, fsys, cfg, index, urlPath, query)
+				return
+			}
+		}
+		if !cfg.listDirs {
+			writeError(w, response.StatusNotFound, nil)
+			return
+		}
+		listDir(w, fsys, name, urlPath)
+		return
+	}
+
+	serveContent(w, req, f, info)
+}
+
+func openErrorStatus(err error) response.StatusCode {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return response.StatusNotFound
+	case errors.Is(err, fs.ErrPermission):
+		return response.StatusForbidden
+	default:
+		return response.StatusInternalServerError
+	}
+}
+
+// serveContent answers for a regular file, taking the conditional and
+// range headers of req into account.
+func serveContent(w response.Writer, req *request.Request, f fs.File, info fs.FileInfo) {
+	modTime := info.ModTime().UTC().Truncate(time.Second)
+	etag := fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+
+	h := headers.NewHeaders()
+	h.Set("Accept-Ranges", "bytes")
+	h.Set("ETag", etag)
+	if !modTime.IsZero() {
+		h.Set("Last-Modified", modTime.Format(timeFormat))
+	}
+
+	switch checkPreconditions(req.Headers, etag, modTime) {
+	case response.StatusNotModified:
+		writeHead(w, response.StatusNotModified, h)
+		return
+	case response.StatusPreconditionFailed:
+		writeError(w, response.StatusPreconditionFailed, nil)
+		return
+	}
+
+	content, sniffed, err := contentType(f, info.Name())
+	if err != nil {
+		writeError(w, response.StatusInternalServerError, nil)
+		return
+	}
+	h.Set("Content-Type", content)
+
+	size := info.Size()
+	seeker, seekable := f.(io.ReadSeeker)
+	var ranges []byteRange
+	if rangeHeader := req.Headers.Get("Range"); rangeHeader != "" && seekable && rangeApplies(req.Headers.Get("If-Range"), etag, modTime) {
+		// A malformed Range leaves ranges empty and is ignored.
+		ranges, err = parseRange(rangeHeader, size)
+		if err == errUnsatisfiableRange {
+			writeError(w, response.StatusRequestedRangeNotSatisfiable,
+				headers.Headers{"content-range": fmt.Sprintf("bytes */%d", size)})
+			return
+		}
+	}
+	if len(ranges) == 0 {
+		h.Set("Content-Length", strconv.FormatInt(size, 10))
+		writeHead(w, response.StatusOK, h)
+		if req.RequestLine.Method == "HEAD" {
+			return
+		}
+		_, _ = io.Copy(response.NewBodyWriter(w), io.MultiReader(bytes.NewReader(sniffed), f))
+		return
+	}
+
+	if len(ranges) == 1 {
+		r := ranges[0]
+		h.Set("Content-Range", r.contentRange(size))
+		h.Set("Content-Length", strconv.FormatInt(r.length, 10))
+		writeHead(w, response.StatusPartialContent, h)
+		if req.RequestLine.Method == "HEAD" {
+			return
+		}
+		_ = copyRange(w, seeker, r)
+		return
+	}
+
+	mw := newMultipartRanges(ranges, content, size)
+	h.Set("Content-Type", mw.contentType())
+	h.Set("Content-Length", strconv.FormatInt(mw.length(), 10))
+	writeHead(w, response.StatusPartialContent, h)
+	if req.RequestLine.Method == "HEAD" {
+		return
+	}
+	_ = mw.writeTo(w, seeker)
+}
+
+// checkPreconditions evaluates the conditional headers in the order of
+// RFC 9110 13.2.2 and returns the status that short-circuits the
+// request, or 0 to serve it normally.
+func checkPreconditions(h headers.Headers, etag string, modTime time.Time) response.StatusCode {
+	if im := h.Get("If-Match"); im != "" {
+		if !etagMatch(im, etag, false) {
+			return response.StatusPreconditionFailed
+		}
+	} else if ius, err := time.Parse(timeFormat, h.Get("If-Unmodified-Since")); err == nil {
+		if modTime.After(ius) {
+			return response.StatusPreconditionFailed
+		}
+	}
+
+	if inm := h.Get("If-None-Match"); inm != "" {
+		if etagMatch(inm, etag, true) {
+			return response.StatusNotModified
+		}
+	} else if ims, err := time.Parse(timeFormat, h.Get("If-Modified-Since")); err == nil {
+		if !modTime.IsZero() && !modTime.After(ims) {
+			return response.StatusNotModified
+		}
+	}
+	return 0
+}
+
+// etagMatch reports whether etag is in the comma-separated list, or the
+// list is "*". Weak comparison ignores the W/ prefix.
+func etagMatch(list, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// rangeApplies reports whether Range should be honoured given If-Range,
+// which holds either a strong ETag or the exact Last-Modified date.
+func rangeApplies(ifRange, etag string, modTime time.Time) bool {
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) {
+		return ifRange == etag
+	}
+	t, err := time.Parse(timeFormat, ifRange)
+	return err == nil && !modTime.IsZero() && t.Equal(modTime)
+}
+
+// contentType picks a media type from the file extension, falling back
+// to sniffing the first bytes of f. The bytes read for sniffing are
+// returned so they can be sent ahead of the rest of f.
+func contentType(f fs.File, name string) (string, []byte, error) {
+	if ct := mime.TypeByExtension(path.Ext(name)); ct != "" {
+		return ct, nil, nil
+	}
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", nil, err
+	}
+	buf = buf[:n]
+	if s, ok := f.(io.Seeker); ok {
+		if _, err := s.Seek(0, io.SeekStart); err != nil {
+			return "", nil, err
+		}
+		return sniff(buf), nil, nil
+	}
+	return sniff(buf), buf, nil
+}
+
+var signatures = []struct {
+	prefix      string
+	contentType string
+}{
+	{"\x89PNG\r\n\x1a\n", "image/png"},
+	{"\xff\xd8\xff", "image/jpeg"},
+	{"GIF87a", "image/gif"},
+	{"GIF89a", "image/gif"},
+	{"%PDF-", "application/pdf"},
+	{"PK\x03\x04", "application/zip"},
+	{"\x1f\x8b\x08", "application/gzip"},
+	{"\x00asm", "application/wasm"},
+	{"wOFF", "font/woff"},
+	{"wOF2", "font/woff2"},
+}
+
+// sniff guesses the media type of content from its first bytes: a few
+// well-known binary signatures, then HTML and XML markers, then text if
+// nothing looks binary.
+func sniff(data []byte) string {
+	for _, sig := range signatures {
+		if bytes.HasPrefix(data, []byte(sig.prefix)) {
+			return sig.contentType
+		}
+	}
+
+	trimmed := bytes.TrimLeft(data, "\t\n\x0c\r ")
+	lower := bytes.ToLower(trimmed[:min(len(trimmed), 14)])
+	for _, tag := range []string{"<!doctype html", "<html", "<head", "<body", "<script", "<p>", "<div", "<table"} {
+		if bytes.HasPrefix(lower, []byte(tag)) {
+			return "text/html; charset=utf-8"
+		}
+	}
+	if bytes.HasPrefix(lower, []byte("<?xml")) {
+		return "text/xml; charset=utf-8"
+	}
+
+	for _, b := range data {
+		if b < 0x20 && b != '\t' && b != '\n' && b != '\r' && b != 0x0c && b != 0x1b {
+			return "application/octet-stream"
+		}
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// listDir renders the entries of the directory name as links.
+func listDir(w response.Writer, fsys fs.FS, name, urlPath string) {
+	entries, err := fs.ReadDir(fsys, name)
+	if err != nil {
+		writeError(w, openErrorStatus(err), nil)
+		return
+	}
+
+	var buf bytes.Buffer
+	title := html.EscapeString(urlPath)
+	fmt.Fprintf(&buf, "<!doctype html>\n<html>\n<head><meta charset=\"utf-8\"><title>Index of %s</title></head>\n<body>\n<h1>Index of %s</h1>\n<ul>\n", title, title)
+	if urlPath != "/" {
+		buf.WriteString("<li><a href=\"../\">../</a></li>\n")
+	}
+	for _, entry := range entries {
+		display := entry.Name()
+		if entry.IsDir() {
+			display += "/"
+		}
+		href := (&url.URL{Path: display}).EscapedPath()
+		// A name with a colon would otherwise be read as a URL scheme.
+		if strings.Contains(display, ":") {
+			href = "./" + href
+		}
+		fmt.Fprintf(&buf, "<li><a href=\"%s\">%s</a></li>\n", html.EscapeString(href), html.EscapeString(display))
+	}
+	buf.WriteString("</ul>\n</body>\n</html>\n")
+
+	h := headers.NewHeaders()
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("Content-Length", strconv.Itoa(buf.Len()))
+	writeHead(w, response.StatusOK, h)
+	_, _ = w.WriteBody(buf.Bytes())
+}
+
+func redirect(w response.Writer, target string) {
+	h := headers.NewHeaders()
+	h.Set("Location", target)
+	h.Set("Content-Length", "0")
+	writeHead(w, response.StatusMovedPermanently, h)
+}
+
+func writeHead(w response.Writer, code response.StatusCode, h headers.Headers) {
+	if err := w.WriteStatusLine(code); err != nil {
+		return
+	}
+	_ = w.WriteHeaders(h)
+}
+
+// writeError sends the reason phrase of code as a plain-text body, with
+// any extra headers added.
+func writeError(w response.Writer, code response.StatusCode, extra headers.Headers) {
+	message := response.StatusText(code) + "\n"
+	h := response.GetDefaultHeaders(len(message))
+	for key, value := range extra {
+		h.Set(key, value)
+	}
+	writeHead(w, code, h)
+	_, _ = w.WriteBody([]byte(message))
+}
@@ -0,0 +1,282 @@
+package fileserver
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/fs"
+	"main/internal/request"
+	"main/internal/response"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var modTime = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+var testFS = fstest.MapFS{
+	"hello.txt":         {Data: []byte("hello, world!"), ModTime: modTime},
+	"style.css":         {Data: []byte("body{}"), ModTime: modTime},
+	"noext":             {Data: []byte("<!DOCTYPE html><p>hi</p>"), ModTime: modTime},
+	"site/index.html":   {Data: []byte("<h1>home</h1>"), ModTime: modTime},
+	"docs/a b.txt":      {Data: []byte("a"), ModTime: modTime},
+	"docs/<script>.txt": {Data: []byte("b"), ModTime: modTime},
+	"docs/nested/c.txt": {Data: []byte("c"), ModTime: modTime},
+}
+
+// get runs h for a raw request head and parses what it wrote.
+func get(t *testing.T, h func(response.Writer, *request.Request), method, target string, extra ...string) *http.Response {
+	t.Helper()
+	raw := method + " " + target + " HTTP/1.1\r\n" + strings.Join(extra, "") + "\r\n"
+	req, err := request.RequestFromReader(strings.NewReader(raw), request.WithMethods("HEAD", "PUT"))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	w := response.NewConnWriter(&buf)
+	if method == "HEAD" {
+		w.SuppressBody()
+	}
+	h(w, req)
+	require.NoError(t, w.Finish())
+
+	resp, err := http.ReadResponse(bufio.NewReader(&buf), &http.Request{Method: method})
+	require.NoError(t, err)
+	return resp
+}
+
+func readBody(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestFileServer(t *testing.T) {
+	h := Handler(testFS)
+
+	// Test: Plain file with type, validators and length
+	resp := get(t, h, "GET", "/hello.txt")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "hello, world!", readBody(t, resp))
+	assert.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "Fri, 01 Mar 2024 12:00:00 GMT", resp.Header.Get("Last-Modified"))
+	assert.Equal(t, "bytes", resp.Header.Get("Accept-Ranges"))
+	assert.Equal(t, int64(13), resp.ContentLength)
+	etag := resp.Header.Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	// Test: Type by extension
+	resp = get(t, h, "GET", "/style.css")
+	assert.Equal(t, mime.TypeByExtension(".css"), resp.Header.Get("Content-Type"))
+
+	// Test: Type by sniffing, with the sniffed bytes still sent
+	resp = get(t, h, "GET", "/noext")
+	assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "<!DOCTYPE html><p>hi</p>", readBody(t, resp))
+
+	// Test: HEAD sends headers only
+	resp = get(t, h, "HEAD", "/hello.txt")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, int64(13), resp.ContentLength)
+	assert.Equal(t, "", readBody(t, resp))
+
+	// Test: Other methods are not allowed
+	resp = get(t, h, "PUT", "/hello.txt")
+	assert.Equal(t, 405, resp.StatusCode)
+	assert.Equal(t, "GET, HEAD", resp.Header.Get("Allow"))
+
+	// Test: Missing file
+	resp = get(t, h, "GET", "/missing.txt")
+	assert.Equal(t, 404, resp.StatusCode)
+
+	// Test: Traversal is refused, escaped or not
+	for _, target := range []string{"/../etc/passwd", "/docs/../../x", "/%2e%2e/x", "/docs/..%2fhello.txt", "/a%5c..%5cb"} {
+		resp = get(t, h, "GET", target)
+		assert.Equal(t, 400, resp.StatusCode, target)
+	}
+
+	// Test: Escaped names are decoded
+	resp = get(t, h, "GET", "/docs/a%20b.txt")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "a", readBody(t, resp))
+
+	// Test: Directory without trailing slash is redirected, keeping the query
+	resp = get(t, h, "GET", "/site?x=1")
+	assert.Equal(t, 301, resp.StatusCode)
+	assert.Equal(t, "site/?x=1", resp.Header.Get("Location"))
+
+	// Test: The redirect stays on this host whatever the path looks like
+	resp = get(t, h, "GET", "//site")
+	assert.Equal(t, 301, resp.StatusCode)
+	assert.Equal(t, "site/", resp.Header.Get("Location"))
+
+	// Test: Directory index file
+	resp = get(t, h, "GET", "/site/")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "<h1>home</h1>", readBody(t, resp))
+	assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+
+	// Test: Directory without index and listing disabled
+	resp = get(t, h, "GET", "/docs/")
+	assert.Equal(t, 404, resp.StatusCode)
+}
+
+func TestDirectoryListing(t *testing.T) {
+	h := Handler(testFS, WithDirectoryListing())
+
+	// Test: Entries are listed with escaped names and links
+	resp := get(t, h, "GET", "/docs/")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+	body := readBody(t, resp)
+	assert.Contains(t, body, `<a href="../">../</a>`)
+	assert.Contains(t, body, `<a href="a%20b.txt">a b.txt</a>`)
+	assert.Contains(t, body, `<a href="nested/">nested/</a>`)
+	assert.Contains(t, body, `&lt;script&gt;.txt</a>`)
+	assert.NotContains(t, body, "<script>")
+
+	// Test: Index file still wins over the listing
+	resp = get(t, h, "GET", "/site/")
+	assert.Equal(t, "<h1>home</h1>", readBody(t, resp))
+
+	// Test: WithIndex("") lists even directories with an index file
+	resp = get(t, Handler(testFS, WithDirectoryListing(), WithIndex("")), "GET", "/site/")
+	assert.Contains(t, readBody(t, resp), `<a href="index.html">index.html</a>`)
+}
+
+func TestConditionalRequests(t *testing.T) {
+	h := Handler(testFS)
+	etag := get(t, h, "GET", "/hello.txt").Header.Get("ETag")
+
+	// Test: Matching If-None-Match gives 304 with validators
+	resp := get(t, h, "GET", "/hello.txt", "If-None-Match: \"other\", "+etag+"\r\n")
+	assert.Equal(t, 304, resp.StatusCode)
+	assert.Equal(t, etag, resp.Header.Get("ETag"))
+	assert.Equal(t, "", readBody(t, resp))
+
+	// Test: Weak comparison for If-None-Match
+	resp = get(t, h, "GET", "/hello.txt", "If-None-Match: W/"+etag+"\r\n")
+	assert.Equal(t, 304, resp.StatusCode)
+
+	// Test: Non-matching If-None-Match serves the file
+	resp = get(t, h, "GET", "/hello.txt", "If-None-Match: \"other\"\r\n")
+	assert.Equal(t, 200, resp.StatusCode)
+
+	// Test: If-Modified-Since at or after the modification time
+	resp = get(t, h, "GET", "/hello.txt", "If-Modified-Since: Fri, 01 Mar 2024 12:00:00 GMT\r\n")
+	assert.Equal(t, 304, resp.StatusCode)
+
+	// Test: If-Modified-Since before the modification time
+	resp = get(t, h, "GET", "/hello.txt", "If-Modified-Since: Thu, 29 Feb 2024 12:00:00 GMT\r\n")
+	assert.Equal(t, 200, resp.StatusCode)
+
+	// Test: If-None-Match takes precedence over If-Modified-Since
+	resp = get(t, h, "GET", "/hello.txt", "If-None-Match: \"other\"\r\n", "If-Modified-Since: Fri, 01 Mar 2024 12:00:00 GMT\r\n")
+	assert.Equal(t, 200, resp.StatusCode)
+
+	// Test: Failing If-Match gives 412
+	resp = get(t, h, "GET", "/hello.txt", "If-Match: \"other\"\r\n")
+	assert.Equal(t, 412, resp.StatusCode)
+
+	// Test: If-Unmodified-Since before the modification time gives 412
+	resp = get(t, h, "GET", "/hello.txt", "If-Unmodified-Since: Thu, 29 Feb 2024 12:00:00 GMT\r\n")
+	assert.Equal(t, 412, resp.StatusCode)
+}
+
+func TestRangeRequests(t *testing.T) {
+	h := Handler(testFS)
+	etag := get(t, h, "GET", "/hello.txt").Header.Get("ETag")
+
+	// Test: Single range
+	resp := get(t, h, "GET", "/hello.txt", "Range: bytes=0-4\r\n")
+	assert.Equal(t, 206, resp.StatusCode)
+	assert.Equal(t, "bytes 0-4/13", resp.Header.Get("Content-Range"))
+	assert.Equal(t, "hello", readBody(t, resp))
+
+	// Test: Suffix and open-ended ranges
+	resp = get(t, h, "GET", "/hello.txt", "Range: bytes=-6\r\n")
+	assert.Equal(t, "world!", readBody(t, resp))
+	resp = get(t, h, "GET", "/hello.txt", "Range: bytes=7-\r\n")
+	assert.Equal(t, "world!", readBody(t, resp))
+
+	// Test: Unsatisfiable range
+	resp = get(t, h, "GET", "/hello.txt", "Range: bytes=100-200\r\n")
+	assert.Equal(t, 416, resp.StatusCode)
+	assert.Equal(t, "bytes */13", resp.Header.Get("Content-Range"))
+
+	// Test: A malformed range is ignored and the whole file is sent
+	for _, value := range []string{"bytes=5-1", "bytes=abc", "lines=0-1"} {
+		resp = get(t, h, "GET", "/hello.txt", "Range: "+value+"\r\n")
+		assert.Equal(t, 200, resp.StatusCode, value)
+		assert.Equal(t, "hello, world!", readBody(t, resp), value)
+	}
+
+	// Test: Multiple ranges as multipart/byteranges
+	resp = get(t, h, "GET", "/hello.txt", "Range: bytes=0-4, 7-11\r\n")
+	assert.Equal(t, 206, resp.StatusCode)
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/byteranges", mediaType)
+	body := readBody(t, resp)
+	assert.Equal(t, int64(len(body)), resp.ContentLength)
+	mr := multipart.NewReader(strings.NewReader(body), params["boundary"])
+	var parts []string
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		assert.Equal(t, "text/plain; charset=utf-8", part.Header.Get("Content-Type"))
+		data, err := io.ReadAll(part)
+		require.NoError(t, err)
+		parts = append(parts, part.Header.Get("Content-Range")+" "+string(data))
+	}
+	assert.Equal(t, []string{"bytes 0-4/13 hello", "bytes 7-11/13 world"}, parts)
+
+	// Test: If-Range with the current ETag honours the range
+	resp = get(t, h, "GET", "/hello.txt", "Range: bytes=0-4\r\n", "If-Range: "+etag+"\r\n")
+	assert.Equal(t, 206, resp.StatusCode)
+
+	// Test: If-Range with a stale validator sends the whole file
+	resp = get(t, h, "GET", "/hello.txt", "Range: bytes=0-4\r\n", "If-Range: \"stale\"\r\n")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "hello, world!", readBody(t, resp))
+
+	// Test: If-Range with the Last-Modified date
+	resp = get(t, h, "GET", "/hello.txt", "Range: bytes=0-4\r\n", "If-Range: Fri, 01 Mar 2024 12:00:00 GMT\r\n")
+	assert.Equal(t, 206, resp.StatusCode)
+}
+
+func TestSniff(t *testing.T) {
+	// Test: Signatures, markup and text
+	assert.Equal(t, "image/png", sniff([]byte("\x89PNG\r\n\x1a\nrest")))
+	assert.Equal(t, "application/pdf", sniff([]byte("%PDF-1.7")))
+	assert.Equal(t, "text/html; charset=utf-8", sniff([]byte("  \n<HTML><body>")))
+	assert.Equal(t, "text/xml; charset=utf-8", sniff([]byte("<?xml version=\"1.0\"?>")))
+	assert.Equal(t, "text/plain; charset=utf-8", sniff([]byte("just words\n")))
+	assert.Equal(t, "application/octet-stream", sniff([]byte{0x00, 0x01, 0x02}))
+}
+
+func TestFSPath(t *testing.T) {
+	// Test: Clean paths map onto fs names
+	for target, want := range map[string]string{"/": ".", "/a/b": "a/b", "/a//b/": "a/b", "/./a": "a"} {
+		name, ok := fsPath(target)
+		assert.True(t, ok, target)
+		assert.Equal(t, want, name)
+		assert.True(t, fs.ValidPath(name))
+	}
+
+	// Test: Relative or invalid paths are refused
+	for _, target := range []string{"a", "/..", "/a/../b", "/%zz", "/a%00b"} {
+		_, ok := fsPath(target)
+		assert.False(t, ok, target)
+	}
+}
@@ -0,0 +1,156 @@
+package fileserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"main/internal/response"
+	"strconv"
+	"strings"
+)
+
+// maxRanges caps the ranges honoured in one request, so a long Range
+// header cannot turn a small file into a huge multipart response.
+const maxRanges = 32
+
+var (
+	// errInvalidRange marks a Range header that is ignored, so the whole
+	// file is served (RFC 9110 14.2).
+	errInvalidRange       = errors.New("invalid range")
+	errUnsatisfiableRange = errors.New("unsatisfiable range")
+)
+
+type byteRange struct {
+	start, length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// parseRange parses a "bytes=" Range header against a file of size bytes.
+// A malformed header, or one with more than maxRanges ranges, returns
+// errInvalidRange. Ranges starting past the end are dropped; if none are
+// left, errUnsatisfiableRange is returned.
+func parseRange(s string, size int64) ([]byteRange, error) {
+	unit, set, found := strings.Cut(s, "=")
+	if !found || strings.TrimSpace(unit) != "bytes" {
+		return nil, errInvalidRange
+	}
+
+	var ranges []byteRange
+	specs := 0
+	for _, spec := range strings.Split(set, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		specs++
+		first, last, found := strings.Cut(spec, "-")
+		if !found {
+			return nil, errInvalidRange
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+		var r byteRange
+		if first == "" {
+			// A suffix range: the last n bytes.
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, errInvalidRange
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			n = min(n, size)
+			r = byteRange{start: size - n, length: n}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, errInvalidRange
+			}
+			end := size - 1
+			if last != "" {
+				end, err = strconv.ParseInt(last, 10, 64)
+				if err != nil || end < start {
+					return nil, errInvalidRange
+				}
+				end = min(end, size-1)
+			}
+			if start >= size {
+				continue
+			}
+			r = byteRange{start: start, length: end - start + 1}
+		}
+		ranges = append(ranges, r)
+	}
+
+	switch {
+	case specs == 0 || specs > maxRanges:
+		return nil, errInvalidRange
+	case len(ranges) == 0:
+		return nil, errUnsatisfiableRange
+	}
+	return ranges, nil
+}
+
+// copyRange writes the bytes of r from f as the response body.
+func copyRange(w response.Writer, f io.ReadSeeker, r byteRange) error {
+	if _, err := f.Seek(r.start, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := io.CopyN(response.NewBodyWriter(w), f, r.length)
+	return err
+}
+
+// multipartRanges lays out a multipart/byteranges body, so its length can
+// be announced before it is written.
+type multipartRanges struct {
+	ranges   []byteRange
+	boundary string
+	headers  []string
+}
+
+func newMultipartRanges(ranges []byteRange, contentType string, size int64) *multipartRanges {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	m := &multipartRanges{ranges: ranges, boundary: hex.EncodeToString(b[:])}
+	for _, r := range ranges {
+		m.headers = append(m.headers, fmt.Sprintf("\r\n--%s\r\nContent-Type: %s\r\nContent-Range: %s\r\n\r\n",
+			m.boundary, contentType, r.contentRange(size)))
+	}
+	// The first delimiter needs no leading CRLF.
+	m.headers[0] = m.headers[0][2:]
+	return m
+}
+
+func (m *multipartRanges) contentType() string {
+	return "multipart/byteranges; boundary=" + m.boundary
+}
+
+func (m *multipartRanges) closing() string {
+	return "\r\n--" + m.boundary + "--\r\n"
+}
+
+func (m *multipartRanges) length() int64 {
+	n := int64(len(m.closing()))
+	for i, r := range m.ranges {
+		n += int64(len(m.headers[i])) + r.length
+	}
+	return n
+}
+
+func (m *multipartRanges) writeTo(w response.Writer, f io.ReadSeeker) error {
+	for i, r := range m.ranges {
+		if _, err := w.WriteBody([]byte(m.headers[i])); err != nil {
+			return err
+		}
+		if err := copyRange(w, f, r); err != nil {
+			return err
+		}
+	}
+	_, err := w.WriteBody([]byte(m.closing()))
+	return err
+}
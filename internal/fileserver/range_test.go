@@ -0,0 +1,38 @@
+package fileserver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRange(t *testing.T) {
+	// Test: Valid specs
+	ranges, err := parseRange("bytes=0-0, -1, 5-", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []byteRange{{0, 1}, {9, 1}, {5, 5}}, ranges)
+
+	// Test: End past the size is clamped
+	ranges, err = parseRange("bytes=8-100", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []byteRange{{8, 2}}, ranges)
+
+	// Test: Ranges starting past the end are dropped
+	ranges, err = parseRange("bytes=20-30, 0-1", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []byteRange{{0, 2}}, ranges)
+
+	// Test: Malformed headers, and too many ranges
+	for _, spec := range []string{"bytes=5-1", "items=0-1", "bytes=abc", "bytes=-", "bytes=", "bytes=" + strings.Repeat("0-0,", 33)} {
+		_, err = parseRange(spec, 10)
+		assert.ErrorIs(t, err, errInvalidRange, spec)
+	}
+
+	// Test: Well-formed but unsatisfiable
+	for _, spec := range []string{"bytes=10-", "bytes=20-30, 10-", "bytes=-0"} {
+		_, err = parseRange(spec, 10)
+		assert.ErrorIs(t, err, errUnsatisfiableRange, spec)
+	}
+}
@@ -2,55 +2,98 @@ package headers
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
-	"regexp"
+	"io"
+	"sort"
 	"strings"
 )
 
 type Headers map[string]string
 
+// ErrInvalidField is returned by Validate and WriteTo for a field whose
+// name is not a token, or whose value holds a CR, LF or NUL that would
+// end its line early and start fields of its own.
+var ErrInvalidField = errors.New("invalid header field")
+
+var crlf = []byte("\r\n")
+
+// tchar marks the bytes allowed in a field-name token (RFC 9110 5.6.2).
+var tchar [256]bool
+
+func init() {
+	for c := '0'; c <= '9'; c++ {
+		tchar[c] = true
+	}
+	for c := 'a'; c <= 'z'; c++ {
+		tchar[c] = true
+		tchar[c-'a'+'A'] = true
+	}
+	for _, c := range []byte("!#$%&'*+-.^_`|~") {
+		tchar[c] = true
+	}
+}
+
+// maxStackKey is the longest field-name lowercased without a heap allocation.
+const maxStackKey = 64
+
+// Parse reads field-lines from data until the blank line ending the
+// section, adding them to h. Repeated fields are combined into one value,
+// joined once per call rather than copied again for every repeat.
 func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 	total := 0
+	// repeats holds the later values of fields seen more than once.
+	var repeats map[string][]string
+	defer func() {
+		for key, values := range repeats {
+			h[key] = join(key, h[key], values)
+		}
+	}()
 
 	for {
 		if len(data) >= 2 && data[0] == '\r' && data[1] == '\n' {
 			return total + 2, true, nil
 		}
 
-		idx := bytes.Index(data, []byte("\r\n"))
+		idx := bytes.Index(data, crlf)
 		if idx == -1 {
 			return total, false, nil
 		}
 
-		line := string(data[:idx])
+		line := data[:idx]
 		data = data[idx+2:]
 		total += idx + 2
 
-		line = strings.TrimSpace(line)
-		if line == "" {
+		line = trimSpace(line)
+		if len(line) == 0 {
 			continue
 		}
 
-		colonIndex := strings.Index(line, ":")
+		colonIndex := bytes.IndexByte(line, ':')
 		if colonIndex <= 0 {
 			return total, false, fmt.Errorf("invalid header format: %s", line)
 		}
 
 		key := line[:colonIndex]
-		value := strings.TrimLeft(line[colonIndex+1:], " ")
+		value := trimLeftSpace(line[colonIndex+1:])
 
-		regex := regexp.MustCompile(`^[A-Za-z0-9!#$%&'*+\-.\^_` + "`" + `|~]+$`)
-		if !regex.MatchString(key) {
+		if !isToken(key) {
 			return total, false, fmt.Errorf("invalid characters in header field name: %s", key)
 		}
+		if bytes.ContainsAny(value, "\r\n\x00") {
+			return total, false, fmt.Errorf("invalid characters in header field value: %q", value)
+		}
 
-		lowerKey := strings.ToLower(key)
-		if prev, exists := h[lowerKey]; exists {
-			h[lowerKey] = prev + ", " + value
+		var stack [maxStackKey]byte
+		lowerKey := toLower(stack[:0], key)
+		if _, exists := h[string(lowerKey)]; exists {
+			if repeats == nil {
+				repeats = make(map[string][]string)
+			}
+			repeats[string(lowerKey)] = append(repeats[string(lowerKey)], string(value))
 		} else {
-			h[lowerKey] = value
+			h[string(lowerKey)] = string(value)
 		}
-
 	}
 }
 
@@ -59,3 +102,201 @@ func NewHeaders() Headers {
 	return make(Headers)
 
 }
+
+// Get returns the value of the field named key, case-insensitively.
+func (h Headers) Get(key string) string {
+	return h[strings.ToLower(key)]
+}
+
+// Set replaces any existing value of the field named key. Values are not
+// checked here; WriteTo refuses fields Validate rejects.
+func (h Headers) Set(key, value string) {
+	h[strings.ToLower(key)] = value
+}
+
+// Add appends values to the field named key, combining repeated fields
+// the same way Parse does. Adding many values in one call joins them
+// once.
+func (h Headers) Add(key string, values ...string) {
+	if len(values) == 0 {
+		return
+	}
+	key = strings.ToLower(key)
+	if prev, exists := h[key]; exists {
+		h[key] = join(key, prev, values)
+	} else {
+		h[key] = join(key, values[0], values[1:])
+	}
+}
+
+// join appends values to first with the separator of key, in a single
+// allocation.
+func join(key, first string, values []string) string {
+	if len(values) == 0 {
+		return first
+	}
+	sep := separator(key)
+	n := len(first) + len(sep)*len(values)
+	for _, v := range values {
+		n += len(v)
+	}
+	var b strings.Builder
+	b.Grow(n)
+	b.WriteString(first)
+	for _, v := range values {
+		b.WriteString(sep)
+		b.WriteString(v)
+	}
+	return b.String()
+}
+
+func (h Headers) Delete(key string) {
+	delete(h, strings.ToLower(key))
+}
+
+// Values returns the individual field values of key. Only Set-Cookie is
+// kept as separate lines; every other field is a single combined value.
+func (h Headers) Values(key string) []string {
+	key = strings.ToLower(key)
+	v, exists := h[key]
+	if !exists {
+		return nil
+	}
+	if key != "set-cookie" {
+		return []string{v}
+	}
+	return strings.Split(v, lineSeparator)
+}
+
+// Validate checks that every field name is a token and that no value
+// contains CR, LF or NUL, apart from the LFs separating Set-Cookie
+// values.
+func (h Headers) Validate() error {
+	for key, value := range h {
+		if !IsToken(key) {
+			return fmt.Errorf("%w: name %q", ErrInvalidField, key)
+		}
+		invalid := "\r\n\x00"
+		if key == "set-cookie" {
+			invalid = "\r\x00"
+		}
+		if strings.ContainsAny(value, invalid) {
+			return fmt.Errorf("%w: value of %s", ErrInvalidField, key)
+		}
+	}
+	return nil
+}
+
+// WriteTo writes the fields as HTTP/1.1 field-lines followed by the blank
+// line ending the section. Fields are sorted by name, and each Set-Cookie
+// value gets its own line. Nothing is written if Validate fails.
+func (h Headers) WriteTo(w io.Writer) (int64, error) {
+	if err := h.Validate(); err != nil {
+		return 0, err
+	}
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	for _, key := range keys {
+		values := []string{h[key]}
+		if key == "set-cookie" {
+			values = strings.Split(h[key], lineSeparator)
+		}
+		for _, value := range values {
+			buf.WriteString(key)
+			buf.WriteString(": ")
+			buf.WriteString(value)
+			buf.Write(crlf)
+		}
+	}
+	buf.Write(crlf)
+	return buf.WriteTo(w)
+}
+
+// HasToken reports whether the comma-separated list in the field named key
+// contains token, compared case-insensitively, as in Connection: close.
+func (h Headers) HasToken(key, token string) bool {
+	for _, v := range strings.Split(h.Get(key), ",") {
+		if strings.EqualFold(strings.TrimSpace(v), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsToken reports whether s is a valid RFC 9110 token, the syntax of field
+// names.
+func IsToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !tchar[s[i]] {
+			return false
+		}
+	}
+	return true
+}
+
+// lineSeparator joins repeated Set-Cookie values. Cookie attributes such
+// as Expires contain commas, so RFC 6265 forbids folding them into one
+// comma-separated line, and a bare LF can never occur inside a field value.
+const lineSeparator = "\n"
+
+func separator(lowerKey string) string {
+	switch lowerKey {
+	case "set-cookie":
+		return lineSeparator
+	case "cookie":
+		// RFC 9113 8.2.3: split cookie crumbs are recombined with "; ".
+		return "; "
+	default:
+		return ", "
+	}
+}
+
+func isToken(b []byte) bool {
+	for _, c := range b {
+		if !tchar[c] {
+			return false
+		}
+	}
+	return true
+}
+
+// toLower appends the ASCII-lowercased key to dst. Keys are already known to
+// be tokens, so only A-Z need folding.
+func toLower(dst, key []byte) []byte {
+	for _, c := range key {
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		dst = append(dst, c)
+	}
+	return dst
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\v' || c == '\f' || c == '\r' || c == '\n'
+}
+
+func trimSpace(b []byte) []byte {
+	for len(b) > 0 && isSpace(b[0]) {
+		b = b[1:]
+	}
+	for len(b) > 0 && isSpace(b[len(b)-1]) {
+		b = b[:len(b)-1]
+	}
+	return b
+}
+
+func trimLeftSpace(b []byte) []byte {
+	for len(b) > 0 && b[0] == ' ' {
+		b = b[1:]
+	}
+	return b
+}
@@ -1,6 +1,8 @@
 package headers
 
 import (
+	"bytes"
+	"runtime"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -189,3 +191,112 @@ func TestHeaderParse(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, "initial, second, third", headers["lang-pref"])
 }
+
+func TestHeaderParseSpecialFields(t *testing.T) {
+	// Test: Bare LF in field value (invalid)
+	headers := NewHeaders()
+	data := []byte("x-bad: one\ntwo\r\n\r\n")
+	_, done, err := headers.Parse(data)
+	require.Error(t, err)
+	assert.False(t, done)
+
+	// Test: Repeated Set-Cookie values are kept apart
+	headers = NewHeaders()
+	data = []byte("Set-Cookie: a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT\r\nSet-Cookie: b=2\r\n\r\n")
+	n, done, err := headers.Parse(data)
+	require.NoError(t, err)
+	assert.Equal(t, len(data), n)
+	assert.True(t, done)
+	assert.Equal(t, []string{"a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT", "b=2"}, headers.Values("set-cookie"))
+
+	// Test: Repeated Cookie fields are joined with semicolons
+	headers = NewHeaders()
+	data = []byte("Cookie: a=1\r\nCookie: b=2\r\n\r\n")
+	_, _, err = headers.Parse(data)
+	require.NoError(t, err)
+	assert.Equal(t, "a=1; b=2", headers["cookie"])
+
+	// Test: Many repeats are joined once, not copied again per line
+	headers = NewHeaders()
+	data = bytes.Repeat([]byte("X-A: 0123456789\r\n"), 2000)
+	data = append(data, crlf...)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, _, err = headers.Parse(data)
+	runtime.ReadMemStats(&after)
+	require.NoError(t, err)
+	assert.Len(t, headers["x-a"], 2000*len("0123456789")+1999*len(", "))
+	// Copying the value on every repeat would allocate about 24 MB.
+	assert.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(1<<20))
+}
+
+func TestHeaderHelpers(t *testing.T) {
+	// Test: Get, Set and Delete are case-insensitive
+	headers := NewHeaders()
+	headers.Set("Content-Type", "text/plain")
+	assert.Equal(t, "text/plain", headers.Get("CONTENT-TYPE"))
+	assert.Equal(t, "text/plain", headers["content-type"])
+	headers.Delete("content-TYPE")
+	assert.Equal(t, "", headers.Get("Content-Type"))
+	assert.Nil(t, headers.Values("Content-Type"))
+
+	// Test: Add combines like Parse
+	headers.Add("Accept", "text/html")
+	headers.Add("accept", "application/json")
+	assert.Equal(t, "text/html, application/json", headers.Get("Accept"))
+	assert.Equal(t, []string{"text/html, application/json"}, headers.Values("Accept"))
+	headers.Add("Accept", "text/plain", "*/*")
+	assert.Equal(t, "text/html, application/json, text/plain, */*", headers.Get("Accept"))
+	headers.Add("Cookie", "a=1", "b=2")
+	assert.Equal(t, "a=1; b=2", headers.Get("Cookie"))
+
+	// Test: WriteTo emits sorted field-lines and one line per Set-Cookie
+	headers = NewHeaders()
+	headers.Set("Content-Length", "0")
+	headers.Add("Set-Cookie", "a=1")
+	headers.Add("Set-Cookie", "b=2")
+	var buf bytes.Buffer
+	n, err := headers.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, "content-length: 0\r\nset-cookie: a=1\r\nset-cookie: b=2\r\n\r\n", buf.String())
+	assert.Equal(t, int64(buf.Len()), n)
+
+	// Test: Line breaks and NULs in values, or names that are not tokens,
+	// are refused before anything is written
+	for _, bad := range []Headers{
+		{"location": "/next\nSet-Cookie: session=stolen"},
+		{"x-note": "a\r\n\r\n<html>"},
+		{"x-note": "a\x00b"},
+		{"set-cookie": "a=1\r\nX-Injected: 1"},
+		{"x-bad name": "v"},
+	} {
+		buf.Reset()
+		_, err = bad.WriteTo(&buf)
+		assert.ErrorIs(t, err, ErrInvalidField)
+		assert.Zero(t, buf.Len())
+	}
+	assert.Equal(t, []string{"a\nb"}, Headers{"x-note": "a\nb"}.Values("X-Note"))
+
+	// Test: HasToken matches list members case-insensitively
+	headers.Set("Connection", "keep-alive, Upgrade")
+	assert.True(t, headers.HasToken("connection", "upgrade"))
+	assert.False(t, headers.HasToken("connection", "close"))
+	assert.False(t, headers.HasToken("x-missing", "close"))
+
+	// Test: IsToken
+	assert.True(t, IsToken("X-Test_123!#$%&'*+-.^_`|~"))
+	assert.False(t, IsToken(""))
+	assert.False(t, IsToken("User Agent"))
+	assert.False(t, IsToken("Üser"))
+}
+
+func BenchmarkHeaderParse(b *testing.B) {
+	data := []byte("Host: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\nContent-Type: application/json\r\n\r\n")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		headers := NewHeaders()
+		if _, _, err := headers.Parse(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,487 @@
+package hpack
+
+import (
+	"errors"
+	"fmt"
+	"main/internal/headers"
+)
+
+// DefaultHeaderTableSize is the initial size of the dynamic table in
+// HTTP/2.
+const DefaultHeaderTableSize = 4096
+
+// HeaderField is a name-value pair of a header block. Names are
+// lowercase; pseudo-header fields start with ':'.
+type HeaderField struct {
+	Name, Value string
+	// Sensitive fields are never added to a dynamic table, by this
+	// encoder or any intermediary (RFC 7541 7.1.3).
+	Sensitive bool
+}
+
+// size is the space the field takes in a dynamic table (RFC 7541 4.1).
+func (f HeaderField) size() uint32 {
+	return uint32(len(f.Name) + len(f.Value) + 32)
+}
+
+var staticTable = [...]HeaderField{
+	{Name: ":authority"},
+	{Name: ":method", Value: "GET"},
+	{Name: ":method", Value: "POST"},
+	{Name: ":path", Value: "/"},
+	{Name: ":path", Value: "/index.html"},
+	{Name: ":scheme", Value: "http"},
+	{Name: ":scheme", Value: "https"},
+	{Name: ":status", Value: "200"},
+	{Name: ":status", Value: "204"},
+	{Name: ":status", Value: "206"},
+	{Name: ":status", Value: "304"},
+	{Name: ":status", Value: "400"},
+	{Name: ":status", Value: "404"},
+	{Name: ":status", Value: "500"},
+	{Name: "accept-charset"},
+	{Name: "accept-encoding", Value: "gzip, deflate"},
+	{Name: "accept-language"},
+	{Name: "accept-ranges"},
+	{Name: "accept"},
+	{Name: "access-control-allow-origin"},
+	{Name: "age"},
+	{Name: "allow"},
+	{Name: "authorization"},
+	{Name: "cache-control"},
+	{Name: "content-disposition"},
+	{Name: "content-encoding"},
+	{Name: "content-language"},
+	{Name: "content-length"},
+	{Name: "content-location"},
+	{Name: "content-range"},
+	{Name: "content-type"},
+	{Name: "cookie"},
+	{Name: "date"},
+	{Name: "etag"},
+	{Name: "expect"},
+	{Name: "expires"},
+	{Name: "from"},
+	{Name: "host"},
+	{Name: "if-match"},
+	{Name: "if-modified-since"},
+	{Name: "if-none-match"},
+	{Name: "if-range"},
+	{Name: "if-unmodified-since"},
+	{Name: "last-modified"},
+	{Name: "link"},
+	{Name: "location"},
+	{Name: "max-forwards"},
+	{Name: "proxy-authenticate"},
+	{Name: "proxy-authorization"},
+	{Name: "range"},
+	{Name: "referer"},
+	{Name: "refresh"},
+	{Name: "retry-after"},
+	{Name: "server"},
+	{Name: "set-cookie"},
+	{Name: "strict-transport-security"},
+	{Name: "transfer-encoding"},
+	{Name: "user-agent"},
+	{Name: "vary"},
+	{Name: "via"},
+	{Name: "www-authenticate"},
+}
+
+// staticIndex maps fields and names of the static table to their first
+// index, for the encoder.
+var (
+	staticFieldIndex = make(map[HeaderField]int)
+	staticNameIndex  = make(map[string]int)
+)
+
+func init() {
+	for i, f := range staticTable {
+		if _, ok := staticFieldIndex[f]; !ok {
+			staticFieldIndex[f] = i + 1
+		}
+		if _, ok := staticNameIndex[f.Name]; !ok {
+			staticNameIndex[f.Name] = i + 1
+		}
+	}
+}
+
+// dynamicTable is the FIFO of fields added by literals with incremental
+// indexing. The newest entry has the lowest index.
+type dynamicTable struct {
+	entries []HeaderField
+	size    uint32
+	maxSize uint32
+}
+
+func (t *dynamicTable) add(f HeaderField) {
+	f.Sensitive = false
+	t.entries = append(t.entries, f)
+	t.size += f.size()
+	t.evict()
+}
+
+func (t *dynamicTable) setMaxSize(n uint32) {
+	t.maxSize = n
+	t.evict()
+}
+
+// evict drops the oldest entries until the table fits. An entry larger
+// than the whole table empties it (RFC 7541 4.4).
+func (t *dynamicTable) evict() {
+	drop := 0
+	for t.size > t.maxSize {
+		t.size -= t.entries[drop].size()
+		drop++
+	}
+	if drop > 0 {
+		t.entries = append(t.entries[:0], t.entries[drop:]...)
+	}
+}
+
+// field returns the entry at index, counted across the static table and
+// then the dynamic table.
+func (t *dynamicTable) field(index uint64) (HeaderField, bool) {
+	switch {
+	case index == 0:
+		return HeaderField{}, false
+	case index <= uint64(len(staticTable)):
+		return staticTable[index-1], true
+	}
+	i := index - uint64(len(staticTable))
+	if i > uint64(len(t.entries)) {
+		return HeaderField{}, false
+	}
+	return t.entries[uint64(len(t.entries))-i], true
+}
+
+// search returns the index of f in the dynamic table, or of a field with
+// the same name if nameOnly.
+func (t *dynamicTable) search(f HeaderField, nameOnly bool) int {
+	for i := len(t.entries) - 1; i >= 0; i-- {
+		e := t.entries[i]
+		if e.Name == f.Name && (nameOnly || e.Value == f.Value) {
+			return len(staticTable) + len(t.entries) - i
+		}
+	}
+	return 0
+}
+
+var (
+	// ErrHeaderListTooLarge is returned by Decode for a block whose
+	// fields exceed the limit set with SetMaxHeaderListSize. Unlike other
+	// errors, it leaves the decoder in step with the encoder.
+	ErrHeaderListTooLarge = errors.New("HPACK header list too large")
+
+	errIntegerOverflow = errors.New("HPACK integer overflow")
+	errTruncated       = errors.New("truncated HPACK block")
+)
+
+// appendInt appends i with an n-bit prefix, keeping the high bits of
+// first (RFC 7541 5.1).
+func appendInt(dst []byte, first byte, n uint, i uint64) []byte {
+	max := uint64(1)<<n - 1
+	if i < max {
+		return append(dst, first|byte(i))
+	}
+	dst = append(dst, first|byte(max))
+	i -= max
+	for i >= 128 {
+		dst = append(dst, byte(i&0x7f|0x80))
+		i >>= 7
+	}
+	return append(dst, byte(i))
+}
+
+// readInt reads an integer with an n-bit prefix from the start of p and
+// returns it with the rest of p.
+func readInt(p []byte, n uint) (uint64, []byte, error) {
+	if len(p) == 0 {
+		return 0, nil, errTruncated
+	}
+	max := uint64(1)<<n - 1
+	i := uint64(p[0]) & max
+	p = p[1:]
+	if i < max {
+		return i, p, nil
+	}
+	for shift := uint(0); ; shift += 7 {
+		if len(p) == 0 {
+			return 0, nil, errTruncated
+		}
+		if shift > 56 {
+			return 0, nil, errIntegerOverflow
+		}
+		b := p[0]
+		p = p[1:]
+		i += uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return i, p, nil
+		}
+	}
+}
+
+// appendString appends a string literal, Huffman-encoded unless that is
+// longer, as in the RFC 7541 examples.
+func appendString(dst []byte, s string) []byte {
+	if n := huffmanEncodedLen(s); n <= len(s) {
+		dst = appendInt(dst, 0x80, 7, uint64(n))
+		return appendHuffman(dst, s)
+	}
+	dst = appendInt(dst, 0, 7, uint64(len(s)))
+	return append(dst, s...)
+}
+
+// Encoder compresses header blocks for one direction of a connection.
+type Encoder struct {
+	table dynamicTable
+	// pendingMin and pendingSize are the table size updates to signal at
+	// the start of the next block: the smallest size set since the last
+	// block, then the final one (RFC 7541 4.2).
+	pendingMin, pendingSize uint32
+	sizeChanged             bool
+}
+
+// NewEncoder returns an encoder whose dynamic table starts at tableSize,
+// the size both sides assume without a size update.
+func NewEncoder(tableSize uint32) *Encoder {
+	return &Encoder{table: dynamicTable{maxSize: tableSize}}
+}
+
+// SetMaxTableSize changes the size of the dynamic table, within what the
+// decoder allows, and signals it at the start of the next block.
+func (e *Encoder) SetMaxTableSize(n uint32) {
+	if !e.sizeChanged || n < e.pendingMin {
+		e.pendingMin = n
+	}
+	e.pendingSize = n
+	e.sizeChanged = true
+	e.table.setMaxSize(n)
+}
+
+// AppendBlock appends the header block encoding fields to dst.
+func (e *Encoder) AppendBlock(dst []byte, fields ...HeaderField) []byte {
+	if e.sizeChanged {
+		if e.pendingMin < e.pendingSize {
+			dst = appendInt(dst, 0x20, 5, uint64(e.pendingMin))
+		}
+		dst = appendInt(dst, 0x20, 5, uint64(e.pendingSize))
+		e.sizeChanged = false
+	}
+	for _, f := range fields {
+		dst = e.appendField(dst, f)
+	}
+	return dst
+}
+
+func (e *Encoder) appendField(dst []byte, f HeaderField) []byte {
+	if !f.Sensitive {
+		if i, ok := staticFieldIndex[HeaderField{Name: f.Name, Value: f.Value}]; ok {
+			return appendInt(dst, 0x80, 7, uint64(i))
+		}
+		if i := e.table.search(f, false); i > 0 {
+			return appendInt(dst, 0x80, 7, uint64(i))
+		}
+	}
+
+	nameIndex, ok := staticNameIndex[f.Name]
+	if !ok {
+		nameIndex = e.table.search(f, true)
+	}
+	switch {
+	case f.Sensitive:
+		dst = appendInt(dst, 0x10, 4, uint64(nameIndex))
+	case f.size() <= e.table.maxSize:
+		dst = appendInt(dst, 0x40, 6, uint64(nameIndex))
+		e.table.add(f)
+	default:
+		dst = appendInt(dst, 0, 4, uint64(nameIndex))
+	}
+	if nameIndex == 0 {
+		dst = appendString(dst, f.Name)
+	}
+	return appendString(dst, f.Value)
+}
+
+// Decoder decompresses header blocks for one direction of a connection.
+type Decoder struct {
+	table dynamicTable
+	// maxAllowed is the SETTINGS_HEADER_TABLE_SIZE sent to the peer,
+	// the most a size update may ask for.
+	maxAllowed uint32
+	// maxStringLen caps every name and value, so a small block cannot
+	// expand into a huge allocation.
+	maxStringLen int
+	// maxListSize caps the size of a decoded block, counted as in
+	// SETTINGS_MAX_HEADER_LIST_SIZE. Zero means no limit.
+	maxListSize uint64
+}
+
+// NewDecoder returns a decoder whose dynamic table may grow to
+// maxTableSize, and which rejects names and values longer than
+// maxStringLen.
+func NewDecoder(maxTableSize uint32, maxStringLen int) *Decoder {
+	return &Decoder{
+		table:        dynamicTable{maxSize: maxTableSize},
+		maxAllowed:   maxTableSize,
+		maxStringLen: maxStringLen,
+	}
+}
+
+// SetMaxHeaderListSize limits the fields of a block to n bytes, counting
+// each as its name and value plus 32 (RFC 9113 6.5.2). Zero removes the
+// limit.
+func (d *Decoder) SetMaxHeaderListSize(n uint32) {
+	d.maxListSize = uint64(n)
+}
+
+// Decode decodes a complete header block. A block over the header list
+// limit returns ErrHeaderListTooLarge: its fields are dropped as soon as
+// the limit is passed, and the rest is only decoded to update the
+// dynamic table. Any other error leaves the decoder out of sync with the
+// encoder, so in HTTP/2 the connection must be closed with a
+// COMPRESSION_ERROR.
+func (d *Decoder) Decode(block []byte) ([]HeaderField, error) {
+	var fields []HeaderField
+	var listSize uint64
+	tooLarge := false
+	emit := func(f HeaderField) {
+		if tooLarge {
+			return
+		}
+		listSize += uint64(f.size())
+		if d.maxListSize > 0 && listSize > d.maxListSize {
+			tooLarge = true
+			fields = nil
+			return
+		}
+		fields = append(fields, f)
+	}
+	sizeUpdateAllowed := true
+	for p := block; len(p) > 0; {
+		var (
+			f   HeaderField
+			err error
+		)
+		b := p[0]
+		switch {
+		case b&0x80 != 0:
+			// Indexed field.
+			var index uint64
+			if index, p, err = readInt(p, 7); err != nil {
+				return nil, err
+			}
+			var ok bool
+			if f, ok = d.table.field(index); !ok {
+				return nil, fmt.Errorf("invalid HPACK index %d", index)
+			}
+			emit(f)
+			sizeUpdateAllowed = false
+			continue
+
+		case b&0xe0 == 0x20:
+			// Dynamic table size update, only at the start of a block.
+			var size uint64
+			if size, p, err = readInt(p, 5); err != nil {
+				return nil, err
+			}
+			if !sizeUpdateAllowed || size > uint64(d.maxAllowed) {
+				return nil, fmt.Errorf("invalid HPACK table size update to %d", size)
+			}
+			d.table.setMaxSize(uint32(size))
+			continue
+
+		case b&0xc0 == 0x40:
+			// Literal with incremental indexing.
+			if f, p, err = d.readLiteral(p, 6); err != nil {
+				return nil, err
+			}
+			d.table.add(f)
+
+		default:
+			// Literal without indexing, or never indexed.
+			if f, p, err = d.readLiteral(p, 4); err != nil {
+				return nil, err
+			}
+			f.Sensitive = b&0xf0 == 0x10
+		}
+		emit(f)
+		sizeUpdateAllowed = false
+	}
+	if tooLarge {
+		return nil, ErrHeaderListTooLarge
+	}
+	return fields, nil
+}
+
+// DecodeHeaders decodes a complete header block into Headers, combining
+// repeated fields as headers.Headers.Add does. Pseudo-header fields are
+// kept under their names, such as ":path".
+func (d *Decoder) DecodeHeaders(block []byte) (headers.Headers, error) {
+	fields, err := d.Decode(block)
+	if err != nil {
+		return nil, err
+	}
+	h := headers.NewHeaders()
+	AddFields(h, fields)
+	return h, nil
+}
+
+// AddFields adds fields to h as headers.Headers.Add does, joining the
+// values of each name once rather than field by field.
+func AddFields(h headers.Headers, fields []HeaderField) {
+	values := make(map[string][]string)
+	for _, f := range fields {
+		values[f.Name] = append(values[f.Name], f.Value)
+	}
+	for name, vs := range values {
+		h.Add(name, vs...)
+	}
+}
+
+func (d *Decoder) readLiteral(p []byte, n uint) (HeaderField, []byte, error) {
+	var f HeaderField
+	index, p, err := readInt(p, n)
+	if err != nil {
+		return f, nil, err
+	}
+	if index > 0 {
+		named, ok := d.table.field(index)
+		if !ok {
+			return f, nil, fmt.Errorf("invalid HPACK index %d", index)
+		}
+		f.Name = named.Name
+	} else if f.Name, p, err = d.readString(p); err != nil {
+		return f, nil, err
+	}
+	f.Value, p, err = d.readString(p)
+	return f, p, err
+}
+
+func (d *Decoder) readString(p []byte) (string, []byte, error) {
+	if len(p) == 0 {
+		return "", nil, errTruncated
+	}
+	huffman := p[0]&0x80 != 0
+	n, p, err := readInt(p, 7)
+	if err != nil {
+		return "", nil, err
+	}
+	if n > uint64(len(p)) {
+		return "", nil, errTruncated
+	}
+	raw := p[:n]
+	p = p[n:]
+	s := raw
+	if huffman {
+		// Huffman coding shrinks strings by at most 8/5, so the output is
+		// bounded by the block size.
+		if s, err = huffmanDecode(make([]byte, 0, len(raw)*8/5), raw); err != nil {
+			return "", nil, err
+		}
+	}
+	if len(s) > d.maxStringLen {
+		return "", nil, errors.New("HPACK string too long")
+	}
+	return string(s), p, nil
+}
@@ -0,0 +1,273 @@
+package hpack
+
+import (
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHPACKRoundTrip(t *testing.T) {
+	enc := NewEncoder(DefaultHeaderTableSize)
+	dec := NewDecoder(DefaultHeaderTableSize, 1<<16)
+	fields := []HeaderField{
+		{Name: ":method", Value: "GET"},
+		{Name: ":path", Value: "/search?q=hpack"},
+		{Name: "user-agent", Value: "test"},
+		{Name: "authorization", Value: "secret", Sensitive: true},
+		{Name: "x-custom", Value: "value"},
+	}
+
+	// Test: Blocks decode to the fields encoded, and repeated fields
+	// shrink to indexes
+	first := enc.AppendBlock(nil, fields...)
+	got, err := dec.Decode(first)
+	require.NoError(t, err)
+	assert.Equal(t, fields, got)
+	second := enc.AppendBlock(nil, fields...)
+	assert.Less(t, len(second), len(first))
+	got, err = dec.Decode(second)
+	require.NoError(t, err)
+	assert.Equal(t, fields, got)
+
+	// Test: Size updates are signalled and honoured
+	enc.SetMaxTableSize(0)
+	enc.SetMaxTableSize(100)
+	block := enc.AppendBlock(nil, fields...)
+	assert.Equal(t, byte(0x20), block[0])
+	got, err = dec.Decode(block)
+	require.NoError(t, err)
+	assert.Equal(t, fields, got)
+	assert.LessOrEqual(t, dec.table.size, uint32(100))
+
+	// Test: Malformed blocks
+	_, err = NewDecoder(DefaultHeaderTableSize, 1<<16).Decode([]byte{0x80})
+	assert.Error(t, err, "index 0")
+	_, err = NewDecoder(DefaultHeaderTableSize, 1<<16).Decode([]byte{0xbe})
+	assert.Error(t, err, "empty dynamic table")
+	_, err = NewDecoder(DefaultHeaderTableSize, 1<<16).Decode([]byte{0x3f, 0xe2, 0x1f})
+	assert.Error(t, err, "size update beyond the limit")
+	_, err = NewDecoder(DefaultHeaderTableSize, 1<<16).Decode([]byte{0x82, 0x20})
+	assert.Error(t, err, "size update after a field")
+	_, err = NewDecoder(DefaultHeaderTableSize, 3).Decode(NewEncoder(DefaultHeaderTableSize).AppendBlock(nil, HeaderField{Name: "long", Value: "value"}))
+	assert.Error(t, err, "string too long")
+	_, err = NewDecoder(DefaultHeaderTableSize, 1<<16).Decode([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01})
+	assert.Error(t, err, "integer overflow")
+}
+
+// hpackExample is one header block of RFC 7541 Appendix C, with the
+// fields it decodes to and the dynamic table size afterwards.
+type hpackExample struct {
+	block     string
+	fields    []HeaderField
+	tableSize uint32
+}
+
+// runExamples decodes a sequence of blocks with one decoder. When enc is
+// given, it must also reproduce every block from the fields.
+func runExamples(t *testing.T, dec *Decoder, enc *Encoder, examples []hpackExample) {
+	t.Helper()
+	for i, ex := range examples {
+		block, err := hex.DecodeString(strings.ReplaceAll(ex.block, " ", ""))
+		require.NoError(t, err)
+		got, err := dec.Decode(block)
+		require.NoError(t, err, "block %d", i)
+		assert.Equal(t, ex.fields, got, "block %d", i)
+		assert.Equal(t, ex.tableSize, dec.table.size, "block %d", i)
+		if enc != nil {
+			assert.Equal(t, block, enc.AppendBlock(nil, ex.fields...), "block %d", i)
+		}
+	}
+}
+
+func TestIntegerRepresentation(t *testing.T) {
+	// Test: RFC 7541 C.1 examples
+	for _, tc := range []struct {
+		prefix  uint
+		value   uint64
+		encoded []byte
+	}{
+		{5, 10, []byte{0x0a}},
+		{5, 1337, []byte{0x1f, 0x9a, 0x0a}},
+		{8, 42, []byte{0x2a}},
+	} {
+		assert.Equal(t, tc.encoded, appendInt(nil, 0, tc.prefix, tc.value))
+		got, rest, err := readInt(tc.encoded, tc.prefix)
+		require.NoError(t, err)
+		assert.Equal(t, tc.value, got)
+		assert.Empty(t, rest)
+	}
+}
+
+func TestLiteralRepresentations(t *testing.T) {
+	// Test: RFC 7541 C.2 examples, each with an empty table
+	for _, ex := range []hpackExample{
+		{
+			block:     "400a 6375 7374 6f6d 2d6b 6579 0d63 7573 746f 6d2d 6865 6164 6572",
+			fields:    []HeaderField{{Name: "custom-key", Value: "custom-header"}},
+			tableSize: 55,
+		},
+		{
+			block:  "040c 2f73 616d 706c 652f 7061 7468",
+			fields: []HeaderField{{Name: ":path", Value: "/sample/path"}},
+		},
+		{
+			block:  "1008 7061 7373 776f 7264 0673 6563 7265 74",
+			fields: []HeaderField{{Name: "password", Value: "secret", Sensitive: true}},
+		},
+		{
+			block:  "82",
+			fields: []HeaderField{{Name: ":method", Value: "GET"}},
+		},
+	} {
+		runExamples(t, NewDecoder(DefaultHeaderTableSize, 1<<16), nil, []hpackExample{ex})
+	}
+}
+
+var exampleRequests = [][]HeaderField{
+	{
+		{Name: ":method", Value: "GET"},
+		{Name: ":scheme", Value: "http"},
+		{Name: ":path", Value: "/"},
+		{Name: ":authority", Value: "www.example.com"},
+	},
+	{
+		{Name: ":method", Value: "GET"},
+		{Name: ":scheme", Value: "http"},
+		{Name: ":path", Value: "/"},
+		{Name: ":authority", Value: "www.example.com"},
+		{Name: "cache-control", Value: "no-cache"},
+	},
+	{
+		{Name: ":method", Value: "GET"},
+		{Name: ":scheme", Value: "https"},
+		{Name: ":path", Value: "/index.html"},
+		{Name: ":authority", Value: "www.example.com"},
+		{Name: "custom-key", Value: "custom-value"},
+	},
+}
+
+func TestRequestExamples(t *testing.T) {
+	// Test: RFC 7541 C.3, requests without Huffman coding
+	runExamples(t, NewDecoder(DefaultHeaderTableSize, 1<<16), nil, []hpackExample{
+		{"8286 8441 0f77 7777 2e65 7861 6d70 6c65 2e63 6f6d", exampleRequests[0], 57},
+		{"8286 84be 5808 6e6f 2d63 6163 6865", exampleRequests[1], 110},
+		{"8287 85bf 400a 6375 7374 6f6d 2d6b 6579 0c63 7573 746f 6d2d 7661 6c75 65", exampleRequests[2], 164},
+	})
+
+	// Test: RFC 7541 C.4, requests with Huffman coding, which the encoder
+	// reproduces
+	runExamples(t, NewDecoder(DefaultHeaderTableSize, 1<<16), NewEncoder(DefaultHeaderTableSize), []hpackExample{
+		{"8286 8441 8cf1 e3c2 e5f2 3a6b a0ab 90f4 ff", exampleRequests[0], 57},
+		{"8286 84be 5886 a8eb 1064 9cbf", exampleRequests[1], 110},
+		{"8287 85bf 4088 25a8 49e9 5ba9 7d7f 8925 a849 e95b b8e8 b4bf", exampleRequests[2], 164},
+	})
+}
+
+var exampleResponses = [][]HeaderField{
+	{
+		{Name: ":status", Value: "302"},
+		{Name: "cache-control", Value: "private"},
+		{Name: "date", Value: "Mon, 21 Oct 2013 20:13:21 GMT"},
+		{Name: "location", Value: "https://www.example.com"},
+	},
+	{
+		{Name: ":status", Value: "307"},
+		{Name: "cache-control", Value: "private"},
+		{Name: "date", Value: "Mon, 21 Oct 2013 20:13:21 GMT"},
+		{Name: "location", Value: "https://www.example.com"},
+	},
+	{
+		{Name: ":status", Value: "200"},
+		{Name: "cache-control", Value: "private"},
+		{Name: "date", Value: "Mon, 21 Oct 2013 20:13:22 GMT"},
+		{Name: "location", Value: "https://www.example.com"},
+		{Name: "content-encoding", Value: "gzip"},
+		{Name: "set-cookie", Value: "foo=ASDJKHQKBZXOQWEOPIUAXQWEOIU; max-age=3600; version=1"},
+	},
+}
+
+func TestResponseExamples(t *testing.T) {
+	// Test: RFC 7541 C.5, responses without Huffman coding, evicting from
+	// a 256-byte table
+	runExamples(t, NewDecoder(256, 1<<16), nil, []hpackExample{
+		{"4803 3330 3258 0770 7269 7661 7465 611d 4d6f 6e2c 2032 3120 4f63 7420 3230 3133 2032 303a 3133 3a32 3120 474d 546e 1768 7474 7073 3a2f 2f77 7777 2e65 7861 6d70 6c65 2e63 6f6d", exampleResponses[0], 222},
+		{"4803 3330 37c1 c0bf", exampleResponses[1], 222},
+		{"88c1 611d 4d6f 6e2c 2032 3120 4f63 7420 3230 3133 2032 303a 3133 3a32 3220 474d 54c0 5a04 677a 6970 7738 666f 6f3d 4153 444a 4b48 514b 425a 584f 5157 454f 5049 5541 5851 5745 4f49 553b 206d 6178 2d61 6765 3d33 3630 303b 2076 6572 7369 6f6e 3d31", exampleResponses[2], 215},
+	})
+
+	// Test: RFC 7541 C.6, responses with Huffman coding, which the encoder
+	// reproduces
+	runExamples(t, NewDecoder(256, 1<<16), NewEncoder(256), []hpackExample{
+		{"4882 6402 5885 aec3 771a 4b61 96d0 7abe 9410 54d4 44a8 2005 9504 0b81 66e0 82a6 2d1b ff6e 919d 29ad 1718 63c7 8f0b 97c8 e9ae 82ae 43d3", exampleResponses[0], 222},
+		{"4883 640e ffc1 c0bf", exampleResponses[1], 222},
+		{"88c1 6196 d07a be94 1054 d444 a820 0595 040b 8166 e084 a62d 1bff c05a 839b d9ab 77ad 94e7 821d d7f2 e6c7 b335 dfdf cd5b 3960 d5af 2708 7f36 72c1 ab27 0fb5 291f 9587 3160 65c0 03ed 4ee5 b106 3d50 07", exampleResponses[2], 215},
+	})
+}
+
+func TestDecodeHeaders(t *testing.T) {
+	block := NewEncoder(DefaultHeaderTableSize).AppendBlock(nil,
+		HeaderField{Name: ":path", Value: "/"},
+		HeaderField{Name: "cookie", Value: "a=1"},
+		HeaderField{Name: "cookie", Value: "b=2"},
+		HeaderField{Name: "accept", Value: "text/html"},
+	)
+
+	// Test: Fields become Headers, with cookie crumbs joined
+	h, err := NewDecoder(DefaultHeaderTableSize, 1<<16).DecodeHeaders(block)
+	require.NoError(t, err)
+	assert.Equal(t, "/", h.Get(":path"))
+	assert.Equal(t, "a=1; b=2", h.Get("Cookie"))
+	assert.Equal(t, "text/html", h.Get("Accept"))
+
+	// Test: Many repeats are joined in order
+	fields := make([]HeaderField, 500)
+	for i := range fields {
+		fields[i] = HeaderField{Name: "x-n", Value: strconv.Itoa(i)}
+	}
+	h, err = NewDecoder(DefaultHeaderTableSize, 1<<16).DecodeHeaders(NewEncoder(DefaultHeaderTableSize).AppendBlock(nil, fields...))
+	require.NoError(t, err)
+	values := strings.Split(h.Get("X-N"), ", ")
+	require.Len(t, values, 500)
+	assert.Equal(t, "499", values[499])
+
+	// Test: Decoding errors are returned
+	_, err = NewDecoder(DefaultHeaderTableSize, 1<<16).DecodeHeaders([]byte{0x80})
+	assert.Error(t, err)
+}
+
+func TestDecoderMaxHeaderListSize(t *testing.T) {
+	enc := NewEncoder(DefaultHeaderTableSize)
+	dec := NewDecoder(DefaultHeaderTableSize, 1<<16)
+	field := HeaderField{Name: "x-big", Value: strings.Repeat("v", 100)}
+	dec.SetMaxHeaderListSize(3 * field.size())
+
+	// Test: A list exactly at the limit is decoded
+	got, err := dec.Decode(enc.AppendBlock(nil, field, field, field))
+	require.NoError(t, err)
+	assert.Len(t, got, 3)
+
+	// Test: One field more fails, even when it is a one-byte index
+	block := enc.AppendBlock(nil, field, field, field, field)
+	assert.Less(t, len(block), 10)
+	_, err = dec.Decode(block)
+	assert.ErrorIs(t, err, ErrHeaderListTooLarge)
+
+	// Test: The rest of a rejected block still updates the dynamic table,
+	// so later blocks decode
+	other := HeaderField{Name: "x-other", Value: "after the limit"}
+	_, err = dec.Decode(enc.AppendBlock(nil, field, field, field, field, other))
+	assert.ErrorIs(t, err, ErrHeaderListTooLarge)
+	got, err = dec.Decode(enc.AppendBlock(nil, other))
+	require.NoError(t, err)
+	assert.Equal(t, []HeaderField{other}, got)
+
+	// Test: Zero removes the limit
+	dec.SetMaxHeaderListSize(0)
+	got, err = dec.Decode(enc.AppendBlock(nil, field, field, field, field))
+	require.NoError(t, err)
+	assert.Len(t, got, 4)
+}
@@ -0,0 +1,131 @@
+package hpack
+
+import "errors"
+
+var errHuffman = errors.New("invalid Huffman-encoded string")
+
+// huffmanLengths is the code length of every symbol of the HPACK Huffman
+// code, 256 being EOS (RFC 7541 Appendix B). The code is canonical, so
+// the codes themselves follow from the lengths.
+var huffmanLengths = [257]uint8{
+	13, 23, 28, 28, 28, 28, 28, 28, 28, 24, 30, 28, 28, 30, 28, 28, // 0-15
+	28, 28, 28, 28, 28, 28, 30, 28, 28, 28, 28, 28, 28, 28, 28, 28, // 16-31
+	6, 10, 10, 12, 13, 6, 8, 11, 10, 10, 8, 11, 8, 6, 6, 6, //  !"#$%&'()*+,-./
+	5, 5, 5, 6, 6, 6, 6, 6, 6, 6, 7, 8, 15, 6, 12, 10, // 0123456789:;<=>?
+	13, 6, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7, // @ABCDEFGHIJKLMNO
+	7, 7, 7, 7, 7, 7, 7, 7, 8, 7, 8, 13, 19, 13, 14, 6, // PQRSTUVWXYZ[\]^_
+	15, 5, 6, 5, 6, 5, 6, 6, 6, 5, 7, 7, 6, 6, 6, 5, // `abcdefghijklmno
+	6, 7, 6, 5, 5, 6, 7, 7, 7, 7, 7, 15, 11, 14, 13, 28, // pqrstuvwxyz{|}~ DEL
+	20, 22, 20, 20, 22, 22, 22, 23, 22, 23, 23, 23, 23, 23, 24, 23, // 128-143
+	24, 24, 22, 23, 24, 23, 23, 23, 23, 21, 22, 23, 22, 23, 23, 24, // 144-159
+	22, 21, 20, 22, 22, 23, 23, 21, 23, 22, 22, 24, 21, 22, 23, 23, // 160-175
+	21, 21, 22, 21, 23, 22, 23, 23, 20, 22, 22, 22, 23, 22, 22, 23, // 176-191
+	26, 26, 20, 19, 22, 23, 22, 25, 26, 26, 26, 27, 27, 26, 24, 25, // 192-207
+	19, 21, 26, 27, 27, 26, 27, 24, 21, 21, 26, 26, 28, 27, 27, 27, // 208-223
+	20, 24, 20, 21, 22, 21, 21, 23, 22, 22, 25, 25, 24, 24, 26, 23, // 224-239
+	26, 27, 26, 26, 27, 27, 27, 27, 27, 28, 27, 27, 27, 27, 27, 26, // 240-255
+	30, // EOS
+}
+
+const huffmanEOS = 256
+
+var (
+	huffmanCodes [257]uint32
+	// huffmanTree is the decoding tree: node i has children
+	// huffmanTree[i][0] and [1]. Leaves are stored as ^symbol.
+	huffmanTree [][2]int32
+)
+
+func init() {
+	// Canonical codes are assigned in order of length, then symbol.
+	var code uint32
+	var prevLen uint8
+	for length := uint8(1); length <= 30; length++ {
+		for sym, l := range huffmanLengths {
+			if l != length {
+				continue
+			}
+			if prevLen != 0 {
+				code = (code + 1) << (length - prevLen)
+			}
+			huffmanCodes[sym] = code
+			prevLen = length
+		}
+	}
+
+	huffmanTree = make([][2]int32, 1, 2*len(huffmanLengths))
+	for sym, code := range huffmanCodes {
+		node := int32(0)
+		for i := int(huffmanLengths[sym]) - 1; i >= 0; i-- {
+			bit := code >> i & 1
+			if i == 0 {
+				huffmanTree[node][bit] = ^int32(sym)
+				break
+			}
+			if huffmanTree[node][bit] == 0 {
+				huffmanTree = append(huffmanTree, [2]int32{})
+				huffmanTree[node][bit] = int32(len(huffmanTree) - 1)
+			}
+			node = huffmanTree[node][bit]
+		}
+	}
+}
+
+// huffmanEncodedLen returns the length of s once Huffman-encoded.
+func huffmanEncodedLen(s string) int {
+	bits := 0
+	for i := 0; i < len(s); i++ {
+		bits += int(huffmanLengths[s[i]])
+	}
+	return (bits + 7) / 8
+}
+
+// appendHuffman appends the Huffman encoding of s, padded with the most
+// significant bits of EOS.
+func appendHuffman(dst []byte, s string) []byte {
+	var acc uint64
+	var n uint
+	for i := 0; i < len(s); i++ {
+		acc = acc<<huffmanLengths[s[i]] | uint64(huffmanCodes[s[i]])
+		n += uint(huffmanLengths[s[i]])
+		for n >= 8 {
+			n -= 8
+			dst = append(dst, byte(acc>>n))
+		}
+	}
+	if n > 0 {
+		dst = append(dst, byte(acc<<(8-n))|byte(0xff>>n))
+	}
+	return dst
+}
+
+// huffmanDecode appends the decoding of src to dst. Padding must be
+// shorter than a byte and consist of ones, and EOS may not appear
+// (RFC 7541 5.2).
+func huffmanDecode(dst, src []byte) ([]byte, error) {
+	node := int32(0)
+	depth := 0
+	ones := true
+	for _, b := range src {
+		for i := 7; i >= 0; i-- {
+			bit := b >> i & 1
+			next := huffmanTree[node][bit]
+			depth++
+			ones = ones && bit == 1
+			if next < 0 {
+				sym := ^next
+				if sym == huffmanEOS {
+					return nil, errHuffman
+				}
+				dst = append(dst, byte(sym))
+				node, depth, ones = 0, 0, true
+				continue
+			}
+			node = next
+		}
+	}
+	if depth > 7 || !ones {
+		return nil, errHuffman
+	}
+	return dst, nil
+}
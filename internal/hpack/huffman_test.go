@@ -0,0 +1,36 @@
+package hpack
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHuffmanCode(t *testing.T) {
+	// Test: The canonical code matches the RFC 7541 table
+	assert.Equal(t, uint32(0x0), huffmanCodes['0'])
+	assert.Equal(t, uint32(0x14), huffmanCodes[' '])
+	assert.Equal(t, uint32(0x7fff0), huffmanCodes['\\'])
+	assert.Equal(t, uint32(0xfffe6), huffmanCodes[128])
+	assert.Equal(t, uint32(0x3ffffee), huffmanCodes[255])
+	assert.Equal(t, uint32(0x3fffffff), huffmanCodes[huffmanEOS])
+
+	// Test: Every byte value survives a round trip
+	var all strings.Builder
+	for i := 0; i < 256; i++ {
+		all.WriteByte(byte(i))
+	}
+	encoded := appendHuffman(nil, all.String())
+	assert.Len(t, encoded, huffmanEncodedLen(all.String()))
+	decoded, err := huffmanDecode(nil, encoded)
+	require.NoError(t, err)
+	assert.Equal(t, all.String(), string(decoded))
+
+	// Test: Padding must be short and all ones
+	_, err = huffmanDecode(nil, []byte{0xff, 0xff})
+	assert.Error(t, err)
+	_, err = huffmanDecode(nil, []byte{0x00})
+	assert.Error(t, err)
+}
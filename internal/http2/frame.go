@@ -0,0 +1,433 @@
+package http2
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// ClientPreface is what every HTTP/2 client sends before its first
+// frame (RFC 9113 3.4).
+const ClientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+const (
+	frameHeaderLen = 9
+
+	// DefaultMaxFrameSize is the largest frame payload a peer accepts
+	// until it says otherwise, and the smallest it may say.
+	DefaultMaxFrameSize = 1 << 14
+	// MaxFrameSizeLimit is the largest SETTINGS_MAX_FRAME_SIZE allowed.
+	MaxFrameSizeLimit = 1<<24 - 1
+	// DefaultWindowSize is the initial flow-control window of the
+	// connection and of every stream.
+	DefaultWindowSize = 65535
+	// MaxWindowSize is the largest a flow-control window may grow.
+	MaxWindowSize = 1<<31 - 1
+)
+
+type FrameType uint8
+
+const (
+	FrameData         FrameType = 0x0
+	FrameHeaders      FrameType = 0x1
+	FramePriority     FrameType = 0x2
+	FrameRSTStream    FrameType = 0x3
+	FrameSettings     FrameType = 0x4
+	FramePushPromise  FrameType = 0x5
+	FramePing         FrameType = 0x6
+	FrameGoAway       FrameType = 0x7
+	FrameWindowUpdate FrameType = 0x8
+	FrameContinuation FrameType = 0x9
+)
+
+var frameNames = map[FrameType]string{
+	FrameData:         "DATA",
+	FrameHeaders:      "HEADERS",
+	FramePriority:     "PRIORITY",
+	FrameRSTStream:    "RST_STREAM",
+	FrameSettings:     "SETTINGS",
+	FramePushPromise:  "PUSH_PROMISE",
+	FramePing:         "PING",
+	FrameGoAway:       "GOAWAY",
+	FrameWindowUpdate: "WINDOW_UPDATE",
+	FrameContinuation: "CONTINUATION",
+}
+
+func (t FrameType) String() string {
+	if name, ok := frameNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("FrameType(%d)", uint8(t))
+}
+
+type Flags uint8
+
+const (
+	FlagEndStream  Flags = 0x1
+	FlagAck        Flags = 0x1
+	FlagEndHeaders Flags = 0x4
+	FlagPadded     Flags = 0x8
+	FlagPriority   Flags = 0x20
+)
+
+func (f Flags) Has(flag Flags) bool {
+	return f&flag != 0
+}
+
+// ErrCode is the reason carried by RST_STREAM and GOAWAY frames.
+type ErrCode uint32
+
+const (
+	ErrCodeNo                 ErrCode = 0x0
+	ErrCodeProtocol           ErrCode = 0x1
+	ErrCodeInternal           ErrCode = 0x2
+	ErrCodeFlowControl        ErrCode = 0x3
+	ErrCodeSettingsTimeout    ErrCode = 0x4
+	ErrCodeStreamClosed       ErrCode = 0x5
+	ErrCodeFrameSize          ErrCode = 0x6
+	ErrCodeRefusedStream      ErrCode = 0x7
+	ErrCodeCancel             ErrCode = 0x8
+	ErrCodeCompression        ErrCode = 0x9
+	ErrCodeConnect            ErrCode = 0xa
+	ErrCodeEnhanceYourCalm    ErrCode = 0xb
+	ErrCodeInadequateSecurity ErrCode = 0xc
+	ErrCodeHTTP11Required     ErrCode = 0xd
+)
+
+var errCodeNames = map[ErrCode]string{
+	ErrCodeNo:                 "NO_ERROR",
+	ErrCodeProtocol:           "PROTOCOL_ERROR",
+	ErrCodeInternal:           "INTERNAL_ERROR",
+	ErrCodeFlowControl:        "FLOW_CONTROL_ERROR",
+	ErrCodeSettingsTimeout:    "SETTINGS_TIMEOUT",
+	ErrCodeStreamClosed:       "STREAM_CLOSED",
+	ErrCodeFrameSize:          "FRAME_SIZE_ERROR",
+	ErrCodeRefusedStream:      "REFUSED_STREAM",
+	ErrCodeCancel:             "CANCEL",
+	ErrCodeCompression:        "COMPRESSION_ERROR",
+	ErrCodeConnect:            "CONNECT_ERROR",
+	ErrCodeEnhanceYourCalm:    "ENHANCE_YOUR_CALM",
+	ErrCodeInadequateSecurity: "INADEQUATE_SECURITY",
+	ErrCodeHTTP11Required:     "HTTP_1_1_REQUIRED",
+}
+
+func (c ErrCode) String() string {
+	if name, ok := errCodeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("ErrCode(%#x)", uint32(c))
+}
+
+// ConnError is an error that ends the whole connection with a GOAWAY
+// (RFC 9113 5.4.1).
+type ConnError struct {
+	Code   ErrCode
+	Reason string
+}
+
+func (e ConnError) Error() string {
+	return fmt.Sprintf("connection error %v: %s", e.Code, e.Reason)
+}
+
+// StreamError is an error that ends a single stream with a RST_STREAM
+// (RFC 9113 5.4.2).
+type StreamError struct {
+	StreamID uint32
+	Code     ErrCode
+	Reason   string
+}
+
+func (e StreamError) Error() string {
+	return fmt.Sprintf("stream %d error %v: %s", e.StreamID, e.Code, e.Reason)
+}
+
+type SettingID uint16
+
+const (
+	SettingHeaderTableSize      SettingID = 0x1
+	SettingEnablePush           SettingID = 0x2
+	SettingMaxConcurrentStreams SettingID = 0x3
+	SettingInitialWindowSize    SettingID = 0x4
+	SettingMaxFrameSize         SettingID = 0x5
+	SettingMaxHeaderListSize    SettingID = 0x6
+)
+
+type Setting struct {
+	ID    SettingID
+	Value uint32
+}
+
+// Valid checks the value of the settings RFC 9113 6.5.2 constrains.
+func (s Setting) Valid() error {
+	switch {
+	case s.ID == SettingEnablePush && s.Value > 1:
+		return ConnError{ErrCodeProtocol, "invalid SETTINGS_ENABLE_PUSH"}
+	case s.ID == SettingInitialWindowSize && s.Value > MaxWindowSize:
+		return ConnError{ErrCodeFlowControl, "SETTINGS_INITIAL_WINDOW_SIZE too large"}
+	case s.ID == SettingMaxFrameSize && (s.Value < DefaultMaxFrameSize || s.Value > MaxFrameSizeLimit):
+		return ConnError{ErrCodeProtocol, "invalid SETTINGS_MAX_FRAME_SIZE"}
+	}
+	return nil
+}
+
+// Frame is a frame as read off the wire. Payload still holds any padding
+// and priority fields; see DataPayload and HeaderBlock.
+type Frame struct {
+	Type     FrameType
+	Flags    Flags
+	StreamID uint32
+	Payload  []byte
+}
+
+// Framer reads and writes frames. Reads and writes may happen
+// concurrently with each other, but not with themselves.
+type Framer struct {
+	r       io.Reader
+	w       io.Writer
+	maxRead uint32
+	rbuf    []byte
+	wbuf    []byte
+}
+
+// NewFramer returns a framer that writes to w and reads from r, accepting
+// payloads up to DefaultMaxFrameSize.
+func NewFramer(w io.Writer, r io.Reader) *Framer {
+	return &Framer{r: r, w: w, maxRead: DefaultMaxFrameSize}
+}
+
+// SetMaxReadFrameSize sets the largest payload ReadFrame accepts, which
+// should match the SETTINGS_MAX_FRAME_SIZE sent to the peer.
+func (f *Framer) SetMaxReadFrameSize(n uint32) {
+	f.maxRead = n
+}
+
+// ReadFrame reads the next frame. Its payload is only valid until the
+// next call. Frames that break the length and stream rules of their type
+// are reported as a ConnError.
+func (f *Framer) ReadFrame() (*Frame, error) {
+	var header [frameHeaderLen]byte
+	if _, err := io.ReadFull(f.r, header[:]); err != nil {
+		return nil, err
+	}
+	length := uint32(header[0])<<16 | uint32(header[1])<<8 | uint32(header[2])
+	fr := &Frame{
+		Type:     FrameType(header[3]),
+		Flags:    Flags(header[4]),
+		StreamID: binary.BigEndian.Uint32(header[5:]) & (1<<31 - 1),
+	}
+	if length > f.maxRead {
+		return nil, ConnError{ErrCodeFrameSize, fmt.Sprintf("%v frame of %d bytes", fr.Type, length)}
+	}
+	if cap(f.rbuf) < int(length) {
+		f.rbuf = make([]byte, length)
+	}
+	fr.Payload = f.rbuf[:length]
+	if _, err := io.ReadFull(f.r, fr.Payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if err := fr.validate(); err != nil {
+		return nil, err
+	}
+	return fr, nil
+}
+
+// validate checks the payload length and stream of fixed-layout frames
+// (RFC 9113 6).
+func (fr *Frame) validate() error {
+	onConn := fr.StreamID == 0
+	n := len(fr.Payload)
+	switch fr.Type {
+	case FrameData, FrameHeaders, FrameContinuation, FramePriority, FrameRSTStream, FramePushPromise:
+		if onConn {
+			return ConnError{ErrCodeProtocol, fmt.Sprintf("%v frame on stream 0", fr.Type)}
+		}
+	case FrameSettings, FramePing, FrameGoAway:
+		if !onConn {
+			return ConnError{ErrCodeProtocol, fmt.Sprintf("%v frame on stream %d", fr.Type, fr.StreamID)}
+		}
+	}
+	switch fr.Type {
+	case FramePriority:
+		if n != 5 {
+			return StreamError{fr.StreamID, ErrCodeFrameSize, "PRIORITY frame size"}
+		}
+	case FrameRSTStream, FrameWindowUpdate:
+		if n != 4 {
+			return ConnError{ErrCodeFrameSize, fmt.Sprintf("%v frame size", fr.Type)}
+		}
+	case FramePing:
+		if n != 8 {
+			return ConnError{ErrCodeFrameSize, "PING frame size"}
+		}
+	case FrameSettings:
+		if n%6 != 0 || fr.Flags.Has(FlagAck) && n != 0 {
+			return ConnError{ErrCodeFrameSize, "SETTINGS frame size"}
+		}
+	case FrameGoAway:
+		if n < 8 {
+			return ConnError{ErrCodeFrameSize, "GOAWAY frame size"}
+		}
+	}
+	return nil
+}
+
+// unpad strips the padding of a DATA, HEADERS or PUSH_PROMISE frame.
+func (fr *Frame) unpad() ([]byte, error) {
+	p := fr.Payload
+	if !fr.Flags.Has(FlagPadded) {
+		return p, nil
+	}
+	if len(p) == 0 || int(p[0]) >= len(p) {
+		return nil, ConnError{ErrCodeProtocol, "padding longer than payload"}
+	}
+	return p[1 : len(p)-int(p[0])], nil
+}
+
+// DataPayload returns the data of a DATA frame without its padding.
+func (fr *Frame) DataPayload() ([]byte, error) {
+	return fr.unpad()
+}
+
+// HeaderBlock returns the header block fragment of a HEADERS frame,
+// without padding and priority fields, or of a CONTINUATION frame.
+func (fr *Frame) HeaderBlock() ([]byte, error) {
+	if fr.Type == FrameContinuation {
+		return fr.Payload, nil
+	}
+	p, err := fr.unpad()
+	if err != nil {
+		return nil, err
+	}
+	if fr.Flags.Has(FlagPriority) {
+		if len(p) < 5 {
+			return nil, ConnError{ErrCodeProtocol, "HEADERS frame too short for priority"}
+		}
+		p = p[5:]
+	}
+	return p, nil
+}
+
+// Settings returns the parameters of a SETTINGS frame.
+func (fr *Frame) Settings() []Setting {
+	settings := make([]Setting, 0, len(fr.Payload)/6)
+	for p := fr.Payload; len(p) >= 6; p = p[6:] {
+		settings = append(settings, Setting{
+			ID:    SettingID(binary.BigEndian.Uint16(p)),
+			Value: binary.BigEndian.Uint32(p[2:]),
+		})
+	}
+	return settings
+}
+
+// ParseSettings decodes a SETTINGS payload sent some other way, such as
+// the HTTP2-Settings header of an h2c upgrade.
+func ParseSettings(payload []byte) ([]Setting, error) {
+	fr := &Frame{Type: FrameSettings, Payload: payload}
+	if err := fr.validate(); err != nil {
+		return nil, err
+	}
+	return fr.Settings(), nil
+}
+
+// WindowIncrement returns the increment of a WINDOW_UPDATE frame.
+func (fr *Frame) WindowIncrement() uint32 {
+	return binary.BigEndian.Uint32(fr.Payload) & (1<<31 - 1)
+}
+
+// ErrCode returns the error code of a RST_STREAM or GOAWAY frame.
+func (fr *Frame) ErrCode() ErrCode {
+	if fr.Type == FrameGoAway {
+		return ErrCode(binary.BigEndian.Uint32(fr.Payload[4:]))
+	}
+	return ErrCode(binary.BigEndian.Uint32(fr.Payload))
+}
+
+// LastStreamID returns the last stream ID of a GOAWAY frame.
+func (fr *Frame) LastStreamID() uint32 {
+	return binary.BigEndian.Uint32(fr.Payload) & (1<<31 - 1)
+}
+
+// WriteFrame writes a frame with the given payload.
+func (f *Framer) WriteFrame(t FrameType, flags Flags, streamID uint32, payload []byte) error {
+	n := len(payload)
+	f.wbuf = append(f.wbuf[:0],
+		byte(n>>16), byte(n>>8), byte(n),
+		byte(t), byte(flags))
+	f.wbuf = binary.BigEndian.AppendUint32(f.wbuf, streamID)
+	f.wbuf = append(f.wbuf, payload...)
+	_, err := f.w.Write(f.wbuf)
+	return err
+}
+
+func (f *Framer) WriteSettings(settings ...Setting) error {
+	payload := make([]byte, 0, 6*len(settings))
+	for _, s := range settings {
+		payload = binary.BigEndian.AppendUint16(payload, uint16(s.ID))
+		payload = binary.BigEndian.AppendUint32(payload, s.Value)
+	}
+	return f.WriteFrame(FrameSettings, 0, 0, payload)
+}
+
+func (f *Framer) WriteSettingsAck() error {
+	return f.WriteFrame(FrameSettings, FlagAck, 0, nil)
+}
+
+func (f *Framer) WritePing(ack bool, data [8]byte) error {
+	var flags Flags
+	if ack {
+		flags = FlagAck
+	}
+	return f.WriteFrame(FramePing, flags, 0, data[:])
+}
+
+func (f *Framer) WriteGoAway(lastStreamID uint32, code ErrCode, debug []byte) error {
+	payload := binary.BigEndian.AppendUint32(nil, lastStreamID)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(code))
+	return f.WriteFrame(FrameGoAway, 0, 0, append(payload, debug...))
+}
+
+func (f *Framer) WriteRSTStream(streamID uint32, code ErrCode) error {
+	return f.WriteFrame(FrameRSTStream, 0, streamID, binary.BigEndian.AppendUint32(nil, uint32(code)))
+}
+
+func (f *Framer) WriteWindowUpdate(streamID, increment uint32) error {
+	return f.WriteFrame(FrameWindowUpdate, 0, streamID, binary.BigEndian.AppendUint32(nil, increment))
+}
+
+func (f *Framer) WriteData(streamID uint32, endStream bool, data []byte) error {
+	var flags Flags
+	if endStream {
+		flags = FlagEndStream
+	}
+	return f.WriteFrame(FrameData, flags, streamID, data)
+}
+
+// WriteHeaders writes an encoded header block as a HEADERS frame,
+// followed by CONTINUATION frames if it does not fit in maxFrameSize.
+func (f *Framer) WriteHeaders(streamID uint32, endStream bool, block []byte, maxFrameSize uint32) error {
+	flags := Flags(0)
+	if endStream {
+		flags |= FlagEndStream
+	}
+	t := FrameHeaders
+	for {
+		chunk := block
+		if len(chunk) > int(maxFrameSize) {
+			chunk = chunk[:maxFrameSize]
+		}
+		block = block[len(chunk):]
+		if len(block) == 0 {
+			flags |= FlagEndHeaders
+		}
+		if err := f.WriteFrame(t, flags, streamID, chunk); err != nil {
+			return err
+		}
+		if len(block) == 0 {
+			return nil
+		}
+		t, flags = FrameContinuation, 0
+	}
+}
@@ -0,0 +1,141 @@
+package http2
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	f := NewFramer(&buf, &buf)
+
+	// Test: Every writer produces a frame that reads back
+	require.NoError(t, f.WriteSettings(Setting{SettingMaxConcurrentStreams, 100}, Setting{SettingInitialWindowSize, 1 << 20}))
+	require.NoError(t, f.WriteSettingsAck())
+	require.NoError(t, f.WritePing(true, [8]byte{1, 2, 3, 4, 5, 6, 7, 8}))
+	require.NoError(t, f.WriteGoAway(7, ErrCodeEnhanceYourCalm, []byte("calm")))
+	require.NoError(t, f.WriteRSTStream(3, ErrCodeCancel))
+	require.NoError(t, f.WriteWindowUpdate(5, 1000))
+	require.NoError(t, f.WriteData(1, true, []byte("hello")))
+
+	fr, err := f.ReadFrame()
+	require.NoError(t, err)
+	assert.Equal(t, FrameSettings, fr.Type)
+	assert.Equal(t, []Setting{{SettingMaxConcurrentStreams, 100}, {SettingInitialWindowSize, 1 << 20}}, fr.Settings())
+
+	fr, err = f.ReadFrame()
+	require.NoError(t, err)
+	assert.True(t, fr.Type == FrameSettings && fr.Flags.Has(FlagAck))
+
+	fr, err = f.ReadFrame()
+	require.NoError(t, err)
+	assert.Equal(t, FramePing, fr.Type)
+	assert.True(t, fr.Flags.Has(FlagAck))
+	assert.Equal(t, []byte{1, 2, 3, 4, 5, 6, 7, 8}, fr.Payload)
+
+	fr, err = f.ReadFrame()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(7), fr.LastStreamID())
+	assert.Equal(t, ErrCodeEnhanceYourCalm, fr.ErrCode())
+	assert.Equal(t, "calm", string(fr.Payload[8:]))
+
+	fr, err = f.ReadFrame()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(3), fr.StreamID)
+	assert.Equal(t, ErrCodeCancel, fr.ErrCode())
+
+	fr, err = f.ReadFrame()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1000), fr.WindowIncrement())
+
+	fr, err = f.ReadFrame()
+	require.NoError(t, err)
+	assert.True(t, fr.Flags.Has(FlagEndStream))
+	data, err := fr.DataPayload()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	_, err = f.ReadFrame()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestFrameHeaders(t *testing.T) {
+	// Test: Blocks larger than the frame size continue in CONTINUATION
+	var buf bytes.Buffer
+	f := NewFramer(&buf, &buf)
+	block := bytes.Repeat([]byte("x"), DefaultMaxFrameSize+10)
+	require.NoError(t, f.WriteHeaders(1, true, block, DefaultMaxFrameSize))
+	fr, err := f.ReadFrame()
+	require.NoError(t, err)
+	assert.Equal(t, FrameHeaders, fr.Type)
+	assert.True(t, fr.Flags.Has(FlagEndStream))
+	assert.False(t, fr.Flags.Has(FlagEndHeaders))
+	assert.Len(t, fr.Payload, DefaultMaxFrameSize)
+	fr, err = f.ReadFrame()
+	require.NoError(t, err)
+	assert.Equal(t, FrameContinuation, fr.Type)
+	assert.True(t, fr.Flags.Has(FlagEndHeaders))
+	assert.Len(t, fr.Payload, 10)
+
+	// Test: Padding and priority fields are stripped
+	fr = &Frame{Type: FrameHeaders, Flags: FlagPadded | FlagPriority, StreamID: 1,
+		Payload: append([]byte{2, 0, 0, 0, 3, 16}, 'a', 'b', 0, 0)}
+	got, err := fr.HeaderBlock()
+	require.NoError(t, err)
+	assert.Equal(t, "ab", string(got))
+
+	fr = &Frame{Type: FrameData, Flags: FlagPadded, StreamID: 1, Payload: []byte{5, 'a'}}
+	_, err = fr.DataPayload()
+	assert.Error(t, err)
+}
+
+func TestFrameValidation(t *testing.T) {
+	read := func(raw string) error {
+		b, err := hex.DecodeString(raw)
+		require.NoError(t, err)
+		_, err = NewFramer(io.Discard, bytes.NewReader(b)).ReadFrame()
+		return err
+	}
+	var connErr ConnError
+
+	// Test: Frames over the maximum size are FRAME_SIZE_ERRORs
+	err := read("004001" + "00" + "00" + "00000001")
+	require.ErrorAs(t, err, &connErr)
+	assert.Equal(t, ErrCodeFrameSize, connErr.Code)
+
+	// Test: Stream-level frames on stream 0 and connection-level frames
+	// on a stream are PROTOCOL_ERRORs
+	err = read("000000" + "00" + "00" + "00000000")
+	require.ErrorAs(t, err, &connErr)
+	assert.Equal(t, ErrCodeProtocol, connErr.Code)
+	err = read("000000" + "04" + "00" + "00000001")
+	require.ErrorAs(t, err, &connErr)
+	assert.Equal(t, ErrCodeProtocol, connErr.Code)
+
+	// Test: Fixed-size frames with the wrong size
+	err = read("000007" + "06" + "00" + "00000000" + "00000000000000")
+	require.ErrorAs(t, err, &connErr)
+	assert.Equal(t, ErrCodeFrameSize, connErr.Code)
+	err = read("000006" + "04" + "01" + "00000000" + "000100000000")
+	require.ErrorAs(t, err, &connErr)
+	assert.Equal(t, ErrCodeFrameSize, connErr.Code)
+	var streamErr StreamError
+	err = read("000004" + "02" + "00" + "00000003" + "00000000")
+	require.ErrorAs(t, err, &streamErr)
+	assert.Equal(t, uint32(3), streamErr.StreamID)
+
+	// Test: Truncated payloads
+	err = read("000008" + "06" + "00" + "00000000" + "0000")
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// Test: Setting values outside their range
+	assert.Error(t, Setting{SettingEnablePush, 2}.Valid())
+	assert.Error(t, Setting{SettingInitialWindowSize, 1 << 31}.Valid())
+	assert.Error(t, Setting{SettingMaxFrameSize, 100}.Valid())
+	assert.NoError(t, Setting{SettingMaxFrameSize, 1 << 20}.Valid())
+}
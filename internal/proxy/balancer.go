@@ -0,0 +1,367 @@
+package proxy
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"main/internal/request"
+	"main/internal/response"
+	"main/internal/server"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultMaxFailures    = 3
+	defaultEjectionPeriod = 30 * time.Second
+	defaultRetries        = 1
+)
+
+// backend is one upstream of a Pool.
+type backend struct {
+	addr string
+	// active counts requests currently being served by the backend.
+	active atomic.Int64
+	// healthy is the verdict of the last active health check. Backends
+	// start out healthy.
+	healthy atomic.Bool
+
+	mu           sync.Mutex
+	failures     int
+	ejectedUntil time.Time
+}
+
+// available reports whether the backend may be picked at now.
+func (b *backend) available(now time.Time) bool {
+	if !b.healthy.Load() {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !now.Before(b.ejectedUntil)
+}
+
+// Strategy chooses the backend for a request among the available ones.
+type Strategy interface {
+	pick(candidates []*backend, req *request.Request) *backend
+}
+
+type roundRobin struct {
+	next atomic.Uint64
+}
+
+// RoundRobin hands requests to each backend in turn.
+func RoundRobin() Strategy {
+	return &roundRobin{}
+}
+
+func (s *roundRobin) pick(candidates []*backend, _ *request.Request) *backend {
+	n := s.next.Add(1) - 1
+	return candidates[n%uint64(len(candidates))]
+}
+
+type leastConnections struct{}
+
+// LeastConnections hands each request to the backend with the fewest
+// requests in flight.
+func LeastConnections() Strategy {
+	return leastConnections{}
+}
+
+func (leastConnections) pick(candidates []*backend, _ *request.Request) *backend {
+	best := candidates[0]
+	for _, b := range candidates[1:] {
+		if b.active.Load() < best.active.Load() {
+			best = b
+		}
+	}
+	return best
+}
+
+type consistentHash struct {
+	key func(req *request.Request) string
+}
+
+// HashByHeader sends requests with the same value of the named header to
+// the same backend for as long as it stays available.
+func HashByHeader(name string) Strategy {
+	return consistentHash{key: func(req *request.Request) string {
+		return req.Headers.Get(name)
+	}}
+}
+
+// HashByPath sends requests for the same path, ignoring the query, to the
+// same backend for as long as it stays available.
+func HashByPath() Strategy {
+	return consistentHash{key: func(req *request.Request) string {
+		path, _, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+		return path
+	}}
+}
+
+// pick uses rendezvous hashing: every backend is scored against the key
+// and the highest score wins, so a backend leaving only moves the keys
+// that were on it.
+func (s consistentHash) pick(candidates []*backend, req *request.Request) *backend {
+	key := s.key(req)
+	var best *backend
+	var bestScore uint64
+	for _, b := range candidates {
+		h := fnv.New64a()
+		_, _ = io.WriteString(h, key)
+		_, _ = h.Write([]byte{0})
+		_, _ = io.WriteString(h, b.addr)
+		if score := h.Sum64(); best == nil || score > bestScore {
+			best, bestScore = b, score
+		}
+	}
+	return best
+}
+
+// Pool spreads requests over a set of upstreams, leaving out those that
+// fail health checks or keep failing requests.
+type Pool struct {
+	backends []*backend
+	strategy Strategy
+
+	checkPath     string
+	checkInterval time.Duration
+	checkTimeout  time.Duration
+
+	maxFailures    int
+	ejectionPeriod time.Duration
+	retries        int
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// PoolOption configures a Pool.
+type PoolOption func(*Pool)
+
+// WithStrategy sets how backends are chosen. The default is RoundRobin.
+func WithStrategy(s Strategy) PoolOption {
+	return func(p *Pool) {
+		p.strategy = s
+	}
+}
+
+// WithHealthCheck sends GET path to every backend each interval. A
+// backend answering with anything but 2xx or 3xx, or not answering within
+// the interval, is taken out of rotation until a later check passes.
+func WithHealthCheck(path string, interval time.Duration) PoolOption {
+	return func(p *Pool) {
+		p.checkPath = path
+		p.checkInterval = interval
+		p.checkTimeout = interval
+	}
+}
+
+// WithPassiveEjection takes a backend out of rotation for period after
+// maxFailures consecutive requests to it failed to get a response.
+func WithPassiveEjection(maxFailures int, period time.Duration) PoolOption {
+	return func(p *Pool) {
+		p.maxFailures = maxFailures
+		p.ejectionPeriod = period
+	}
+}
+
+// WithRetries sets how many other backends an idempotent request without
+// a body is tried on after the first one failed.
+func WithRetries(n int) PoolOption {
+	return func(p *Pool) {
+		p.retries = n
+	}
+}
+
+// NewPool returns a pool over the "host:port" addresses in upstreams and
+// starts its health checks, if any. Close stops them.
+func NewPool(upstreams []string, opts ...PoolOption) *Pool {
+	p := &Pool{
+		strategy:       RoundRobin(),
+		maxFailures:    defaultMaxFailures,
+		ejectionPeriod: defaultEjectionPeriod,
+		retries:        defaultRetries,
+		stop:           make(chan struct{}),
+	}
+	for _, addr := range upstreams {
+		b := &backend{addr: addr}
+		b.healthy.Store(true)
+		p.backends = append(p.backends, b)
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	if p.checkPath != "" && p.checkInterval > 0 {
+		p.wg.Add(1)
+		go p.healthCheckLoop()
+	}
+	return p
+}
+
+// Close stops the health checks. Closing a pool again does nothing.
+func (p *Pool) Close() error {
+	p.stopOnce.Do(func() { close(p.stop) })
+	p.wg.Wait()
+	return nil
+}
+
+// Available returns the addresses of the backends currently in rotation.
+func (p *Pool) Available() []string {
+	now := time.Now()
+	var addrs []string
+	for _, b := range p.backends {
+		if b.available(now) {
+			addrs = append(addrs, b.addr)
+		}
+	}
+	return addrs
+}
+
+// pick chooses a backend for req, skipping those already tried. It
+// returns nil when none is left.
+func (p *Pool) pick(req *request.Request, tried []*backend) *backend {
+	now := time.Now()
+	var candidates []*backend
+	for _, b := range p.backends {
+		if b.available(now) && !slices.Contains(tried, b) {
+			candidates = append(candidates, b)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	return p.strategy.pick(candidates, req)
+}
+
+func (p *Pool) succeeded(b *backend) {
+	b.mu.Lock()
+	b.failures = 0
+	b.mu.Unlock()
+}
+
+func (p *Pool) failed(b *backend) {
+	if p.maxFailures <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.failures >= p.maxFailures {
+		b.ejectedUntil = time.Now().Add(p.ejectionPeriod)
+		b.failures = 0
+	}
+}
+
+func (p *Pool) healthCheckLoop() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.checkInterval)
+	defer ticker.Stop()
+	for {
+		p.checkAll()
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Pool) checkAll() {
+	var wg sync.WaitGroup
+	for _, b := range p.backends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			healthy := p.check(b.addr) == nil
+			if healthy && !b.healthy.Load() {
+				// A backend that recovers gets a clean slate.
+				b.mu.Lock()
+				b.failures = 0
+				b.ejectedUntil = time.Time{}
+				b.mu.Unlock()
+			}
+			b.healthy.Store(healthy)
+		}()
+	}
+	wg.Wait()
+}
+
+// check sends one health check request to addr.
+func (p *Pool) check(addr string) error {
+	conn, err := net.DialTimeout("tcp", addr, p.checkTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(p.checkTimeout)); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n\r\n", p.checkPath, addr)
+	if err != nil {
+		return err
+	}
+	resp, err := response.ResponseFromReader(conn)
+	if err != nil {
+		return err
+	}
+	if status := resp.StatusLine.StatusCode; status < 200 || status >= 400 {
+		return fmt.Errorf("health check got %d", status)
+	}
+	return nil
+}
+
+// idempotentMethods may be sent again without changing the outcome
+// (RFC 9110 9.2.2).
+var idempotentMethods = []string{"GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE"}
+
+// PoolHandler forwards every request to a backend of pool. Requests that
+// fail to get a response are retried on another backend when that is
+// safe: the method is idempotent and there is no body that was already
+// consumed. When no backend is available the answer is 503.
+func PoolHandler(pool *Pool, opts ...Option) server.Handler {
+	cfg := newConfig(opts)
+	return func(w response.Writer, req *request.Request) {
+		attempts := 1
+		if req.Body == request.NoBody && slices.Contains(idempotentMethods, req.RequestLine.Method) {
+			attempts += pool.retries
+		}
+
+		var tried []*backend
+		var lastErr error
+		for range attempts {
+			b := pool.pick(req, tried)
+			if b == nil {
+				break
+			}
+			tried = append(tried, b)
+
+			b.active.Add(1)
+			resp, err := cfg.roundTrip(w, req, b.addr)
+			if err != nil {
+				b.active.Add(-1)
+				pool.failed(b)
+				cfg.logger.Warn("upstream request failed", "upstream", b.addr, "error", err)
+				lastErr = err
+				continue
+			}
+			pool.succeeded(b)
+			defer b.active.Add(-1)
+			defer resp.Close()
+			cfg.relay(w, resp)
+			return
+		}
+
+		code := response.StatusServiceUnavailable
+		if lastErr != nil {
+			code = errorStatus(lastErr)
+		}
+		_ = response.Error(w, code, response.StatusText(code)+"\n")
+	}
+}
@@ -0,0 +1,176 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"main/internal/headers"
+	"main/internal/request"
+	"main/internal/response"
+	"main/internal/server"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// namedUpstream answers every request with its name, except the health
+// check path, which answers with whatever healthy holds.
+func namedUpstream(t *testing.T, name string, healthy *atomic.Bool) string {
+	t.Helper()
+	return startUpstream(t, func(w response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/healthz" && healthy != nil && !healthy.Load() {
+			_ = response.Error(w, response.StatusServiceUnavailable, "down")
+			return
+		}
+		_ = response.Error(w, response.StatusOK, name)
+	})
+}
+
+// deadAddr returns an address nothing listens on.
+func deadAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+// get sends requests over one keep-alive connection to a proxy in front
+// of pool and returns the response bodies.
+func get(t *testing.T, pool *Pool, raws ...string) []string {
+	t.Helper()
+	s, err := server.Serve(0, PoolHandler(pool))
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	conn, err := net.Dial("tcp", loopback(s))
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	br := bufio.NewReader(conn)
+	var bodies []string
+	for _, raw := range raws {
+		_, err = io.WriteString(conn, raw)
+		require.NoError(t, err)
+		resp, err := http.ReadResponse(br, nil)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		if resp.StatusCode != 200 {
+			body = []byte(resp.Status)
+		}
+		bodies = append(bodies, string(body))
+	}
+	return bodies
+}
+
+const plainGet = "GET / HTTP/1.1\r\n\r\n"
+
+func TestPoolStrategies(t *testing.T) {
+	a := namedUpstream(t, "a", nil)
+	b := namedUpstream(t, "b", nil)
+	c := namedUpstream(t, "c", nil)
+
+	// Test: Round-robin visits each backend in turn
+	pool := NewPool([]string{a, b, c})
+	defer pool.Close()
+	assert.Equal(t, []string{"a", "b", "c", "a"}, get(t, pool, plainGet, plainGet, plainGet, plainGet))
+
+	// Test: Hashing by header keeps a key on one backend
+	pool = NewPool([]string{a, b, c}, WithStrategy(HashByHeader("X-User")))
+	defer pool.Close()
+	user1 := "GET / HTTP/1.1\r\nX-User: alice\r\n\r\n"
+	bodies := get(t, pool, user1, user1, user1)
+	assert.Equal(t, bodies[0], bodies[1])
+	assert.Equal(t, bodies[0], bodies[2])
+
+	// Test: Hashing by path ignores the query
+	pool = NewPool([]string{a, b, c}, WithStrategy(HashByPath()))
+	defer pool.Close()
+	bodies = get(t, pool, "GET /p?x=1 HTTP/1.1\r\n\r\n", "GET /p?x=2 HTTP/1.1\r\n\r\n")
+	assert.Equal(t, bodies[0], bodies[1])
+}
+
+func TestConsistentHashStability(t *testing.T) {
+	// Test: Removing a backend only moves the keys that were on it
+	backends := []*backend{{addr: "a:1"}, {addr: "b:1"}, {addr: "c:1"}, {addr: "d:1"}}
+	s := HashByPath()
+	moved := 0
+	for i := range 200 {
+		req := &request.Request{RequestLine: request.RequestLine{RequestTarget: "/k" + string(rune('a'+i%26)) + string(rune('a'+i/26))}}
+		before := s.pick(backends, req)
+		after := s.pick(backends[:3], req)
+		if before != backends[3] {
+			assert.Equal(t, before, after)
+		} else {
+			moved++
+		}
+	}
+	assert.Greater(t, moved, 0)
+}
+
+func TestLeastConnections(t *testing.T) {
+	// Test: The backend with the fewest requests in flight wins
+	backends := []*backend{{addr: "a"}, {addr: "b"}, {addr: "c"}}
+	backends[0].active.Store(3)
+	backends[1].active.Store(1)
+	backends[2].active.Store(2)
+	assert.Equal(t, backends[1], LeastConnections().pick(backends, &request.Request{Headers: headers.NewHeaders()}))
+}
+
+func TestPoolFailures(t *testing.T) {
+	a := namedUpstream(t, "a", nil)
+	dead := deadAddr(t)
+
+	// Test: Idempotent requests are retried on another backend
+	pool := NewPool([]string{dead, a}, WithPassiveEjection(0, 0))
+	defer pool.Close()
+	assert.Equal(t, []string{"a", "a"}, get(t, pool, plainGet, plainGet))
+
+	// Test: Requests with a body are not retried
+	pool = NewPool([]string{dead, a}, WithPassiveEjection(0, 0))
+	defer pool.Close()
+	post := "POST / HTTP/1.1\r\nContent-Length: 2\r\n\r\nhi"
+	assert.Equal(t, []string{"502 Bad Gateway"}, get(t, pool, post))
+
+	// Test: Consecutive failures eject a backend
+	pool = NewPool([]string{dead, a}, WithPassiveEjection(2, time.Minute), WithRetries(0))
+	defer pool.Close()
+	bodies := get(t, pool, plainGet, plainGet, plainGet, plainGet, plainGet)
+	assert.Equal(t, []string{"502 Bad Gateway", "a", "502 Bad Gateway", "a", "a"}, bodies)
+	assert.Equal(t, []string{a}, pool.Available())
+
+	// Test: No backend left gives 503
+	pool = NewPool([]string{dead}, WithPassiveEjection(1, time.Minute), WithRetries(0))
+	defer pool.Close()
+	assert.Equal(t, []string{"502 Bad Gateway", "503 Service Unavailable"}, get(t, pool, plainGet, plainGet))
+}
+
+func TestPoolHealthChecks(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+	a := namedUpstream(t, "a", &healthy)
+	b := namedUpstream(t, "b", nil)
+
+	pool := NewPool([]string{a, b}, WithHealthCheck("/healthz", 10*time.Millisecond))
+	defer pool.Close()
+
+	// Test: Failing checks take a backend out of rotation
+	healthy.Store(false)
+	require.Eventually(t, func() bool { return len(pool.Available()) == 1 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []string{b}, pool.Available())
+	assert.Equal(t, []string{"b", "b"}, get(t, pool, plainGet, plainGet))
+
+	// Test: Passing checks bring it back
+	healthy.Store(true)
+	require.Eventually(t, func() bool { return len(pool.Available()) == 2 }, time.Second, 5*time.Millisecond)
+
+	// Test: Closing twice is harmless
+	require.NoError(t, pool.Close())
+	require.NoError(t, pool.Close())
+}
@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"io"
+	"main/internal/request"
+	"main/internal/response"
+	"main/internal/server"
+	"maps"
+	"net"
+	"net/url"
+	"sync"
+)
+
+// ForwardHandler acts as an HTTP proxy for clients configured to use the
+// server as one. Requests in absolute form, such as
+// "GET http://example.com/ HTTP/1.1", are forwarded to the named host,
+// and CONNECT requests open a tunnel to host:port. The server must accept
+// CONNECT through request.WithMethods.
+func ForwardHandler(opts ...Option) server.Handler {
+	cfg := newConfig(opts)
+	return func(w response.Writer, req *request.Request) {
+		if req.RequestLine.Method == "CONNECT" {
+			cfg.tunnel(w, req)
+			return
+		}
+
+		u, err := url.Parse(req.RequestLine.RequestTarget)
+		if err != nil || u.Scheme != "http" || u.Host == "" {
+			_ = response.Error(w, response.StatusBadRequest, "Request target must be an absolute http URL\n")
+			return
+		}
+		addr := u.Host
+		if u.Port() == "" {
+			addr = net.JoinHostPort(u.Hostname(), "80")
+		}
+
+		// The upstream is an origin server, which expects the origin form.
+		out := *req
+		out.RequestLine.RequestTarget = u.RequestURI()
+		out.Headers = maps.Clone(req.Headers)
+		out.Headers.Set("Host", u.Host)
+
+		resp, err := cfg.roundTrip(w, &out, addr)
+		if err != nil {
+			cfg.logger.Warn("upstream request failed", "upstream", addr, "error", err)
+			_ = response.Error(w, errorStatus(err), response.StatusText(errorStatus(err))+"\n")
+			return
+		}
+		defer resp.Close()
+		cfg.relay(w, resp)
+	}
+}
+
+// tunnel answers CONNECT by dialing the target and splicing the client
+// connection to it until either side is done.
+func (c *config) tunnel(w response.Writer, req *request.Request) {
+	addr := req.RequestLine.RequestTarget
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		_ = response.Error(w, response.StatusBadRequest, "CONNECT target must be host:port\n")
+		return
+	}
+	hj, ok := w.(response.Hijacker)
+	if !ok {
+		_ = response.Error(w, response.StatusInternalServerError, "Tunnelling not supported\n")
+		return
+	}
+
+	target, err := net.DialTimeout("tcp", addr, c.dialTimeout)
+	if err != nil {
+		c.logger.Warn("tunnel dial failed", "target", addr, "error", err)
+		_ = response.Error(w, errorStatus(err), response.StatusText(errorStatus(err))+"\n")
+		return
+	}
+	client, rw, err := hj.Hijack()
+	if err != nil {
+		target.Close()
+		_ = response.Error(w, response.StatusInternalServerError, "Tunnelling not supported\n")
+		return
+	}
+
+	// Written by hand: the writer is gone, and the reason phrase is not
+	// the usual one for 200.
+	_, err = rw.WriteString("HTTP/1.1 200 Connection Established\r\n\r\n")
+	if err == nil {
+		err = rw.Flush()
+	}
+	if err != nil {
+		client.Close()
+		target.Close()
+		return
+	}
+	// rw.Reader may hold bytes the client sent right after its request.
+	splice(client, rw.Reader, target)
+}
+
+// splice copies between the two connections in both directions. When one
+// direction ends, the write side of its destination is shut down so the
+// peer sees EOF; both connections are closed once both directions are
+// done.
+func splice(client net.Conn, clientReader io.Reader, target net.Conn) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(target, clientReader)
+		closeWrite(target)
+	}()
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(client, target)
+		closeWrite(client)
+	}()
+	wg.Wait()
+	client.Close()
+	target.Close()
+}
+
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		_ = cw.CloseWrite()
+		return
+	}
+	conn.Close()
+}
@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"main/internal/request"
+	"main/internal/response"
+	"main/internal/server"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startForwardProxy returns a connection to a forward proxy.
+func startForwardProxy(t *testing.T) net.Conn {
+	t.Helper()
+	s, err := server.Serve(0, ForwardHandler(), server.WithRequestOptions(request.WithMethods("CONNECT")))
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	conn, err := net.Dial("tcp", loopback(s))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+	return conn
+}
+
+func TestForwardProxy(t *testing.T) {
+	var seen *request.Request
+	upstream := startUpstream(t, func(w response.Writer, req *request.Request) {
+		seen = req
+		_ = response.Error(w, response.StatusOK, "origin")
+	})
+
+	// Test: Absolute-form targets are forwarded in origin form
+	conn := startForwardProxy(t)
+	br := bufio.NewReader(conn)
+	_, err := io.WriteString(conn, "GET http://"+upstream+"/path?q=1 HTTP/1.1\r\nHost: "+upstream+"\r\nProxy-Connection: keep-alive\r\n\r\n")
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "origin", readBody(t, resp))
+	require.NotNil(t, seen)
+	assert.Equal(t, "/path?q=1", seen.RequestLine.RequestTarget)
+	assert.Equal(t, upstream, seen.Headers.Get("Host"))
+	assert.Empty(t, seen.Headers.Get("Proxy-Connection"))
+
+	// Test: Origin-form targets are not proxy requests
+	_, err = io.WriteString(conn, "GET /path HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+	resp, err = http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
+func TestConnectTunnel(t *testing.T) {
+	target := rawUpstream(t, func(conn net.Conn) {
+		// Echo each line back until the client is done.
+		br := bufio.NewReader(conn)
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				return
+			}
+			_, _ = io.WriteString(conn, "echo "+line)
+		}
+	})
+
+	// Test: CONNECT replies 200 and splices both directions, including
+	// bytes sent right behind the request
+	conn := startForwardProxy(t)
+	br := bufio.NewReader(conn)
+	_, err := io.WriteString(conn, "CONNECT "+target+" HTTP/1.1\r\nHost: "+target+"\r\n\r\nfirst\n")
+	require.NoError(t, err)
+	status, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 Connection Established\r\n", status)
+	blank, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "\r\n", blank)
+
+	line, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "echo first\n", line)
+	_, err = io.WriteString(conn, "second\n")
+	require.NoError(t, err)
+	line, err = br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "echo second\n", line)
+
+	// Test: Closing the client side ends the tunnel
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+	rest, err := io.ReadAll(br)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+
+	// Test: Unreachable targets get 502 and malformed ones 400
+	conn = startForwardProxy(t)
+	br = bufio.NewReader(conn)
+	_, err = io.WriteString(conn, "CONNECT "+deadAddr(t)+" HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 502, resp.StatusCode)
+	_, _ = io.ReadAll(resp.Body)
+
+	_, err = io.WriteString(conn, "CONNECT no-port HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+	resp, err = http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 400, resp.StatusCode)
+}
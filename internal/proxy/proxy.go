@@ -0,0 +1,309 @@
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"main/internal/headers"
+	"main/internal/request"
+	"main/internal/response"
+	"main/internal/server"
+	"maps"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultDialTimeout     = 10 * time.Second
+	defaultResponseTimeout = 30 * time.Second
+)
+
+// copyBufferSize is the size of the buffer bodies are relayed through.
+const copyBufferSize = 32 << 10
+
+// hopHeaders apply to a single connection and are never forwarded
+// (RFC 9110 7.6.1). Expect is answered by the server in front of the
+// proxy, so it is dropped as well.
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"TE",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+	"Expect",
+}
+
+type config struct {
+	dialTimeout     time.Duration
+	responseTimeout time.Duration
+	logger          *slog.Logger
+}
+
+// Option configures a proxy handler.
+type Option func(*config)
+
+// WithDialTimeout bounds how long connecting to an upstream may take.
+func WithDialTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.dialTimeout = d
+	}
+}
+
+// WithResponseTimeout bounds how long the upstream may take to send the
+// head of its response once the request has been written. Requests that
+// run out of time are answered with 504.
+func WithResponseTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.responseTimeout = d
+	}
+}
+
+// WithLogger sets the logger for upstream failures. Proxies are silent by
+// default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *config) {
+		if logger != nil {
+			c.logger = logger
+		}
+	}
+}
+
+func newConfig(opts []Option) *config {
+	cfg := &config{
+		dialTimeout:     defaultDialTimeout,
+		responseTimeout: defaultResponseTimeout,
+		logger:          slog.New(slog.DiscardHandler),
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// Handler forwards every request to upstream, a "host:port" address, and
+// relays the response back. Each request uses its own upstream
+// connection.
+func Handler(upstream string, opts ...Option) server.Handler {
+	cfg := newConfig(opts)
+	return func(w response.Writer, req *request.Request) {
+		resp, err := cfg.roundTrip(w, req, upstream)
+		if err != nil {
+			cfg.logger.Warn("upstream request failed", "upstream", upstream, "error", err)
+			_ = response.Error(w, errorStatus(err), response.StatusText(errorStatus(err))+"\n")
+			return
+		}
+		defer resp.Close()
+		cfg.relay(w, resp)
+	}
+}
+
+// errorStatus picks 504 for upstreams that ran out of time and 502 for
+// every other failure.
+func errorStatus(err error) response.StatusCode {
+	var netErr net.Error
+	if errors.Is(err, os.ErrDeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout() {
+		return response.StatusGatewayTimeout
+	}
+	return response.StatusBadGateway
+}
+
+// upstreamResponse is a response read from an upstream connection. Its
+// body is read lazily; Close releases the connection.
+type upstreamResponse struct {
+	*response.Response
+	conn net.Conn
+}
+
+func (r *upstreamResponse) Close() error {
+	return r.conn.Close()
+}
+
+// roundTrip sends req to addr and reads the head of the response.
+// Interim responses other than 100 Continue, which the server in front
+// has already dealt with, are passed on to w.
+func (c *config) roundTrip(w response.Writer, req *request.Request, addr string) (*upstreamResponse, error) {
+	conn, err := net.DialTimeout("tcp", addr, c.dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.exchange(w, conn, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *config) exchange(w response.Writer, conn net.Conn, req *request.Request) (*upstreamResponse, error) {
+	if err := writeRequest(conn, req); err != nil {
+		return nil, fmt.Errorf("error writing request: %w", err)
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(c.responseTimeout)); err != nil {
+		return nil, err
+	}
+	br := bufio.NewReaderSize(conn, copyBufferSize)
+	for {
+		resp, err := response.ResponseFromReader(br, response.WithRequestMethod(req.RequestLine.Method))
+		if err != nil {
+			return nil, fmt.Errorf("error reading response: %w", err)
+		}
+		status := resp.StatusLine.StatusCode
+		if status == response.StatusSwitchingProtocols {
+			// Upgrade is never forwarded, so this is a broken upstream.
+			return nil, errors.New("unexpected 101 Switching Protocols")
+		}
+		if status >= 200 {
+			if err := conn.SetReadDeadline(time.Time{}); err != nil {
+				return nil, err
+			}
+			return &upstreamResponse{Response: resp, conn: conn}, nil
+		}
+		if status != response.StatusContinue {
+			removeHopHeaders(resp.Headers)
+			_ = w.WriteInterim(status, resp.Headers)
+		}
+	}
+}
+
+// writeRequest sends req upstream. The request keeps its target and
+// method; hop-by-hop fields are replaced by the proxy's own, and the
+// forwarding fields gain the client's address.
+func writeRequest(conn net.Conn, req *request.Request) error {
+	h := maps.Clone(req.Headers)
+	removeHopHeaders(h)
+	addForwarded(h, req)
+	h.Set("Connection", "close")
+
+	hasBody := req.Body != request.NoBody
+	chunked := hasBody && h.Get("Content-Length") == ""
+	if chunked {
+		h.Set("Transfer-Encoding", "chunked")
+	}
+
+	bw := bufio.NewWriterSize(conn, copyBufferSize)
+	fmt.Fprintf(bw, "%s %s HTTP/1.1\r\n", req.RequestLine.Method, req.RequestLine.RequestTarget)
+	if _, err := h.WriteTo(bw); err != nil {
+		return err
+	}
+	if hasBody {
+		var dst io.Writer = bw
+		if chunked {
+			dst = request.NewChunkedWriter(bw)
+		}
+		buf := make([]byte, copyBufferSize)
+		if _, err := io.CopyBuffer(dst, req.Body, buf); err != nil {
+			return err
+		}
+		if chunked {
+			if _, err := io.WriteString(bw, "0\r\n\r\n"); err != nil {
+				return err
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// relay streams resp to the client. Bodies of known length keep their
+// Content-Length; anything else is sent chunked. If the upstream body
+// breaks off, the client connection is dropped so the response is seen
+// as incomplete.
+func (c *config) relay(w response.Writer, resp *upstreamResponse) {
+	h := maps.Clone(resp.Headers)
+	removeHopHeaders(h)
+	chunked := resp.Body != request.NoBody && h.Get("Content-Length") == ""
+	if chunked {
+		h.Set("Transfer-Encoding", "chunked")
+	}
+
+	if err := w.WriteStatusLine(resp.StatusLine.StatusCode); err != nil {
+		return
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return
+	}
+
+	dst := response.NewBodyWriter(w)
+	if chunked {
+		dst = response.NewChunkedBodyWriter(w)
+	}
+	buf := make([]byte, copyBufferSize)
+	if _, err := io.CopyBuffer(dst, resp.Body, buf); err != nil {
+		c.logger.Warn("error relaying upstream body", "error", err)
+		panic(server.ErrAbortHandler)
+	}
+}
+
+// removeHopHeaders deletes the hop-by-hop fields from h, including any
+// named in its Connection field.
+func removeHopHeaders(h headers.Headers) {
+	for _, name := range strings.Split(h.Get("Connection"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			h.Delete(name)
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Delete(name)
+	}
+}
+
+// addForwarded appends the client to X-Forwarded-For and Forwarded, and
+// sets X-Forwarded-Proto to the scheme the client used to reach us.
+func addForwarded(h headers.Headers, req *request.Request) {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return
+	}
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+	h.Add("X-Forwarded-For", host)
+	h.Set("X-Forwarded-Proto", proto)
+
+	node := host
+	if strings.Contains(host, ":") {
+		// IPv6 addresses are bracketed and quoted (RFC 7239 6).
+		node = `"[` + host + `]"`
+	}
+	element := "for=" + node + ";proto=" + proto
+	if quoted, ok := quoteForwarded(req.Headers.Get("Host")); ok {
+		element += ";host=" + quoted
+	}
+	h.Add("Forwarded", element)
+}
+
+// quoteForwarded returns value as a Forwarded parameter value: as is if
+// it is a token, otherwise as a quoted-string with '"' and '\' escaped.
+// Empty values and values with control characters, which a quoted-string
+// cannot carry, are refused.
+func quoteForwarded(value string) (string, bool) {
+	if value == "" {
+		return "", false
+	}
+	if headers.IsToken(value) {
+		return value, true
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c < ' ' && c != '\t', c == 0x7f:
+			return "", false
+		case c == '"', c == '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	b.WriteByte('"')
+	return b.String(), true
+}
@@ -0,0 +1,262 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"main/internal/headers"
+	"main/internal/request"
+	"main/internal/response"
+	"main/internal/server"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startUpstream serves handler and returns its loopback address.
+func startUpstream(t *testing.T, handler server.Handler, opts ...server.Option) string {
+	t.Helper()
+	s, err := server.Serve(0, handler, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return loopback(s)
+}
+
+// rawUpstream answers every connection with serve and returns its address.
+func rawUpstream(t *testing.T, serve func(conn net.Conn)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				serve(conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func loopback(s *server.Server) string {
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(s.Addr().(*net.TCPAddr).Port))
+}
+
+// roundTrip sends raw through a proxy in front of upstream and parses the
+// final response.
+func roundTrip(t *testing.T, upstream, raw string, opts ...Option) *http.Response {
+	t.Helper()
+	responses := roundTripAll(t, upstream, raw, opts...)
+	return responses[len(responses)-1]
+}
+
+// roundTripAll is roundTrip returning the interim responses as well.
+func roundTripAll(t *testing.T, upstream, raw string, opts ...Option) []*http.Response {
+	t.Helper()
+	s, err := server.Serve(0, Handler(upstream, opts...))
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	conn, err := net.Dial("tcp", loopback(s))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	_, err = io.WriteString(conn, raw)
+	require.NoError(t, err)
+	method, _, _ := strings.Cut(raw, " ")
+	br := bufio.NewReader(conn)
+	var responses []*http.Response
+	for {
+		resp, err := http.ReadResponse(br, &http.Request{Method: method})
+		require.NoError(t, err)
+		responses = append(responses, resp)
+		if resp.StatusCode >= 200 {
+			return responses
+		}
+	}
+}
+
+func readBody(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestReverseProxy(t *testing.T) {
+	var seen *request.Request
+	var seenBody string
+	upstream := startUpstream(t, func(w response.Writer, req *request.Request) {
+		body, _ := io.ReadAll(req.Body)
+		seen, seenBody = req, string(body)
+		h := response.GetDefaultHeaders(len("from upstream"))
+		h.Set("Connection", "X-Internal")
+		h.Set("X-Internal", "secret")
+		h.Set("Keep-Alive", "timeout=5")
+		h.Set("X-App", "yes")
+		_ = w.WriteStatusLine(response.StatusCreated)
+		_ = w.WriteHeaders(h)
+		_, _ = w.WriteBody([]byte("from upstream"))
+	})
+
+	// Test: Request and response are forwarded, hop-by-hop fields stripped
+	resp := roundTrip(t, upstream, "POST /items?x=1 HTTP/1.1\r\nHost: example.com\r\n"+
+		"Connection: keep-alive, X-Hop\r\nX-Hop: 1\r\nTE: trailers\r\nProxy-Authorization: Basic abc\r\n"+
+		"X-Forwarded-For: 10.0.0.1\r\nContent-Length: 5\r\n\r\nhello")
+	assert.Equal(t, 201, resp.StatusCode)
+	assert.Equal(t, "from upstream", readBody(t, resp))
+	assert.Equal(t, "yes", resp.Header.Get("X-App"))
+	assert.Empty(t, resp.Header.Get("X-Internal"))
+	assert.Empty(t, resp.Header.Get("Keep-Alive"))
+
+	require.NotNil(t, seen)
+	assert.Equal(t, "POST", seen.RequestLine.Method)
+	assert.Equal(t, "/items?x=1", seen.RequestLine.RequestTarget)
+	assert.Equal(t, "hello", seenBody)
+	assert.Equal(t, "example.com", seen.Headers.Get("Host"))
+	assert.Empty(t, seen.Headers.Get("X-Hop"))
+	assert.Empty(t, seen.Headers.Get("TE"))
+	assert.Empty(t, seen.Headers.Get("Proxy-Authorization"))
+	assert.Equal(t, "10.0.0.1, 127.0.0.1", seen.Headers.Get("X-Forwarded-For"))
+	assert.Equal(t, "for=127.0.0.1;proto=http;host=example.com", seen.Headers.Get("Forwarded"))
+
+	// Test: Requests without a body are sent without one
+	resp = roundTrip(t, upstream, "GET / HTTP/1.1\r\n\r\n")
+	assert.Equal(t, 201, resp.StatusCode)
+	assert.Equal(t, request.NoBody, seen.Body)
+	assert.Empty(t, seen.Headers.Get("Transfer-Encoding"))
+
+	// Test: Chunked request bodies are re-chunked upstream
+	resp = roundTrip(t, upstream, "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n2\r\nde\r\n0\r\n\r\n")
+	assert.Equal(t, 201, resp.StatusCode)
+	assert.Equal(t, "abcde", seenBody)
+}
+
+func TestReverseProxyStreaming(t *testing.T) {
+	// Test: Chunked upstream bodies are streamed chunked
+	upstream := startUpstream(t, func(w response.Writer, req *request.Request) {
+		h := headers.NewHeaders()
+		h.Set("Transfer-Encoding", "chunked")
+		_ = w.WriteStatusLine(response.StatusOK)
+		_ = w.WriteHeaders(h)
+		_, _ = w.WriteChunkedBody([]byte("one,"))
+		_, _ = w.WriteChunkedBody([]byte("two"))
+		_, _ = w.WriteChunkedBodyDone()
+	})
+	resp := roundTrip(t, upstream, "GET / HTTP/1.1\r\n\r\n")
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	assert.Equal(t, "one,two", readBody(t, resp))
+
+	// Test: Interim responses are passed on and close-delimited bodies are
+	// sent chunked
+	addr := rawUpstream(t, func(conn net.Conn) {
+		_, _ = bufio.NewReader(conn).ReadString('\n')
+		_, _ = io.WriteString(conn, "HTTP/1.1 103 Early Hints\r\nLink: </a.css>\r\n\r\nHTTP/1.1 200 OK\r\n\r\nuntil close")
+	})
+	responses := roundTripAll(t, addr, "GET / HTTP/1.1\r\n\r\n")
+	require.Len(t, responses, 2)
+	assert.Equal(t, 103, responses[0].StatusCode)
+	assert.Equal(t, "</a.css>", responses[0].Header.Get("Link"))
+	resp = responses[1]
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	assert.Equal(t, "until close", readBody(t, resp))
+
+	// Test: HEAD responses keep their length and carry no body
+	upstream = startUpstream(t, func(w response.Writer, req *request.Request) {
+		_ = response.Error(w, response.StatusOK, "ignored body")
+	}, server.WithRequestOptions(request.WithMethods("HEAD")))
+	s, err := server.Serve(0, Handler(upstream), server.WithRequestOptions(request.WithMethods("HEAD")))
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	conn, err := net.Dial("tcp", loopback(s))
+	require.NoError(t, err)
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	_, err = io.WriteString(conn, "HEAD / HTTP/1.1\r\n\r\nGET / HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+	resp, err = http.ReadResponse(br, &http.Request{Method: "HEAD"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(12), resp.ContentLength)
+	resp, err = http.ReadResponse(br, &http.Request{Method: "GET"})
+	require.NoError(t, err)
+	assert.Equal(t, "ignored body", readBody(t, resp))
+}
+
+func TestReverseProxyErrors(t *testing.T) {
+	// Test: Unreachable upstream gives 502
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	ln.Close()
+	resp := roundTrip(t, addr, "GET / HTTP/1.1\r\n\r\n")
+	assert.Equal(t, 502, resp.StatusCode)
+
+	// Test: Slow upstream gives 504
+	addr = rawUpstream(t, func(conn net.Conn) {
+		time.Sleep(time.Second)
+	})
+	resp = roundTrip(t, addr, "GET / HTTP/1.1\r\n\r\n", WithResponseTimeout(50*time.Millisecond))
+	assert.Equal(t, 504, resp.StatusCode)
+
+	// Test: Garbage from upstream gives 502
+	addr = rawUpstream(t, func(conn net.Conn) {
+		_, _ = io.WriteString(conn, "SMTP ready\r\n\r\n")
+	})
+	resp = roundTrip(t, addr, "GET / HTTP/1.1\r\n\r\n")
+	assert.Equal(t, 502, resp.StatusCode)
+
+	// Test: Truncated upstream body drops the client connection
+	addr = rawUpstream(t, func(conn net.Conn) {
+		_, _ = io.WriteString(conn, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n")
+	})
+	resp = roundTrip(t, addr, "GET / HTTP/1.1\r\n\r\n")
+	assert.Equal(t, 200, resp.StatusCode)
+	_, err = io.ReadAll(resp.Body)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestForwardedHeaders(t *testing.T) {
+	// Test: IPv6 clients are bracketed and quoted
+	h := headers.NewHeaders()
+	addForwarded(h, &request.Request{RemoteAddr: "[2001:db8::1]:4711", Headers: headers.Headers{"host": "a b"}})
+	assert.Equal(t, "2001:db8::1", h.Get("X-Forwarded-For"))
+	assert.Equal(t, `for="[2001:db8::1]";proto=http;host="a b"`, h.Get("Forwarded"))
+	assert.Equal(t, "http", h.Get("X-Forwarded-Proto"))
+
+	// Test: Requests that arrived over TLS are forwarded as https,
+	// replacing whatever the client claimed
+	h = headers.Headers{"x-forwarded-proto": "http"}
+	addForwarded(h, &request.Request{RemoteAddr: "10.0.0.1:4711", TLS: &tls.ConnectionState{}})
+	assert.Equal(t, "for=10.0.0.1;proto=https", h.Get("Forwarded"))
+	assert.Equal(t, "https", h.Get("X-Forwarded-Proto"))
+
+	// Test: Only '"' and '\' are escaped in quoted hosts, and hosts with
+	// control characters are left out
+	for host, want := range map[string]string{
+		`a"b\c`:       `for=10.0.0.1;proto=http;host="a\"b\\c"`,
+		"caf\xc3\xa9": "for=10.0.0.1;proto=http;host=\"caf\xc3\xa9\"",
+		"a\x01b":      "for=10.0.0.1;proto=http",
+		"a\x7fb":      "for=10.0.0.1;proto=http",
+	} {
+		h = headers.NewHeaders()
+		addForwarded(h, &request.Request{RemoteAddr: "10.0.0.1:4711", Headers: headers.Headers{"host": host}})
+		assert.Equal(t, want, h.Get("Forwarded"), host)
+	}
+
+	// Test: Hop-by-hop fields, including those named in Connection
+	h = headers.Headers{"connection": "close, X-Custom", "x-custom": "1", "upgrade": "websocket", "x-keep": "1"}
+	removeHopHeaders(h)
+	assert.Equal(t, headers.Headers{"x-keep": "1"}, h)
+}
@@ -0,0 +1,187 @@
+package ratelimit
+
+import (
+	"fmt"
+	"hash/maphash"
+	"main/internal/request"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	numShards            = 32
+	defaultSweepInterval = time.Minute
+)
+
+// Decision is the outcome of one request against a client's bucket.
+type Decision struct {
+	Allowed bool
+	// Limit is the bucket size, the most requests a client can make in a
+	// burst.
+	Limit int
+	// Remaining is the number of whole tokens left after this request.
+	Remaining int
+	// RetryAfter is how long until the next token, when not allowed.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type shard struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// Limiter keeps a token bucket per client. Each request takes a token,
+// and tokens come back at a steady rate up to the burst size.
+type Limiter struct {
+	rate  float64
+	burst int
+	key   KeyFunc
+	now   func() time.Time
+
+	sweepInterval time.Duration
+	seed          maphash.Seed
+	shards        [numShards]shard
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Option configures a Limiter.
+type Option func(*Limiter)
+
+// WithKey sets how requests are assigned to clients. The default is
+// ByRemoteIP.
+func WithKey(key KeyFunc) Option {
+	return func(l *Limiter) {
+		l.key = key
+	}
+}
+
+// WithSweepInterval sets how often buckets that have refilled are
+// dropped. A full bucket is the same as a new one, so this only bounds
+// memory.
+func WithSweepInterval(d time.Duration) Option {
+	return func(l *Limiter) {
+		l.sweepInterval = d
+	}
+}
+
+// NewLimiter returns a limiter that refills rate tokens per second into
+// buckets of burst tokens, and starts dropping idle buckets. It panics
+// if rate is not positive. Close stops the sweeps.
+func NewLimiter(rate float64, burst int, opts ...Option) *Limiter {
+	if !(rate > 0) {
+		panic(fmt.Sprintf("ratelimit: rate must be positive, got %v", rate))
+	}
+	l := &Limiter{
+		rate:          rate,
+		burst:         burst,
+		key:           ByRemoteIP(),
+		now:           time.Now,
+		sweepInterval: defaultSweepInterval,
+		seed:          maphash.MakeSeed(),
+		stop:          make(chan struct{}),
+	}
+	for i := range l.shards {
+		l.shards[i].buckets = make(map[string]*bucket)
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	if l.sweepInterval > 0 {
+		l.wg.Add(1)
+		go l.sweepLoop()
+	}
+	return l
+}
+
+// Close stops the sweeps.
+func (l *Limiter) Close() error {
+	close(l.stop)
+	l.wg.Wait()
+	return nil
+}
+
+// Key returns the client req is counted against, or "" if it is not
+// limited.
+func (l *Limiter) Key(req *request.Request) string {
+	return l.key(req)
+}
+
+// Allow takes a token from key's bucket, if there is one.
+func (l *Limiter) Allow(key string) Decision {
+	now := l.now()
+	s := &l.shards[maphash.String(l.seed, key)%numShards]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: float64(l.burst), last: now}
+		s.buckets[key] = b
+	} else {
+		l.refill(b, now)
+	}
+
+	d := Decision{Limit: l.burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		d.Allowed = true
+	} else {
+		d.RetryAfter = l.timeFor(1 - b.tokens)
+	}
+	d.Remaining = int(b.tokens)
+	d.Reset = l.timeFor(float64(l.burst) - b.tokens)
+	return d
+}
+
+func (l *Limiter) refill(b *bucket, now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(float64(l.burst), b.tokens+elapsed.Seconds()*l.rate)
+		b.last = now
+	}
+}
+
+// timeFor returns how long it takes to refill n tokens.
+func (l *Limiter) timeFor(n float64) time.Duration {
+	return time.Duration(math.Ceil(n / l.rate * float64(time.Second)))
+}
+
+func (l *Limiter) sweepLoop() {
+	defer l.wg.Done()
+	ticker := time.NewTicker(l.sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			l.sweep()
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// sweep drops the buckets that have refilled completely.
+func (l *Limiter) sweep() {
+	now := l.now()
+	for i := range l.shards {
+		s := &l.shards[i]
+		s.mu.Lock()
+		for key, b := range s.buckets {
+			l.refill(b, now)
+			if b.tokens >= float64(l.burst) {
+				delete(s.buckets, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,123 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a settable time source for limiters under test.
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+func newTestLimiter(t *testing.T, rate float64, burst int) (*Limiter, *fakeClock) {
+	t.Helper()
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	l := NewLimiter(rate, burst, WithSweepInterval(0))
+	l.now = clock.now
+	t.Cleanup(func() { l.Close() })
+	return l, clock
+}
+
+func bucketCount(l *Limiter) int {
+	n := 0
+	for i := range l.shards {
+		l.shards[i].mu.Lock()
+		n += len(l.shards[i].buckets)
+		l.shards[i].mu.Unlock()
+	}
+	return n
+}
+
+func TestAllow(t *testing.T) {
+	l, clock := newTestLimiter(t, 2, 3)
+
+	// Test: A new client can spend its whole burst at once
+	for remaining := 2; remaining >= 0; remaining-- {
+		d := l.Allow("a")
+		assert.True(t, d.Allowed)
+		assert.Equal(t, 3, d.Limit)
+		assert.Equal(t, remaining, d.Remaining)
+	}
+	assert.Equal(t, Decision{Limit: 3, RetryAfter: 500 * time.Millisecond, Reset: 1500 * time.Millisecond}, l.Allow("a"))
+
+	// Test: Other clients have their own buckets
+	assert.True(t, l.Allow("b").Allowed)
+
+	// Test: Tokens come back at the refill rate, never above the burst
+	clock.advance(400 * time.Millisecond)
+	assert.False(t, l.Allow("a").Allowed)
+	clock.advance(100 * time.Millisecond)
+	assert.Equal(t, Decision{Allowed: true, Limit: 3, Reset: 1500 * time.Millisecond}, l.Allow("a"))
+	clock.advance(time.Hour)
+	assert.Equal(t, 2, l.Allow("a").Remaining)
+}
+
+func TestNewLimiterRate(t *testing.T) {
+	// Test: A rate that would never refill is refused
+	for _, rate := range []float64{0, -1, math.NaN()} {
+		assert.Panics(t, func() { NewLimiter(rate, 1) }, "rate %v", rate)
+	}
+}
+
+func TestAllowConcurrent(t *testing.T) {
+	l, _ := newTestLimiter(t, 1e-9, 50)
+
+	// Test: Concurrent requests never take more than the burst
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	n := 0
+	for range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if l.Allow("a").Allowed {
+				mu.Lock()
+				n++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 50, n)
+}
+
+func TestSweep(t *testing.T) {
+	l, clock := newTestLimiter(t, 1, 2)
+	l.Allow("a")
+	l.Allow("b")
+	l.Allow("b")
+	require.Equal(t, 2, bucketCount(l))
+
+	// Test: Buckets are dropped once they have refilled, and not before
+	clock.advance(time.Second)
+	l.sweep()
+	assert.Equal(t, 1, bucketCount(l))
+	clock.advance(time.Second)
+	l.sweep()
+	assert.Equal(t, 0, bucketCount(l))
+
+	// Test: The sweep loop runs on its own and stops on Close
+	l = NewLimiter(1000, 1, WithSweepInterval(time.Millisecond))
+	l.Allow("a")
+	assert.Eventually(t, func() bool { return bucketCount(l) == 0 }, time.Second, time.Millisecond)
+	require.NoError(t, l.Close())
+}
@@ -0,0 +1,100 @@
+package ratelimit
+
+import (
+	"bufio"
+	"main/internal/headers"
+	"main/internal/request"
+	"main/internal/response"
+	"main/internal/server"
+	"maps"
+	"math"
+	"net"
+	"strconv"
+	"time"
+)
+
+// KeyFunc returns the client a request is counted against. Requests
+// with an empty key are not limited.
+type KeyFunc func(req *request.Request) string
+
+// ByRemoteIP counts requests against the IP address they came from.
+func ByRemoteIP() KeyFunc {
+	return func(req *request.Request) string {
+		host, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			return req.RemoteAddr
+		}
+		return host
+	}
+}
+
+// ByHeader counts requests against the value of the named header, such
+// as an API key, falling back to the remote IP when it is missing.
+func ByHeader(name string) KeyFunc {
+	byIP := ByRemoteIP()
+	return func(req *request.Request) string {
+		if v := req.Headers.Get(name); v != "" {
+			return v
+		}
+		return byIP(req)
+	}
+}
+
+// Middleware limits the requests each client of l can make to next.
+// Requests over the limit get 429 Too Many Requests with Retry-After,
+// and every response reports the client's quota in the RateLimit-Limit,
+// RateLimit-Remaining and RateLimit-Reset headers.
+func Middleware(next server.Handler, l *Limiter) server.Handler {
+	return func(w response.Writer, req *request.Request) {
+		key := l.Key(req)
+		if key == "" {
+			next(w, req)
+			return
+		}
+		d := l.Allow(key)
+		if !d.Allowed {
+			const msg = "Too Many Requests\n"
+			h := response.GetDefaultHeaders(len(msg))
+			setHeaders(h, d)
+			h.Set("Retry-After", strconv.Itoa(seconds(d.RetryAfter)))
+			_ = w.WriteStatusLine(response.StatusTooManyRequests)
+			_ = w.WriteHeaders(h)
+			_, _ = w.WriteBody([]byte(msg))
+			return
+		}
+		next(&limitWriter{Writer: w, decision: d}, req)
+	}
+}
+
+func setHeaders(h headers.Headers, d Decision) {
+	h.Set("RateLimit-Limit", strconv.Itoa(d.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(seconds(d.Reset)))
+}
+
+// seconds rounds d up to whole seconds, as the headers carry them.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// limitWriter adds the quota headers to the handler's response.
+type limitWriter struct {
+	response.Writer
+	decision Decision
+}
+
+func (w *limitWriter) WriteHeaders(h headers.Headers) error {
+	if h = maps.Clone(h); h == nil {
+		h = headers.NewHeaders()
+	}
+	setHeaders(h, w.decision)
+	return w.Writer.WriteHeaders(h)
+}
+
+func (w *limitWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.Writer.(response.Hijacker)
+	if !ok {
+		return nil, nil, response.ErrNotHijackable
+	}
+	return hj.Hijack()
+}
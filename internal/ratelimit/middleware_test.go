@@ -0,0 +1,116 @@
+package ratelimit
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"main/internal/request"
+	"main/internal/response"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func okHandler(w response.Writer, req *request.Request) {
+	_ = response.Error(w, response.StatusOK, "ok\n")
+}
+
+// serve runs handler behind the middleware for a GET from remoteAddr with
+// the given extra header lines, and parses what it wrote.
+func serve(t *testing.T, l *Limiter, remoteAddr string, extra ...string) *http.Response {
+	t.Helper()
+	raw := "GET / HTTP/1.1\r\n"
+	for _, line := range extra {
+		raw += line + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+	req.RemoteAddr = remoteAddr
+
+	var buf bytes.Buffer
+	w := response.NewConnWriter(&buf)
+	Middleware(okHandler, l)(w, req)
+	require.NoError(t, w.Finish())
+
+	resp, err := http.ReadResponse(bufio.NewReader(&buf), nil)
+	require.NoError(t, err)
+	return resp
+}
+
+func TestMiddleware(t *testing.T) {
+	l, clock := newTestLimiter(t, 0.5, 2)
+
+	// Test: Allowed responses report the remaining quota
+	resp := serve(t, l, "192.0.2.1:1000")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "2", resp.Header.Get("RateLimit-Limit"))
+	assert.Equal(t, "1", resp.Header.Get("RateLimit-Remaining"))
+	assert.Equal(t, "2", resp.Header.Get("RateLimit-Reset"))
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "ok\n", string(body))
+
+	// Test: Other ports of the same IP share the bucket, and a request
+	// over the limit is refused with the time to wait
+	assert.Equal(t, http.StatusOK, serve(t, l, "192.0.2.1:1001").StatusCode)
+	resp = serve(t, l, "192.0.2.1:1002")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "2", resp.Header.Get("Retry-After"))
+	assert.Equal(t, "0", resp.Header.Get("RateLimit-Remaining"))
+	assert.Equal(t, "4", resp.Header.Get("RateLimit-Reset"))
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "Too Many Requests\n", string(body))
+
+	// Test: Another IP is not affected, and the first one recovers
+	assert.Equal(t, http.StatusOK, serve(t, l, "[2001:db8::1]:1000").StatusCode)
+	clock.advance(1500 * time.Millisecond)
+	resp = serve(t, l, "192.0.2.1:1003")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+	clock.advance(500 * time.Millisecond)
+	assert.Equal(t, http.StatusOK, serve(t, l, "192.0.2.1:1004").StatusCode)
+}
+
+func TestMiddlewareKeys(t *testing.T) {
+	l, _ := newTestLimiter(t, 1, 1)
+	l.key = ByHeader("X-API-Key")
+
+	// Test: Requests are counted per header value, falling back to the IP
+	assert.Equal(t, http.StatusOK, serve(t, l, "192.0.2.1:1000", "X-API-Key: alpha").StatusCode)
+	assert.Equal(t, http.StatusOK, serve(t, l, "192.0.2.1:1000", "X-API-Key: beta").StatusCode)
+	assert.Equal(t, http.StatusTooManyRequests, serve(t, l, "192.0.2.2:1000", "X-API-Key: alpha").StatusCode)
+	assert.Equal(t, http.StatusOK, serve(t, l, "192.0.2.1:1000").StatusCode)
+	assert.Equal(t, http.StatusTooManyRequests, serve(t, l, "192.0.2.1:1001").StatusCode)
+
+	// Test: An empty key exempts the request
+	l.key = func(req *request.Request) string { return "" }
+	resp := serve(t, l, "192.0.2.1:1000")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("RateLimit-Limit"))
+}
+
+func TestMiddlewareHijack(t *testing.T) {
+	l, _ := newTestLimiter(t, 1, 1)
+	server, client := net.Pipe()
+	defer client.Close()
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	req.RemoteAddr = "192.0.2.1:1000"
+
+	// Test: Hijack reaches the connection through the middleware
+	hijacked := make(chan bool, 1)
+	go Middleware(func(w response.Writer, req *request.Request) {
+		conn, _, err := w.(response.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+		hijacked <- err == nil
+	}, l)(response.NewServerConnWriter(server, bufio.NewReader(server)), req)
+	assert.True(t, <-hijacked)
+}
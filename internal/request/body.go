@@ -0,0 +1,218 @@
+package request
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"main/internal/headers"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrLineTooLong = errors.New("start line or header field too long")
+	// ErrHeaderTooLarge is returned when the request-line and header
+	// section together exceed WithMaxHeaderBytes.
+	ErrHeaderTooLarge = errors.New("request header section too large")
+	// ErrBodyNotDrained is returned by Body.Close when the unread rest of
+	// the body was too large to discard, so the connection cannot be
+	// reused for another request.
+	ErrBodyNotDrained = errors.New("request body too large to drain")
+	ErrBodyClosed     = errors.New("read on closed request body")
+	// ErrUnsupportedTransferEncoding is returned for transfer codings
+	// other than chunked.
+	ErrUnsupportedTransferEncoding = errors.New("unsupported transfer-encoding")
+	// ErrUnsupportedMethod is returned for methods the parser was not
+	// configured to accept.
+	ErrUnsupportedMethod = errors.New("Invalid HTTP Method")
+)
+
+// maxDrainSize is how much unread body Close discards to keep the
+// connection usable.
+const maxDrainSize = 256 << 10
+
+// NoBody is the Body of requests without one.
+var NoBody io.ReadCloser = noBody{}
+
+type noBody struct{}
+
+func (noBody) Read([]byte) (int, error) { return 0, io.EOF }
+func (noBody) Close() error             { return nil }
+
+// bodyFraming reports how the body following h is delimited. Requests
+// carrying both Transfer-Encoding and Content-Length are rejected, since
+// intermediaries may disagree on which one wins (RFC 9112 6.1).
+func bodyFraming(h headers.Headers) (chunked bool, length int64, err error) {
+	te, hasTE := h["transfer-encoding"]
+	cl, hasCL := h["content-length"]
+	if hasTE {
+		if hasCL {
+			return false, 0, errors.New("both Transfer-Encoding and Content-Length present")
+		}
+		if !strings.EqualFold(strings.TrimSpace(te), "chunked") {
+			return false, 0, fmt.Errorf("%w: %q", ErrUnsupportedTransferEncoding, te)
+		}
+		return true, 0, nil
+	}
+	if !hasCL {
+		return false, 0, nil
+	}
+	length, err = strconv.ParseInt(cl, 10, 64)
+	if err != nil || length < 0 || cl[0] == '+' {
+		return false, 0, fmt.Errorf("invalid Content-Length: %q", cl)
+	}
+	return false, length, nil
+}
+
+// attachBody sets req.Body to a lazy reader over br. release is called
+// once br is no longer needed by the body.
+func attachBody(req *Request, br *bufio.Reader, release func()) error {
+	chunked, length, err := bodyFraming(req.Headers)
+	if err != nil {
+		return err
+	}
+	if !chunked && length == 0 {
+		release()
+		req.Body = NoBody
+		return nil
+	}
+
+	b := &body{br: br, release: release, remaining: length}
+	if chunked {
+		b.chunked = newChunkedDecoder()
+		req.Trailers = b.chunked.trailers
+	}
+	req.Body = b
+	return nil
+}
+
+// NewBodyReader returns a reader for a message body that is read
+// straight off br, for callers that frame bodies other than requests.
+// length is the Content-Length, or -1 for a body that runs until br
+// returns EOF; it is ignored when chunked is set. The trailers of a
+// chunked body are added to the returned Headers once the body has been
+// read to EOF.
+func NewBodyReader(br *bufio.Reader, chunked bool, length int64) (io.ReadCloser, headers.Headers) {
+	if !chunked && length == 0 {
+		return NoBody, nil
+	}
+	b := &body{br: br, remaining: length}
+	if chunked {
+		b.chunked = newChunkedDecoder()
+		return b, b.chunked.trailers
+	}
+	return b, nil
+}
+
+// body reads a Content-Length, chunked or close-delimited body straight
+// off the connection reader.
+type body struct {
+	br        *bufio.Reader
+	release   func()
+	remaining int64
+	chunked   *chunkedDecoder
+	// err is sticky: once set, every Read returns it.
+	err    error
+	closed bool
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, ErrBodyClosed
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	var n int
+	var err error
+	if b.chunked != nil {
+		n, err = b.readChunked(p)
+	} else {
+		n, err = b.readLength(p)
+	}
+	if err != nil {
+		b.err = err
+		if err == io.EOF && b.release != nil {
+			b.release()
+			b.release = nil
+		}
+	}
+	return n, err
+}
+
+func (b *body) readLength(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return b.br.Read(p)
+	}
+	if b.remaining == 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.br.Read(p)
+	b.remaining -= int64(n)
+	if err == io.EOF {
+		return n, io.ErrUnexpectedEOF
+	}
+	if err == nil && b.remaining == 0 {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (b *body) readChunked(p []byte) (int, error) {
+	for {
+		if b.chunked.done() {
+			return 0, io.EOF
+		}
+		data, _ := b.br.Peek(b.br.Buffered())
+		consumed, payload, err := b.chunked.decode(data, len(p))
+		if err != nil {
+			return 0, err
+		}
+		n := copy(p, payload)
+		_, _ = b.br.Discard(consumed)
+		if n > 0 {
+			return n, nil
+		}
+		if consumed > 0 {
+			continue
+		}
+
+		if _, err := b.br.Peek(b.br.Buffered() + 1); err != nil {
+			switch err {
+			case io.EOF:
+				return 0, io.ErrUnexpectedEOF
+			case bufio.ErrBufferFull:
+				return 0, errors.New("chunk size line or trailer field too long")
+			default:
+				return 0, err
+			}
+		}
+	}
+}
+
+// Close discards up to maxDrainSize unread bytes so the next request on
+// the connection can be parsed.
+func (b *body) Close() error {
+	if b.closed {
+		return nil
+	}
+	if b.err == nil {
+		n, err := io.CopyN(io.Discard, b, maxDrainSize+1)
+		switch {
+		case err == nil && n > maxDrainSize:
+			b.err = ErrBodyNotDrained
+		case err != nil && err != io.EOF:
+			b.err = err
+		}
+	}
+	b.closed = true
+	if b.err != io.EOF {
+		return b.err
+	}
+	return nil
+}
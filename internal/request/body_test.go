@@ -0,0 +1,187 @@
+package request
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamingBody(t *testing.T) {
+	// Test: Content-Length body
+	reader := &chunkReader{
+		data:            "POST /submit HTTP/1.1\r\nContent-Length: 13\r\n\r\nhello, world!",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	body, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world!", string(body))
+	require.NoError(t, r.Body.Close())
+
+	// Test: Body shorter than Content-Length
+	reader = &chunkReader{
+		data:            "POST /submit HTTP/1.1\r\nContent-Length: 20\r\n\r\npartial content",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.Body)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// Test: No body
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, NoBody, r.Body)
+
+	// Test: Chunked body with extensions and trailers
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n" +
+			"5\r\nhello\r\n" +
+			"7;ext=1\r\n, world\r\n" +
+			"0\r\nX-Checksum: abc\r\n\r\n",
+		numBytesPerRead: 4,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	body, err = io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", string(body))
+	assert.Equal(t, "abc", r.Trailers["x-checksum"])
+
+	// Test: Malformed chunk size
+	r, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\nhello\r\n0\r\n\r\n"))
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.Body)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid chunk size")
+
+	// Test: Missing CRLF after chunk data
+	r, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nhello\r\n0\r\n\r\n"))
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.Body)
+	require.Error(t, err)
+
+	// Test: Truncated chunked body
+	r, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhel"))
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.Body)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// Test: Both Transfer-Encoding and Content-Length
+	_, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\nContent-Length: 5\r\n\r\n"))
+	require.Error(t, err)
+
+	// Test: Unsupported transfer coding
+	_, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nTransfer-Encoding: gzip, chunked\r\n\r\n"))
+	assert.ErrorIs(t, err, ErrUnsupportedTransferEncoding)
+
+	// Test: Invalid Content-Length
+	_, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: -1\r\n\r\n"))
+	require.Error(t, err)
+}
+
+func TestBodyClose(t *testing.T) {
+	// Test: Close drains so the next request on the connection parses
+	br := bufio.NewReader(strings.NewReader(
+		"POST /first HTTP/1.1\r\nContent-Length: 11\r\n\r\nunread body" +
+			"POST /second HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n" +
+			"GET /third HTTP/1.1\r\n\r\n"))
+	r, err := RequestFromReader(br)
+	require.NoError(t, err)
+	assert.Equal(t, "/first", r.RequestLine.RequestTarget)
+	require.NoError(t, r.Body.Close())
+
+	r, err = RequestFromReader(br)
+	require.NoError(t, err)
+	assert.Equal(t, "/second", r.RequestLine.RequestTarget)
+	buf := make([]byte, 1)
+	_, err = r.Body.Read(buf)
+	require.NoError(t, err)
+	require.NoError(t, r.Body.Close())
+
+	r, err = RequestFromReader(br)
+	require.NoError(t, err)
+	assert.Equal(t, "/third", r.RequestLine.RequestTarget)
+
+	// Test: Clean end of connection
+	_, err = RequestFromReader(br)
+	assert.Equal(t, io.EOF, err)
+
+	// Test: Read after Close
+	r, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: 3\r\n\r\nabc"))
+	require.NoError(t, err)
+	require.NoError(t, r.Body.Close())
+	require.NoError(t, r.Body.Close())
+	_, err = r.Body.Read(buf)
+	assert.ErrorIs(t, err, ErrBodyClosed)
+
+	// Test: Bodies too large to drain
+	big := strings.Repeat("x", maxDrainSize+10)
+	r, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: " + strconv.Itoa(len(big)) + "\r\n\r\n" + big))
+	require.NoError(t, err)
+	assert.ErrorIs(t, r.Body.Close(), ErrBodyNotDrained)
+
+	// Test: Close reports framing errors
+	r, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n"))
+	require.NoError(t, err)
+	require.Error(t, r.Body.Close())
+}
+
+func TestLineTooLong(t *testing.T) {
+	// Test: Field-line longer than the read buffer
+	_, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nX-Big: " + strings.Repeat("x", bufferSize) + "\r\n\r\n"))
+	assert.ErrorIs(t, err, ErrLineTooLong)
+}
+
+func TestNewBodyReader(t *testing.T) {
+	// Test: Close-delimited body runs until EOF
+	body, trailers := NewBodyReader(bufio.NewReader(strings.NewReader("until the end")), false, -1)
+	assert.Nil(t, trailers)
+	data, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "until the end", string(data))
+
+	// Test: Content-Length body stops at its length
+	br := bufio.NewReader(strings.NewReader("abcdef"))
+	body, _ = NewBodyReader(br, false, 4)
+	data, err = io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "abcd", string(data))
+	rest, _ := io.ReadAll(br)
+	assert.Equal(t, "ef", string(rest))
+
+	// Test: Chunked body fills the trailers
+	body, trailers = NewBodyReader(bufio.NewReader(strings.NewReader("3\r\nabc\r\n0\r\nX-Sum: 1\r\n\r\n")), true, 0)
+	data, err = io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(data))
+	assert.Equal(t, "1", trailers.Get("X-Sum"))
+
+	// Test: Empty body
+	body, _ = NewBodyReader(bufio.NewReader(strings.NewReader("x")), false, 0)
+	assert.Equal(t, NoBody, body)
+}
+
+func TestChunkedWriter(t *testing.T) {
+	// Test: Each write is one chunk, and empty writes are dropped
+	var buf strings.Builder
+	cw := NewChunkedWriter(&buf)
+	for _, p := range []string{"hello", "", ", world"} {
+		n, err := io.WriteString(cw, p)
+		require.NoError(t, err)
+		assert.Equal(t, len(p), n)
+	}
+	assert.Equal(t, "5\r\nhello\r\n7\r\n, world\r\n", buf.String())
+
+	// Test: The output reads back once the last chunk is added
+	body, _ := NewBodyReader(bufio.NewReader(strings.NewReader(buf.String()+"0\r\n\r\n")), true, 0)
+	data, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", string(data))
+}
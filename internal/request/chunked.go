@@ -0,0 +1,157 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"main/internal/headers"
+)
+
+type chunkedState int
+
+const (
+	chunkSize chunkedState = iota
+	chunkData
+	chunkDataEnd
+	chunkTrailer
+	chunkDone
+)
+
+// maxChunkSizeLine bounds a chunk-size line including chunk extensions.
+const maxChunkSizeLine = 4096
+
+// chunkedDecoder is a push-style decoder for the chunked transfer coding
+// (RFC 9112 7.1). It is shared by the buffering Parser and the streaming
+// Body, which each own the bytes being decoded.
+type chunkedDecoder struct {
+	state     chunkedState
+	remaining int64
+	trailers  headers.Headers
+}
+
+func newChunkedDecoder() *chunkedDecoder {
+	return &chunkedDecoder{trailers: headers.NewHeaders()}
+}
+
+func (d *chunkedDecoder) done() bool {
+	return d.state == chunkDone
+}
+
+// decode consumes chunked framing from the front of data and returns up to
+// max bytes of chunk payload as a subslice of data. consumed counts both
+// framing and payload. It stops after the first run of payload, so callers
+// loop until consumed is 0 or the decoder is done.
+func (d *chunkedDecoder) decode(data []byte, max int) (consumed int, payload []byte, err error) {
+	for d.state != chunkDone {
+		rest := data[consumed:]
+		switch d.state {
+
+		case chunkSize:
+			idx := bytes.Index(rest, crlf)
+			if idx == -1 {
+				if len(rest) > maxChunkSizeLine {
+					return consumed, nil, errors.New("chunk size line too long")
+				}
+				return consumed, nil, nil
+			}
+			size, err := parseChunkSize(rest[:idx])
+			if err != nil {
+				return consumed, nil, err
+			}
+			consumed += idx + len(crlf)
+			if size == 0 {
+				d.state = chunkTrailer
+			} else {
+				d.remaining = size
+				d.state = chunkData
+			}
+
+		case chunkData:
+			n := int(min(d.remaining, int64(len(rest)), int64(max)))
+			if n == 0 {
+				return consumed, nil, nil
+			}
+			d.remaining -= int64(n)
+			if d.remaining == 0 {
+				d.state = chunkDataEnd
+			}
+			return consumed + n, rest[:n], nil
+
+		case chunkDataEnd:
+			if len(rest) < len(crlf) {
+				return consumed, nil, nil
+			}
+			if !bytes.HasPrefix(rest, crlf) {
+				return consumed, nil, errors.New("missing CRLF after chunk data")
+			}
+			consumed += len(crlf)
+			d.state = chunkSize
+
+		case chunkTrailer:
+			n, complete, err := d.trailers.Parse(rest)
+			if err != nil {
+				return consumed, nil, fmt.Errorf("error parsing trailer fields: %w", err)
+			}
+			consumed += n
+			if !complete {
+				return consumed, nil, nil
+			}
+			d.state = chunkDone
+		}
+	}
+	return consumed, nil, nil
+}
+
+// parseChunkSize parses a chunk-size line, ignoring chunk extensions.
+func parseChunkSize(line []byte) (int64, error) {
+	if i := bytes.IndexByte(line, ';'); i >= 0 {
+		line = line[:i]
+	}
+	line = bytes.TrimRight(line, " \t")
+	if len(line) == 0 || len(line) > 15 {
+		return 0, fmt.Errorf("invalid chunk size: %q", line)
+	}
+	var size int64
+	for _, c := range line {
+		var v byte
+		switch {
+		case '0' <= c && c <= '9':
+			v = c - '0'
+		case 'a' <= c && c <= 'f':
+			v = c - 'a' + 10
+		case 'A' <= c && c <= 'F':
+			v = c - 'A' + 10
+		default:
+			return 0, fmt.Errorf("invalid chunk size: %q", line)
+		}
+		size = size<<4 | int64(v)
+	}
+	return size, nil
+}
+
+// NewChunkedWriter returns a writer that sends each write to w as one
+// chunk. Empty writes are dropped, since a zero-length chunk would end
+// the body; the last chunk and any trailers are left to the caller.
+func NewChunkedWriter(w io.Writer) io.Writer {
+	return chunkedWriter{w}
+}
+
+type chunkedWriter struct {
+	w io.Writer
+}
+
+func (c chunkedWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if _, err := fmt.Fprintf(c.w, "%x\r\n", len(p)); err != nil {
+		return 0, err
+	}
+	n, err := c.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	_, err = io.WriteString(c.w, "\r\n")
+	return n, err
+}
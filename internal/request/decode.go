@@ -0,0 +1,127 @@
+package request
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"main/internal/headers"
+	"strings"
+)
+
+var (
+	// ErrUnsupportedContentEncoding is returned when decompression is
+	// enabled and the body uses a content coding other than gzip or
+	// deflate.
+	ErrUnsupportedContentEncoding = errors.New("unsupported content-encoding")
+	// ErrDecompressedTooLarge is returned by Body.Read once a compressed
+	// body inflates past the configured limit.
+	ErrDecompressedTooLarge = errors.New("decompressed request body exceeds size limit")
+)
+
+// contentCodings returns the codings listed in Content-Encoding in the
+// order they were applied, leaving out identity.
+func contentCodings(h headers.Headers) ([]string, error) {
+	var codings []string
+	for _, coding := range strings.Split(h.Get("Content-Encoding"), ",") {
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		switch coding {
+		case "", "identity":
+		case "gzip", "x-gzip", "deflate":
+			codings = append(codings, coding)
+		default:
+			return nil, fmt.Errorf("%w: %q", ErrUnsupportedContentEncoding, coding)
+		}
+	}
+	return codings, nil
+}
+
+// decodeBody replaces req.Body with a reader that undoes its content
+// codings, producing at most limit bytes. Content-Encoding and
+// Content-Length are removed, since they no longer describe what Body
+// returns.
+func decodeBody(req *Request, limit int64) error {
+	codings, err := contentCodings(req.Headers)
+	if err != nil || len(codings) == 0 {
+		return err
+	}
+	req.Headers.Delete("Content-Encoding")
+	req.Headers.Delete("Content-Length")
+	if req.Body == NoBody {
+		return nil
+	}
+	req.Body = &decodingBody{raw: req.Body, codings: codings, remaining: limit}
+	return nil
+}
+
+// decodingBody inflates a compressed body. The decoders are set up on
+// the first Read, since they read the stream header, and reading must not
+// start before the handler asks for the body.
+type decodingBody struct {
+	raw       io.ReadCloser
+	codings   []string
+	r         io.Reader
+	remaining int64
+	// err is sticky: once set, every Read returns it.
+	err error
+}
+
+func (b *decodingBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if b.r == nil {
+		r, err := newDecoder(b.raw, b.codings)
+		if err != nil {
+			b.err = err
+			return 0, err
+		}
+		b.r = r
+	}
+
+	// Ask for one byte past the limit to tell a body that ends exactly
+	// there from one that keeps going.
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.r.Read(p)
+	if int64(n) > b.remaining {
+		n, err = int(b.remaining), ErrDecompressedTooLarge
+	}
+	b.remaining -= int64(n)
+	if err != nil && err != io.EOF && err != ErrDecompressedTooLarge {
+		err = fmt.Errorf("error decoding request body: %w", err)
+	}
+	if err != nil {
+		b.err = err
+	}
+	return n, err
+}
+
+// Close closes the underlying body, draining what is left of the
+// compressed stream.
+func (b *decodingBody) Close() error {
+	return b.raw.Close()
+}
+
+// newDecoder stacks a decoder for each coding over r, undoing the last
+// applied coding first.
+func newDecoder(r io.Reader, codings []string) (io.Reader, error) {
+	for i := len(codings) - 1; i >= 0; i-- {
+		var err error
+		switch codings[i] {
+		case "gzip", "x-gzip":
+			r, err = gzip.NewReader(r)
+		case "deflate":
+			r, err = zlib.NewReader(r)
+		}
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, fmt.Errorf("error decoding request body: %w", err)
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,139 @@
+package request
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gzipped(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write([]byte(s))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func deflated(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	_, err := zw.Write([]byte(s))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func encodedRequest(encoding string, body []byte) string {
+	return fmt.Sprintf("POST /submit HTTP/1.1\r\nContent-Encoding: %s\r\nContent-Length: %d\r\n\r\n%s", encoding, len(body), body)
+}
+
+func TestDecompression(t *testing.T) {
+	// Test: gzip body is decoded and the encoding headers are dropped
+	reader := &chunkReader{
+		data:            encodedRequest("gzip", gzipped(t, "hello, world!")),
+		numBytesPerRead: 5,
+	}
+	r, err := RequestFromReader(reader, WithDecompression(1024))
+	require.NoError(t, err)
+	body, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world!", string(body))
+	assert.Equal(t, "", r.Headers.Get("Content-Encoding"))
+	assert.Equal(t, "", r.Headers.Get("Content-Length"))
+	require.NoError(t, r.Body.Close())
+
+	// Test: deflate body
+	r, err = RequestFromReader(strings.NewReader(encodedRequest("deflate", deflated(t, "hello, world!"))), WithDecompression(1024))
+	require.NoError(t, err)
+	body, err = io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world!", string(body))
+
+	// Test: Stacked codings are undone in reverse order
+	var stacked bytes.Buffer
+	zw := gzip.NewWriter(&stacked)
+	_, err = zw.Write(deflated(t, "layered"))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	r, err = RequestFromReader(strings.NewReader(encodedRequest("deflate, gzip", stacked.Bytes())), WithDecompression(1024))
+	require.NoError(t, err)
+	body, err = io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "layered", string(body))
+
+	// Test: Body at exactly the limit
+	r, err = RequestFromReader(strings.NewReader(encodedRequest("gzip", gzipped(t, strings.Repeat("a", 100)))), WithDecompression(100))
+	require.NoError(t, err)
+	body, err = io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Len(t, body, 100)
+
+	// Test: Zip bomb is cut off at the limit
+	bomb := gzipped(t, strings.Repeat("a", 1<<20))
+	r, err = RequestFromReader(strings.NewReader(encodedRequest("gzip", bomb)), WithDecompression(1000))
+	require.NoError(t, err)
+	body, err = io.ReadAll(r.Body)
+	assert.ErrorIs(t, err, ErrDecompressedTooLarge)
+	assert.Len(t, body, 1000)
+	_, err = r.Body.Read(make([]byte, 10))
+	assert.ErrorIs(t, err, ErrDecompressedTooLarge)
+
+	// Test: Unsupported encoding
+	_, err = RequestFromReader(strings.NewReader(encodedRequest("br", []byte("xx"))), WithDecompression(1024))
+	assert.ErrorIs(t, err, ErrUnsupportedContentEncoding)
+
+	// Test: Corrupt gzip stream
+	r, err = RequestFromReader(strings.NewReader(encodedRequest("gzip", []byte("not gzip at all"))), WithDecompression(1024))
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.Body)
+	require.Error(t, err)
+
+	// Test: Truncated gzip stream
+	truncated := gzipped(t, "hello, world!")
+	r, err = RequestFromReader(strings.NewReader(encodedRequest("gzip", truncated[:len(truncated)-4])), WithDecompression(1024))
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.Body)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// Test: Without the option the body is left compressed
+	compressed := gzipped(t, "hello")
+	r, err = RequestFromReader(strings.NewReader(encodedRequest("br", compressed)))
+	require.NoError(t, err)
+	body, err = io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Equal(t, compressed, body)
+	assert.Equal(t, "br", r.Headers.Get("Content-Encoding"))
+
+	// Test: identity needs no decoding
+	r, err = RequestFromReader(strings.NewReader(encodedRequest("identity", []byte("plain"))), WithDecompression(1024))
+	require.NoError(t, err)
+	body, err = io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "plain", string(body))
+
+	// Test: Push parser decodes its buffered body
+	p := NewParser(WithDecompression(1024))
+	data := []byte(encodedRequest("gzip", gzipped(t, "pushed")))
+	n, err := p.Feed(data)
+	require.NoError(t, err)
+	assert.Equal(t, len(data), n)
+	require.True(t, p.Done())
+	body, err = io.ReadAll(p.Request().Body)
+	require.NoError(t, err)
+	assert.Equal(t, "pushed", string(body))
+
+	// Test: Push parser rejects unsupported encodings after the headers
+	p = NewParser(WithDecompression(1024))
+	_, err = p.Feed([]byte(encodedRequest("compress", []byte("xx"))))
+	assert.ErrorIs(t, err, ErrUnsupportedContentEncoding)
+}
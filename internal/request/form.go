@@ -0,0 +1,65 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"strings"
+)
+
+// maxFormSize caps the url-encoded body read by ParseForm.
+const maxFormSize = 10 << 20
+
+var ErrFormTooLarge = errors.New("form body exceeds size limit")
+
+// ParseForm returns the query parameters of the request target merged
+// with the body of an application/x-www-form-urlencoded request. Body
+// values are listed before query values of the same name. The body is
+// consumed by the first call; later calls return the same values.
+func (r *Request) ParseForm() (url.Values, error) {
+	if r.form != nil {
+		return r.form, nil
+	}
+	form := make(url.Values)
+
+	if mediaType(r.Headers.Get("Content-Type")) == "application/x-www-form-urlencoded" {
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxFormSize+1))
+		if err != nil {
+			return nil, fmt.Errorf("error reading form body: %w", err)
+		}
+		if len(body) > maxFormSize {
+			return nil, ErrFormTooLarge
+		}
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing form body: %w", err)
+		}
+		form = values
+	}
+
+	if _, query, found := strings.Cut(r.RequestLine.RequestTarget, "?"); found {
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing query string: %w", err)
+		}
+		for key, vs := range values {
+			form[key] = append(form[key], vs...)
+		}
+	}
+
+	r.form = form
+	return form, nil
+}
+
+func mediaType(contentType string) string {
+	if contentType == "" {
+		return ""
+	}
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return mt
+}
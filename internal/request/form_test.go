@@ -0,0 +1,46 @@
+package request
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseForm(t *testing.T) {
+	// Test: URL-encoded body
+	body := "name=gopher&lang=go&lang=zig"
+	r, err := RequestFromReader(strings.NewReader("POST /submit HTTP/1.1\r\nContent-Type: application/x-www-form-urlencoded\r\nContent-Length: 28\r\n\r\n" + body))
+	require.NoError(t, err)
+	form, err := r.ParseForm()
+	require.NoError(t, err)
+	assert.Equal(t, "gopher", form.Get("name"))
+	assert.Equal(t, []string{"go", "zig"}, form["lang"])
+
+	// Test: Second call returns the cached values
+	again, err := r.ParseForm()
+	require.NoError(t, err)
+	assert.Equal(t, form, again)
+
+	// Test: Query string merged after body values
+	r, err = RequestFromReader(strings.NewReader("POST /submit?lang=ocaml&page=2 HTTP/1.1\r\nContent-Type: application/x-www-form-urlencoded; charset=utf-8\r\nContent-Length: 7\r\n\r\nlang=go"))
+	require.NoError(t, err)
+	form, err = r.ParseForm()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"go", "ocaml"}, form["lang"])
+	assert.Equal(t, "2", form.Get("page"))
+
+	// Test: Body ignored for other content types
+	r, err = RequestFromReader(strings.NewReader("POST /submit HTTP/1.1\r\nContent-Type: text/plain\r\nContent-Length: 3\r\n\r\na=b"))
+	require.NoError(t, err)
+	form, err = r.ParseForm()
+	require.NoError(t, err)
+	assert.Empty(t, form)
+
+	// Test: Malformed body
+	r, err = RequestFromReader(strings.NewReader("POST /submit HTTP/1.1\r\nContent-Type: application/x-www-form-urlencoded\r\nContent-Length: 5\r\n\r\na=%zz"))
+	require.NoError(t, err)
+	_, err = r.ParseForm()
+	require.Error(t, err)
+}
@@ -0,0 +1,400 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"main/internal/headers"
+	"mime"
+	"os"
+	"path/filepath"
+)
+
+var (
+	ErrNotMultipart      = errors.New("request is not multipart/form-data")
+	ErrPartTooLarge      = errors.New("multipart part exceeds size limit")
+	ErrMultipartTooLarge = errors.New("multipart body exceeds size limit")
+	// ErrMultipartValuesTooLarge is returned by ReadForm when the form
+	// values alone take up more than MaxMemory.
+	ErrMultipartValuesTooLarge = errors.New("multipart form values exceed memory limit")
+)
+
+const (
+	defaultMaxMemory    = 10 << 20
+	defaultMaxPartSize  = 32 << 20
+	defaultMaxTotalSize = 64 << 20
+
+	maxPartHeaderSize   = 16 << 10
+	multipartBufferSize = 4096
+)
+
+// MultipartReader streams the parts of a multipart/form-data body. The
+// limits may be changed before the first call to NextPart; a limit of 0
+// disables it.
+type MultipartReader struct {
+	// MaxMemory is how many bytes ReadForm keeps in memory before file
+	// parts are spilled to temporary files. With 0, values and files are
+	// all kept in memory.
+	MaxMemory int64
+	// MaxPartSize caps the body of any single part.
+	MaxPartSize int64
+	// MaxTotalSize caps the whole multipart body, including part headers.
+	MaxTotalSize int64
+
+	br           *bufio.Reader
+	delim        []byte // "\r\n--boundary", which ends every part body
+	dashBoundary []byte // "--boundary", which opens the first part
+	current      *Part
+	started      bool
+	finished     bool
+}
+
+// MultipartReader returns a reader over the parts of a multipart/form-data
+// request body. Parts are read straight from Body as they are consumed.
+func (r *Request) MultipartReader() (*MultipartReader, error) {
+	mt, params, err := mime.ParseMediaType(r.Headers.Get("Content-Type"))
+	if err != nil || mt != "multipart/form-data" {
+		return nil, ErrNotMultipart
+	}
+	boundary := params["boundary"]
+	if boundary == "" || len(boundary) > 70 {
+		return nil, fmt.Errorf("invalid multipart boundary: %q", boundary)
+	}
+	return newMultipartReader(r.Body, boundary), nil
+}
+
+func newMultipartReader(body io.Reader, boundary string) *MultipartReader {
+	mr := &MultipartReader{
+		MaxMemory:    defaultMaxMemory,
+		MaxPartSize:  defaultMaxPartSize,
+		MaxTotalSize: defaultMaxTotalSize,
+		delim:        []byte("\r\n--" + boundary),
+		dashBoundary: []byte("--" + boundary),
+	}
+	mr.br = bufio.NewReaderSize(&totalLimitReader{r: body, mr: mr}, multipartBufferSize)
+	return mr
+}
+
+// NextPart returns the next part, or io.EOF after the closing delimiter.
+// Any unread data of the previous part is discarded.
+func (mr *MultipartReader) NextPart() (*Part, error) {
+	if mr.finished {
+		return nil, io.EOF
+	}
+	if mr.current != nil {
+		if _, err := io.Copy(io.Discard, mr.current); err != nil {
+			return nil, err
+		}
+		mr.current = nil
+	}
+
+	if !mr.started {
+		if err := mr.skipPreamble(); err != nil {
+			return nil, err
+		}
+		mr.started = true
+	} else {
+		// The previous part stopped right in front of its delimiter.
+		if _, err := mr.br.Discard(len(mr.delim)); err != nil {
+			return nil, err
+		}
+		line, err := mr.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if bytes.HasPrefix(line, []byte("--")) {
+			mr.finished = true
+			return nil, io.EOF
+		}
+		if len(bytes.TrimRight(line, " \t")) != 0 {
+			return nil, fmt.Errorf("malformed multipart delimiter: %q", line)
+		}
+	}
+	if mr.finished {
+		return nil, io.EOF
+	}
+
+	h, err := mr.readPartHeaders()
+	if err != nil {
+		return nil, err
+	}
+	mr.current = &Part{Headers: h, mr: mr}
+	return mr.current, nil
+}
+
+func (mr *MultipartReader) skipPreamble() error {
+	for {
+		line, err := mr.readLine()
+		if err == io.EOF {
+			return errors.New("multipart boundary not found")
+		}
+		if err != nil {
+			return err
+		}
+		line = bytes.TrimRight(line, " \t")
+		if bytes.Equal(line, mr.dashBoundary) {
+			return nil
+		}
+		if len(line) == len(mr.dashBoundary)+2 && bytes.HasPrefix(line, mr.dashBoundary) && bytes.HasSuffix(line, []byte("--")) {
+			mr.finished = true
+			return nil
+		}
+	}
+}
+
+// readLine returns the next line without its line ending.
+func (mr *MultipartReader) readLine() ([]byte, error) {
+	line, err := mr.br.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, errors.New("multipart line too long")
+	}
+	if err != nil {
+		if err == io.EOF && len(line) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	line = bytes.TrimSuffix(line[:len(line)-1], []byte("\r"))
+	return line, nil
+}
+
+func (mr *MultipartReader) readPartHeaders() (headers.Headers, error) {
+	var block []byte
+	for {
+		line, err := mr.readLine()
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		block = append(block, line...)
+		block = append(block, crlf...)
+		if len(block) > maxPartHeaderSize {
+			return nil, errors.New("multipart part headers too large")
+		}
+		if len(line) == 0 {
+			break
+		}
+	}
+
+	h := headers.NewHeaders()
+	if _, _, err := h.Parse(block); err != nil {
+		return nil, fmt.Errorf("error parsing multipart part headers: %w", err)
+	}
+	return h, nil
+}
+
+// Part is a single part of a multipart body. Read returns its body and
+// io.EOF at the delimiter that ends it.
+type Part struct {
+	Headers headers.Headers
+
+	mr  *MultipartReader
+	n   int64
+	eof bool
+}
+
+// FormName returns the name parameter of the part's Content-Disposition.
+func (p *Part) FormName() string {
+	return p.dispositionParam("name")
+}
+
+// FileName returns the base of the filename parameter of the part's
+// Content-Disposition, or "" for a regular form value.
+func (p *Part) FileName() string {
+	filename := p.dispositionParam("filename")
+	if filename == "" {
+		return ""
+	}
+	return filepath.Base(filename)
+}
+
+func (p *Part) dispositionParam(name string) string {
+	disposition, params, err := mime.ParseMediaType(p.Headers.Get("Content-Disposition"))
+	if err != nil || disposition != "form-data" {
+		return ""
+	}
+	return params[name]
+}
+
+func (p *Part) Read(d []byte) (int, error) {
+	if p.eof {
+		return 0, io.EOF
+	}
+	if len(d) == 0 {
+		return 0, nil
+	}
+
+	br, delim := p.mr.br, p.mr.delim
+	_, peekErr := br.Peek(len(delim))
+	if peekErr != nil && peekErr != io.EOF {
+		return 0, peekErr
+	}
+	peek, _ := br.Peek(br.Buffered())
+
+	var avail []byte
+	if i := bytes.Index(peek, delim); i >= 0 {
+		if i == 0 {
+			p.eof = true
+			return 0, io.EOF
+		}
+		avail = peek[:i]
+	} else if peekErr == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	} else {
+		// The tail could be the start of a delimiter split across reads.
+		avail = peek[:len(peek)-len(delim)+1]
+	}
+
+	n := copy(d, avail)
+	if max := p.mr.MaxPartSize; max > 0 && p.n+int64(n) > max {
+		return 0, ErrPartTooLarge
+	}
+	p.n += int64(n)
+	_, _ = br.Discard(n)
+	return n, nil
+}
+
+type totalLimitReader struct {
+	r  io.Reader
+	mr *MultipartReader
+	n  int64
+}
+
+func (l *totalLimitReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if max := l.mr.MaxTotalSize; max > 0 && l.n > max {
+		return 0, ErrMultipartTooLarge
+	}
+	return n, err
+}
+
+// Form is a fully read multipart form.
+type Form struct {
+	Value map[string][]string
+	File  map[string][]*FileHeader
+}
+
+// FileHeader describes a file part read by ReadForm. Its content is held
+// in memory or, past the reader's MaxMemory, in a temporary file.
+type FileHeader struct {
+	Filename string
+	Headers  headers.Headers
+	Size     int64
+
+	content []byte
+	tmpfile string
+}
+
+func (fh *FileHeader) Open() (io.ReadCloser, error) {
+	if fh.tmpfile != "" {
+		return os.Open(fh.tmpfile)
+	}
+	return io.NopCloser(bytes.NewReader(fh.content)), nil
+}
+
+// RemoveAll deletes the temporary files backing the form's file parts.
+func (f *Form) RemoveAll() error {
+	var errs []error
+	for _, fhs := range f.File {
+		for _, fh := range fhs {
+			if fh.tmpfile != "" {
+				if err := os.Remove(fh.tmpfile); err != nil && !errors.Is(err, os.ErrNotExist) {
+					errs = append(errs, err)
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ReadForm reads every remaining part. Form values and file parts are
+// kept in memory until MaxMemory is used up, after which file parts are
+// written to temporary files that the caller removes with Form.RemoveAll.
+// Values cannot be spilled, so values past MaxMemory fail the form with
+// ErrMultipartValuesTooLarge. A MaxMemory of 0 keeps the whole form in
+// memory.
+func (mr *MultipartReader) ReadForm() (*Form, error) {
+	form := &Form{
+		Value: make(map[string][]string),
+		File:  make(map[string][]*FileHeader),
+	}
+	if err := mr.readForm(form); err != nil {
+		_ = form.RemoveAll()
+		return nil, err
+	}
+	return form, nil
+}
+
+func (mr *MultipartReader) readForm(form *Form) error {
+	memoryLeft := mr.MaxMemory
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := part.FormName()
+		if name == "" {
+			continue
+		}
+
+		// Reading one byte past what is left tells whether the part fits.
+		var buf bytes.Buffer
+		var n int64
+		if mr.MaxMemory > 0 {
+			n, err = io.CopyN(&buf, part, memoryLeft+1)
+		} else {
+			n, err = io.Copy(&buf, part)
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		fits := mr.MaxMemory <= 0 || n <= memoryLeft
+
+		filename := part.FileName()
+		if filename == "" {
+			if !fits {
+				return ErrMultipartValuesTooLarge
+			}
+			memoryLeft -= n
+			form.Value[name] = append(form.Value[name], buf.String())
+			continue
+		}
+
+		fh := &FileHeader{Filename: filename, Headers: part.Headers}
+		if fits {
+			fh.content = buf.Bytes()
+			fh.Size = n
+			memoryLeft -= n
+		} else if err := spill(fh, io.MultiReader(&buf, part)); err != nil {
+			return err
+		}
+		form.File[name] = append(form.File[name], fh)
+	}
+}
+
+func spill(fh *FileHeader, r io.Reader) error {
+	file, err := os.CreateTemp("", "multipart-")
+	if err != nil {
+		return err
+	}
+	fh.tmpfile = file.Name()
+	size, err := io.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(fh.tmpfile)
+		return err
+	}
+	fh.Size = size
+	return nil
+}
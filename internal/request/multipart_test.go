@@ -0,0 +1,178 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const multipartBody = "preamble to ignore\r\n" +
+	"--xyz\r\n" +
+	"Content-Disposition: form-data; name=\"title\"\r\n" +
+	"\r\n" +
+	"hello world\r\n" +
+	"--xyz\r\n" +
+	"Content-Disposition: form-data; name=\"upload\"; filename=\"../../notes.txt\"\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"line one\r\nline two --xy not a boundary\r\n" +
+	"--xyz--\r\n" +
+	"epilogue"
+
+func multipartRequest(t *testing.T, body string) *Request {
+	t.Helper()
+	raw := fmt.Sprintf("POST /upload HTTP/1.1\r\nContent-Type: multipart/form-data; boundary=xyz\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+	r, err := RequestFromReader(&chunkReader{data: raw, numBytesPerRead: 7})
+	require.NoError(t, err)
+	return r
+}
+
+func TestMultipartReader(t *testing.T) {
+	// Test: Parts are streamed with their own headers
+	mr, err := multipartRequest(t, multipartBody).MultipartReader()
+	require.NoError(t, err)
+
+	part, err := mr.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "title", part.FormName())
+	assert.Equal(t, "", part.FileName())
+	data, err := io.ReadAll(part)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(data))
+
+	part, err = mr.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "upload", part.FormName())
+	assert.Equal(t, "notes.txt", part.FileName())
+	assert.Equal(t, "text/plain", part.Headers["content-type"])
+	data, err = io.ReadAll(part)
+	require.NoError(t, err)
+	assert.Equal(t, "line one\r\nline two --xy not a boundary", string(data))
+
+	_, err = mr.NextPart()
+	assert.Equal(t, io.EOF, err)
+
+	// Test: Unread parts are skipped
+	mr, err = multipartRequest(t, multipartBody).MultipartReader()
+	require.NoError(t, err)
+	_, err = mr.NextPart()
+	require.NoError(t, err)
+	part, err = mr.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "upload", part.FormName())
+
+	// Test: Not multipart
+	r, err := RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nContent-Type: text/plain\r\n\r\n"))
+	require.NoError(t, err)
+	_, err = r.MultipartReader()
+	assert.ErrorIs(t, err, ErrNotMultipart)
+
+	// Test: Missing closing delimiter
+	mr, err = multipartRequest(t, "--xyz\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\ntruncated").MultipartReader()
+	require.NoError(t, err)
+	part, err = mr.NextPart()
+	require.NoError(t, err)
+	_, err = io.ReadAll(part)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// Test: Empty form
+	mr, err = multipartRequest(t, "--xyz--\r\n").MultipartReader()
+	require.NoError(t, err)
+	_, err = mr.NextPart()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestMultipartLimits(t *testing.T) {
+	// Test: Per-part limit
+	mr, err := multipartRequest(t, multipartBody).MultipartReader()
+	require.NoError(t, err)
+	mr.MaxPartSize = 5
+	part, err := mr.NextPart()
+	require.NoError(t, err)
+	_, err = io.ReadAll(part)
+	assert.ErrorIs(t, err, ErrPartTooLarge)
+
+	// Test: Total limit
+	mr, err = multipartRequest(t, multipartBody).MultipartReader()
+	require.NoError(t, err)
+	mr.MaxTotalSize = 100
+	_, err = mr.ReadForm()
+	assert.True(t, errors.Is(err, ErrMultipartTooLarge), "got %v", err)
+
+	// Test: Form values count against MaxMemory, as they cannot spill
+	mr, err = multipartRequest(t, multipartBody).MultipartReader()
+	require.NoError(t, err)
+	mr.MaxMemory = 10
+	_, err = mr.ReadForm()
+	assert.ErrorIs(t, err, ErrMultipartValuesTooLarge)
+
+	// Test: A value exactly filling MaxMemory still fits
+	mr, err = multipartRequest(t, multipartBody).MultipartReader()
+	require.NoError(t, err)
+	mr.MaxMemory = 11
+	form, err := mr.ReadForm()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"hello world"}, form.Value["title"])
+	require.NoError(t, form.RemoveAll())
+}
+
+func TestReadForm(t *testing.T) {
+	// Test: Everything fits in memory
+	mr, err := multipartRequest(t, multipartBody).MultipartReader()
+	require.NoError(t, err)
+	form, err := mr.ReadForm()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"hello world"}, form.Value["title"])
+	require.Len(t, form.File["upload"], 1)
+	fh := form.File["upload"][0]
+	assert.Equal(t, "notes.txt", fh.Filename)
+	assert.Equal(t, int64(38), fh.Size)
+	assert.Empty(t, fh.tmpfile)
+	f, err := fh.Open()
+	require.NoError(t, err)
+	data, err := io.ReadAll(f)
+	require.NoError(t, err)
+	assert.Equal(t, "line one\r\nline two --xy not a boundary", string(data))
+	require.NoError(t, form.RemoveAll())
+
+	// Test: File parts past MaxMemory spill to disk
+	mr, err = multipartRequest(t, multipartBody).MultipartReader()
+	require.NoError(t, err)
+	mr.MaxMemory = 16
+	form, err = mr.ReadForm()
+	require.NoError(t, err)
+	fh = form.File["upload"][0]
+	require.NotEmpty(t, fh.tmpfile)
+	assert.Equal(t, int64(38), fh.Size)
+	f, err = fh.Open()
+	require.NoError(t, err)
+	data, err = io.ReadAll(f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	assert.Equal(t, "line one\r\nline two --xy not a boundary", string(data))
+	require.NoError(t, form.RemoveAll())
+	_, err = os.Stat(fh.tmpfile)
+	assert.True(t, os.IsNotExist(err))
+
+	// Test: A MaxMemory of 0 keeps values and files in memory
+	mr, err = multipartRequest(t, multipartBody).MultipartReader()
+	require.NoError(t, err)
+	mr.MaxMemory = 0
+	form, err = mr.ReadForm()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"hello world"}, form.Value["title"])
+	fh = form.File["upload"][0]
+	assert.Empty(t, fh.tmpfile)
+	assert.Equal(t, int64(38), fh.Size)
+	f, err = fh.Open()
+	require.NoError(t, err)
+	data, err = io.ReadAll(f)
+	require.NoError(t, err)
+	assert.Equal(t, "line one\r\nline two --xy not a boundary", string(data))
+}
@@ -0,0 +1,135 @@
+package request
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"main/internal/headers"
+)
+
+// Parser is a push-style request parser for callers that own their own
+// input, such as event loops or captured byte buffers. Feed follows the
+// same contract as headers.Headers.Parse: it reports how many bytes it
+// consumed, and any unconsumed bytes must be passed again at the front of
+// the next call together with whatever arrived since.
+type Parser struct {
+	req    *Request
+	logger *slog.Logger
+	// trace caches whether logger wants debug records, so the hot path
+	// does not box log arguments for a discarding logger.
+	trace bool
+
+	// scanned is how many bytes of the unparsed input are already known
+	// not to contain a CRLF, so partial lines are not searched again after
+	// every read.
+	scanned int
+
+	// streamBody stops the parser after the header block, leaving the body
+	// on the wire for RequestFromReader to attach as a lazy reader.
+	streamBody bool
+
+	// methods are accepted in addition to GET and POST.
+	methods [][]byte
+
+	// maxDecoded enables decoding of Content-Encoding bodies, up to that
+	// many decompressed bytes. Zero leaves bodies as sent.
+	maxDecoded int64
+
+	// maxHeaderBytes caps the request-line and header section together;
+	// headBytes counts what has been consumed of them so far.
+	maxHeaderBytes int
+	headBytes      int
+
+	body          []byte
+	contentLength int64
+	chunked       *chunkedDecoder
+}
+
+// Option configures a Parser.
+type Option func(*Parser)
+
+// WithLogger sends debug traces of every parser state transition to
+// logger. Parsers are silent by default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(p *Parser) {
+		if logger != nil {
+			p.logger = logger
+		}
+	}
+}
+
+// WithMethods accepts methods such as HEAD or PUT in addition to GET and
+// POST, which are always allowed.
+func WithMethods(methods ...string) Option {
+	return func(p *Parser) {
+		for _, m := range methods {
+			p.methods = append(p.methods, []byte(m))
+		}
+	}
+}
+
+// WithDecompression makes Body transparently decode gzip and deflate
+// Content-Encoding, failing with ErrDecompressedTooLarge once more than
+// limit bytes come out. Requests using any other coding are rejected with
+// ErrUnsupportedContentEncoding.
+func WithDecompression(limit int64) Option {
+	return func(p *Parser) {
+		p.maxDecoded = limit
+	}
+}
+
+// WithMaxHeaderBytes sets how large the request-line and header section
+// may be together, 64 KiB by default. Larger heads fail with
+// ErrHeaderTooLarge. Zero removes the limit.
+func WithMaxHeaderBytes(n int) Option {
+	return func(p *Parser) {
+		p.maxHeaderBytes = n
+	}
+}
+
+func NewParser(opts ...Option) *Parser {
+	p := &Parser{
+		req: &Request{
+			Headers:      headers.NewHeaders(),
+			Body:         NoBody,
+			ParserStatus: initialized,
+		},
+		logger:         slog.New(slog.DiscardHandler),
+		maxHeaderBytes: defaultMaxHeaderBytes,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	p.trace = p.logger.Enabled(context.Background(), slog.LevelDebug)
+	return p
+}
+
+// Feed parses as much of data as forms complete request-line and header
+// field-lines, then consumes the body framed by Content-Length or chunked
+// Transfer-Encoding, buffering it in memory. A consumed count of 0 with a
+// nil error means more data is needed.
+func (p *Parser) Feed(data []byte) (consumed int, err error) {
+	if p.Done() {
+		return 0, errors.New("Error: Attempting to parse data in done state")
+	}
+	return p.parse(data)
+}
+
+// Done reports whether a complete request, including its body, has been
+// parsed.
+func (p *Parser) Done() bool {
+	return p.req.ParserStatus == done
+}
+
+// Request returns the request being parsed. Its fields are only complete
+// once Done reports true.
+func (p *Parser) Request() *Request {
+	return p.req
+}
+
+func (p *Parser) setStatus(status Status) {
+	if p.trace {
+		p.logger.Debug("parser state transition", "from", p.req.ParserStatus, "to", status)
+	}
+	p.req.ParserStatus = status
+}
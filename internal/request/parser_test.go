@@ -0,0 +1,159 @@
+package request
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParserFeed(t *testing.T) {
+	// Test: Whole request in a single Feed
+	p := NewParser()
+	data := []byte("GET /coffee HTTP/1.1\r\nHost: localhost:42069\r\n\r\n")
+	n, err := p.Feed(data)
+	require.NoError(t, err)
+	assert.Equal(t, len(data), n)
+	assert.True(t, p.Done())
+	assert.Equal(t, "GET", p.Request().RequestLine.Method)
+	assert.Equal(t, "/coffee", p.Request().RequestLine.RequestTarget)
+	assert.Equal(t, "localhost:42069", p.Request().Headers["host"])
+
+	// Test: Partial data is left unconsumed
+	p = NewParser()
+	n, err = p.Feed([]byte("GET /coff"))
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.False(t, p.Done())
+
+	// Test: Byte at a time, re-feeding unconsumed bytes
+	p = NewParser()
+	var pending []byte
+	for _, c := range data {
+		pending = append(pending, c)
+		n, err = p.Feed(pending)
+		require.NoError(t, err)
+		pending = pending[n:]
+	}
+	assert.True(t, p.Done())
+	assert.Empty(t, pending)
+	assert.Equal(t, "/coffee", p.Request().RequestLine.RequestTarget)
+	assert.Equal(t, "localhost:42069", p.Request().Headers["host"])
+
+	// Test: Bytes after the header block are not consumed
+	p = NewParser()
+	n, err = p.Feed([]byte("POST /submit HTTP/1.1\r\n\r\nbody"))
+	require.NoError(t, err)
+	assert.Equal(t, 25, n)
+	assert.True(t, p.Done())
+
+	// Test: Feed after Done
+	_, err = p.Feed([]byte("more"))
+	require.Error(t, err)
+
+	// Test: Invalid request line
+	p = NewParser()
+	_, err = p.Feed([]byte("PUT /update HTTP/1.1\r\n\r\n"))
+	assert.ErrorIs(t, err, ErrUnsupportedMethod)
+	assert.False(t, p.Done())
+
+	// Test: Extra methods are accepted when configured
+	p = NewParser(WithMethods("HEAD", "PUT"))
+	_, err = p.Feed([]byte("HEAD /index.html HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	assert.True(t, p.Done())
+	assert.Equal(t, "HEAD", p.Request().RequestLine.Method)
+
+	p = NewParser(WithMethods("HEAD"))
+	_, err = p.Feed([]byte("DELETE / HTTP/1.1\r\n\r\n"))
+	assert.ErrorIs(t, err, ErrUnsupportedMethod)
+}
+
+func TestParserLogger(t *testing.T) {
+	// Test: State transitions are traced at debug level
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	_, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"), WithLogger(logger))
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), `from=initialized to="parsing headers"`)
+	assert.Contains(t, buf.String(), `from="parsing headers" to=done`)
+
+	// Test: Parse errors are traced
+	buf.Reset()
+	_, err = RequestFromReader(strings.NewReader("PUT / HTTP/1.1\r\n\r\n"), WithLogger(logger))
+	require.Error(t, err)
+	assert.Contains(t, buf.String(), "parse failed")
+}
+
+func TestParserBody(t *testing.T) {
+	// Test: Content-Length body is buffered
+	p := NewParser()
+	data := []byte("POST /submit HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello")
+	n, err := p.Feed(data)
+	require.NoError(t, err)
+	assert.Equal(t, len(data), n)
+	require.True(t, p.Done())
+	body, err := io.ReadAll(p.Request().Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+
+	// Test: Chunked body fed a byte at a time
+	p = NewParser()
+	data = []byte("POST /submit HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n6\r\n world\r\n0\r\nX-Sum: 1\r\n\r\n")
+	var pending []byte
+	for _, c := range data {
+		pending = append(pending, c)
+		n, err = p.Feed(pending)
+		require.NoError(t, err)
+		pending = pending[n:]
+	}
+	require.True(t, p.Done())
+	assert.Empty(t, pending)
+	body, err = io.ReadAll(p.Request().Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+	assert.Equal(t, "1", p.Request().Trailers["x-sum"])
+}
+
+func TestParserMaxHeaderBytes(t *testing.T) {
+	head := "GET / HTTP/1.1\r\nHost: a\r\n\r\n"
+
+	// Test: A head exactly at the limit is accepted, one byte more is not
+	p := NewParser(WithMaxHeaderBytes(len(head)))
+	n, err := p.Feed([]byte(head))
+	require.NoError(t, err)
+	assert.Equal(t, len(head), n)
+	assert.True(t, p.Done())
+	p = NewParser(WithMaxHeaderBytes(len(head) - 1))
+	_, err = p.Feed([]byte(head))
+	assert.ErrorIs(t, err, ErrHeaderTooLarge)
+
+	// Test: The limit holds when the head arrives a byte at a time
+	p = NewParser(WithMaxHeaderBytes(len(head) - 1))
+	var pending []byte
+	for _, c := range []byte(head) {
+		pending = append(pending, c)
+		n, err = p.Feed(pending)
+		if err != nil {
+			break
+		}
+		pending = pending[n:]
+	}
+	assert.ErrorIs(t, err, ErrHeaderTooLarge)
+
+	// Test: Many field-lines, each within the line limit, are rejected by
+	// the 64 KiB default without being parsed
+	many := "GET / HTTP/1.1\r\n" + strings.Repeat("X-A: "+strings.Repeat("a", 4000)+"\r\n", 3000) + "\r\n"
+	_, err = RequestFromReader(strings.NewReader(many))
+	assert.ErrorIs(t, err, ErrHeaderTooLarge)
+
+	// Test: Zero removes the limit
+	many = "GET / HTTP/1.1\r\n" + strings.Repeat("X-A: "+strings.Repeat("a", 4000)+"\r\n", 100) + "\r\n"
+	r, err := RequestFromReader(strings.NewReader(many), WithMaxHeaderBytes(0))
+	require.NoError(t, err)
+	assert.Len(t, r.Headers["x-a"], 100*4000+99*len(", "))
+}
@@ -1,11 +1,18 @@
 package request
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"main/internal/headers"
+	"net/url"
+	"slices"
 	"strings"
+	"sync"
 )
 
 type Status int
@@ -14,14 +21,91 @@ const (
 	initialized Status = iota
 	done
 	requestStateParsingHeaders
+	requestStateParsingBody
+	requestStateParsingChunkedBody
 )
 
-const bufferSize = 8
+func (s Status) String() string {
+	switch s {
+	case initialized:
+		return "initialized"
+	case done:
+		return "done"
+	case requestStateParsingHeaders:
+		return "parsing headers"
+	case requestStateParsingBody:
+		return "parsing body"
+	case requestStateParsingChunkedBody:
+		return "parsing chunked body"
+	default:
+		return fmt.Sprintf("Status(%d)", int(s))
+	}
+}
+
+// bufferSize is the size of the pooled connection reader. A request-line
+// or header field-line longer than this is rejected.
+const bufferSize = 8192
+
+// defaultMaxHeaderBytes caps the request head unless WithMaxHeaderBytes
+// says otherwise.
+const defaultMaxHeaderBytes = 64 << 10
+
+var readerPool = sync.Pool{
+	New: func() any {
+		return bufio.NewReaderSize(nil, bufferSize)
+	},
+}
+
+var (
+	crlf       = []byte("\r\n")
+	sp         = []byte(" ")
+	http11     = []byte("HTTP/1.1")
+	methodGet  = []byte("GET")
+	methodPost = []byte("POST")
+)
 
 type Request struct {
-	RequestLine  RequestLine
-	Headers      headers.Headers
+	RequestLine RequestLine
+	Headers     headers.Headers
+	// Body is never nil. Requests without a body get NoBody.
+	Body io.ReadCloser
+	// Trailers holds the trailer fields of a chunked body. It is populated
+	// once Body has been read to EOF.
+	Trailers     headers.Headers
 	ParserStatus Status
+	// RemoteAddr is the address of the client, set by the server that
+	// accepted the connection.
+	RemoteAddr string
+	// TLS describes the connection for requests received over TLS, and
+	// is nil otherwise. Verified client certificates are in
+	// TLS.PeerCertificates.
+	TLS *tls.ConnectionState
+
+	form url.Values
+	ctx  context.Context
+}
+
+// Context returns the request's context, which the server cancels when
+// the client disconnects or the handler returns. It is never nil.
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// WithContext returns a shallow copy of r with its context changed to
+// ctx.
+func (r *Request) WithContext(ctx context.Context) *Request {
+	r2 := *r
+	r2.ctx = ctx
+	return &r2
+}
+
+// ExpectsContinue reports whether the client sent Expect: 100-continue and
+// may be waiting for an interim response before sending the body.
+func (r *Request) ExpectsContinue() bool {
+	return strings.EqualFold(r.Headers.Get("Expect"), "100-continue")
 }
 
 type RequestLine struct {
@@ -30,102 +114,195 @@ type RequestLine struct {
 	Method        string
 }
 
-func RequestFromReader(reader io.Reader) (*Request, error) {
-	buf := make([]byte, bufferSize, bufferSize)
-	readToIndex := 0
-	req := &Request{
-		Headers:      headers.NewHeaders(),
-		ParserStatus: initialized,
+// RequestFromReader parses a request from reader and returns it once the
+// header block is complete; the body is then read lazily through
+// Request.Body. When reader is a *bufio.Reader, nothing past the end of
+// the request is consumed from it, so it can be reused for the next
+// request on a keep-alive connection. Otherwise a pooled reader is used,
+// and bytes read past the end of the body are discarded.
+func RequestFromReader(reader io.Reader, opts ...Option) (*Request, error) {
+	br, owned := reader.(*bufio.Reader)
+	release := func() {}
+	if !owned {
+		br = readerPool.Get().(*bufio.Reader)
+		br.Reset(reader)
+		release = func() {
+			br.Reset(nil)
+			readerPool.Put(br)
+		}
 	}
 
-	for req.ParserStatus != done {
+	p, err := readRequest(br, opts)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	req := p.Request()
+	if err := attachBody(req, br, release); err != nil {
+		release()
+		return nil, err
+	}
+	if p.maxDecoded > 0 {
+		// The codings were checked in startBody.
+		_ = decodeBody(req, p.maxDecoded)
+	}
+	return req, nil
+}
 
-		if readToIndex >= len(buf)-1 {
-			newBuf := make([]byte, len(buf)*2)
-			_ = copy(newBuf, buf[:readToIndex])
-			buf = newBuf
+// NewRequest assembles a request received by a transport other than the
+// HTTP/1.1 parser, such as an HTTP/2 stream, applying the same method
+// checks and body decoding that opts configure for parsed requests. h
+// must have lowercase keys, as Parse produces.
+func NewRequest(line RequestLine, h headers.Headers, body io.ReadCloser, opts ...Option) (*Request, error) {
+	p := NewParser(opts...)
+	if !methodAllowed([]byte(line.Method), p.methods) {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedMethod, line.Method)
+	}
+	req := p.req
+	req.RequestLine = line
+	req.Headers = h
+	req.Body = body
+	req.ParserStatus = done
+	if p.maxDecoded > 0 {
+		if err := decodeBody(req, p.maxDecoded); err != nil {
+			return nil, err
 		}
+	}
+	return req, nil
+}
 
-		n, err := reader.Read(buf[readToIndex:])
-		if n > 0 {
-			readToIndex += n
+func readRequest(br *bufio.Reader, opts []Option) (*Parser, error) {
+	p := NewParser(opts...)
+	p.streamBody = true
+	if err := ReadHead(br, p); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("Unexpected EOF: Headers not terminated properly")
 		}
+		return nil, err
+	}
+	return p, nil
+}
 
-		if err == io.EOF {
-			if req.ParserStatus != done {
-				return nil, fmt.Errorf("Unexpected EOF: Headers not terminated properly")
-			}
-			break
-		}
+// HeadParser is an incremental parser fed by ReadHead, such as Parser.
+type HeadParser interface {
+	Feed(data []byte) (int, error)
+	Done() bool
+}
 
-		parsed, err := req.parse(buf[:readToIndex])
+// ReadHead feeds p whatever br has buffered, reading more off the wire
+// as needed, until p is done. Consumed bytes are discarded from br, so
+// whatever follows the head is left for the body. It returns
+// ErrLineTooLong when a line does not fit in br, io.EOF when br ends
+// before any data, and io.ErrUnexpectedEOF when it ends part way. The
+// size of the head as a whole is left to p, as Parser checks it against
+// WithMaxHeaderBytes.
+func ReadHead(br *bufio.Reader, p HeadParser) error {
+	received := false
+	for {
+		data, _ := br.Peek(br.Buffered())
+		received = received || len(data) > 0
+		parsed, err := p.Feed(data)
 		if err != nil {
-			fmt.Printf("Error parsing data: %v\n", err)
-			return nil, err
+			return err
 		}
-		if parsed > 0 {
-			copy(buf, buf[parsed:readToIndex])
+		_, _ = br.Discard(parsed)
+		if p.Done() {
+			return nil
 		}
-		readToIndex -= parsed
 
+		// Peeking past what is buffered makes br read more off the wire.
+		if _, err := br.Peek(br.Buffered() + 1); err != nil {
+			switch {
+			case err == bufio.ErrBufferFull:
+				return ErrLineTooLong
+			case err == io.EOF && !received:
+				return io.EOF
+			case err == io.EOF:
+				return io.ErrUnexpectedEOF
+			default:
+				return err
+			}
+		}
 	}
-	var clear []byte
-	_ = copy(buf, clear)
-
-	return req, nil
 }
 
-func (r *Request) parse(data []byte) (int, error) {
+func (p *Parser) parse(data []byte) (int, error) {
 	totalBytesParsed := 0
-	for r.ParserStatus != done {
-		n, err := r.parseSingle(data[totalBytesParsed:])
+	for p.req.ParserStatus != done {
+		unparsed := data[totalBytesParsed:]
+		status := p.req.ParserStatus
+		lineBased := status == initialized || status == requestStateParsingHeaders
+		// Lines past the head limit are never looked at, so an oversized
+		// head costs no more than the limit to reject.
+		truncated := false
+		if room := p.maxHeaderBytes - p.headBytes; lineBased && p.maxHeaderBytes > 0 && len(unparsed) > room {
+			unparsed, truncated = unparsed[:room], true
+		}
+		if p.scanned > len(unparsed) {
+			p.scanned = 0
+		}
+		if lineBased && bytes.Index(unparsed[p.scanned:], crlf) == -1 {
+			if truncated {
+				return 0, fmt.Errorf("error parsing request: %w", ErrHeaderTooLarge)
+			}
+			// Keep a trailing '\r' in the next scan in case its '\n' is
+			// still on the wire.
+			p.scanned = max(len(unparsed)-1, 0)
+			if p.trace {
+				p.logger.Debug("waiting for more data", "state", p.req.ParserStatus, "pending", len(unparsed))
+			}
+			return totalBytesParsed, nil
+		}
+		n, err := p.parseSingle(unparsed)
 		if err != nil {
-			return 0, fmt.Errorf("error parsing request: %v\n", err)
+			if p.trace {
+				p.logger.Debug("parse failed", "state", p.req.ParserStatus, "error", err)
+			}
+			return 0, fmt.Errorf("error parsing request: %w", err)
 		}
 		if n == 0 {
 			return totalBytesParsed, nil
 		}
+		if lineBased {
+			p.headBytes += n
+		}
 		totalBytesParsed += n
+		p.scanned = 0
 	}
 	return totalBytesParsed, nil
 }
 
-func parseRequestLine(data []byte) (*Request, int, error) {
-	if !strings.Contains(string(data), "\r\n") {
+// parseRequestLine accepts GET and POST, plus any method in extra.
+func parseRequestLine(data []byte, extra [][]byte) (*Request, int, error) {
+	idx := bytes.Index(data, crlf)
+	if idx == -1 {
 		return nil, 0, nil
 	}
 
-	split := strings.SplitN(string(data), "\r\n", 2)
-	if len(split) < 2 {
-		return nil, 0, nil
-	}
-
-	rLineStr := split[0]
-	lineSplit := strings.Split(rLineStr, " ")
+	rLine := data[:idx]
+	method, rest, found := bytes.Cut(rLine, sp)
+	target, version, _ := bytes.Cut(rest, sp)
 	var request *Request
 
 	switch true {
 
-	case len(lineSplit) == 1:
+	case !found:
 		return nil, 0, nil
 
-	case len(lineSplit) != 3:
-		fmt.Printf("error reading data: %v\n", rLineStr)
-		return nil, len(data), errors.New("invalid request format")
+	case len(target) == 0 || len(version) == 0 || bytes.IndexByte(version, ' ') != -1:
+		return nil, len(data), fmt.Errorf("invalid request format: %q", rLine)
 
-	case lineSplit[2] != "HTTP/1.1":
-		fmt.Printf("invalid HTTP version: %s\n", lineSplit[2])
-		return nil, len(data), errors.New("Invalid HTTP Version:")
+	case !bytes.Equal(version, http11):
+		return nil, len(data), fmt.Errorf("Invalid HTTP Version: %q", version)
 
-	case lineSplit[0] != "GET" && lineSplit[0] != "POST":
-		fmt.Printf("invalid HTTP Method: %s\n", lineSplit[0])
-		return nil, len(data), errors.New("Invalid HTTP Method")
+	case !methodAllowed(method, extra):
+		return nil, len(data), fmt.Errorf("%w: %q", ErrUnsupportedMethod, method)
 
 	default:
 		rLine := RequestLine{
-			HttpVersion:   lineSplit[2][5:],
-			RequestTarget: lineSplit[1],
-			Method:        lineSplit[0],
+			HttpVersion:   string(version[len("HTTP/"):]),
+			RequestTarget: string(target),
+			Method:        string(method),
 		}
 
 		request = &Request{
@@ -134,56 +311,122 @@ func parseRequestLine(data []byte) (*Request, int, error) {
 
 	}
 
-	return request, len(split[0]) + len("\r\n"), nil
+	return request, idx + len(crlf), nil
+}
+
+func methodAllowed(method []byte, extra [][]byte) bool {
+	return bytes.Equal(method, methodGet) || bytes.Equal(method, methodPost) ||
+		slices.ContainsFunc(extra, func(m []byte) bool { return bytes.Equal(method, m) })
 }
 
-func (r *Request) parseSingle(data []byte) (int, error) {
+func (p *Parser) parseSingle(data []byte) (int, error) {
+	r := p.req
 	switch r.ParserStatus {
 
 	case initialized:
-		if strings.Contains(string(data), "\r\n") {
-			newReq, n, err := parseRequestLine(data)
-			if err != nil {
-				fmt.Printf("error parsing data: %v\n", err)
-				return 0, err
-			}
-			if n == 0 {
-				return 0, nil
-			}
-			if newReq != nil {
-				r.RequestLine = newReq.RequestLine
-				r.ParserStatus = requestStateParsingHeaders
-			}
-			return n, nil
+		newReq, n, err := parseRequestLine(data, p.methods)
+		if err != nil {
+			return 0, err
+		}
+		if n == 0 {
+			return 0, nil
+		}
+		if newReq != nil {
+			r.RequestLine = newReq.RequestLine
+			p.setStatus(requestStateParsingHeaders)
 		}
-		return 0, nil
+		return n, nil
 
 	case done:
 		return 0, errors.New("Error: Attempting to parse data in done state")
 
 	case requestStateParsingHeaders:
-		if strings.Contains(string(data), "\r\n") {
-			h := r.Headers
-			if r.Headers == nil {
-				return 0, errors.New("r.Headers is nil")
-			}
-			n, complete, err := h.Parse(data)
-			if err != nil {
-				return 0, fmt.Errorf("error parsing header field-lines: %v\n", err)
-			}
-			if n == 0 {
-				return 0, nil
-			}
-			if complete {
-				r.ParserStatus = done
-				r.Headers = h
+		h := r.Headers
+		if r.Headers == nil {
+			return 0, errors.New("r.Headers is nil")
+		}
+		n, complete, err := h.Parse(data)
+		if err != nil {
+			return 0, fmt.Errorf("error parsing header field-lines: %w", err)
+		}
+		if n == 0 {
+			return 0, nil
+		}
+		if complete {
+			r.Headers = h
+			if err := p.startBody(); err != nil {
+				return 0, err
 			}
-			return n, nil
 		}
-		return 0, nil
+		return n, nil
+
+	case requestStateParsingBody:
+		n := int(min(p.contentLength-int64(len(p.body)), int64(len(data))))
+		p.body = append(p.body, data[:n]...)
+		if int64(len(p.body)) == p.contentLength {
+			p.finishBody()
+		}
+		return n, nil
+
+	case requestStateParsingChunkedBody:
+		n, payload, err := p.chunked.decode(data, len(data))
+		if err != nil {
+			return 0, err
+		}
+		p.body = append(p.body, payload...)
+		if p.chunked.done() {
+			r.Trailers = p.chunked.trailers
+			p.finishBody()
+		}
+		return n, nil
 
 	default:
 		return 0, errors.New("Error: Unknown State")
 
 	}
 }
+
+// maxBodyPrealloc caps how much of a declared Content-Length is allocated
+// up front, so a large claim alone cannot reserve memory.
+const maxBodyPrealloc = 64 * 1024
+
+// startBody picks the body framing once the header block is complete. In
+// streaming mode the parser stops here and RequestFromReader attaches a
+// lazy Body instead.
+func (p *Parser) startBody() error {
+	chunked, length, err := bodyFraming(p.req.Headers)
+	if err != nil {
+		return err
+	}
+	if p.maxDecoded > 0 {
+		// Reject unknown codings before any of the body is read.
+		if _, err := contentCodings(p.req.Headers); err != nil {
+			return err
+		}
+	}
+	switch {
+	case p.streamBody:
+		p.setStatus(done)
+	case chunked:
+		p.chunked = newChunkedDecoder()
+		p.setStatus(requestStateParsingChunkedBody)
+	case length > 0:
+		p.contentLength = length
+		p.body = make([]byte, 0, min(length, maxBodyPrealloc))
+		p.setStatus(requestStateParsingBody)
+	default:
+		p.finishBody()
+	}
+	return nil
+}
+
+func (p *Parser) finishBody() {
+	if len(p.body) > 0 {
+		p.req.Body = io.NopCloser(bytes.NewReader(p.body))
+	}
+	if p.maxDecoded > 0 {
+		// The codings were checked in startBody.
+		_ = decodeBody(p.req, p.maxDecoded)
+	}
+	p.setStatus(done)
+}
@@ -1,7 +1,10 @@
 package request
 
 import (
+	"context"
+	"fmt"
 	"io"
+	"main/internal/headers"
 	"strings"
 	"testing"
 
@@ -255,3 +258,63 @@ func TestRequestParsingHeaders(t *testing.T) {
 	require.Error(t, err)
 	assert.Contains(t, err.Error(), "Unexpected EOF")
 }
+
+func BenchmarkRequestFromReader(b *testing.B) {
+	data := "GET /coffee HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n" +
+		"X-Padding: " + strings.Repeat("x", 512) + "\r\n\r\n"
+	for _, size := range []int{1, 3, 8, 64, 1024} {
+		b.Run(fmt.Sprintf("chunk=%d", size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				reader := &chunkReader{
+					data:            data,
+					numBytesPerRead: size,
+				}
+				if _, err := RequestFromReader(reader); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestNewRequest(t *testing.T) {
+	line := RequestLine{HttpVersion: "2", RequestTarget: "/", Method: "GET"}
+
+	// Test: Requests assembled elsewhere keep their fields
+	h := headers.NewHeaders()
+	h.Set("Host", "example.com")
+	req, err := NewRequest(line, h, NoBody)
+	require.NoError(t, err)
+	assert.Equal(t, line, req.RequestLine)
+	assert.Equal(t, "example.com", req.Headers.Get("Host"))
+	assert.Equal(t, NoBody, req.Body)
+
+	// Test: Methods are checked like parsed requests
+	_, err = NewRequest(RequestLine{HttpVersion: "2", RequestTarget: "/", Method: "PUT"}, headers.NewHeaders(), NoBody)
+	assert.ErrorIs(t, err, ErrUnsupportedMethod)
+	_, err = NewRequest(RequestLine{HttpVersion: "2", RequestTarget: "/", Method: "PUT"}, headers.NewHeaders(), NoBody, WithMethods("PUT"))
+	assert.NoError(t, err)
+
+	// Test: Decompression applies too
+	h = headers.NewHeaders()
+	h.Set("Content-Encoding", "br")
+	_, err = NewRequest(line, h, io.NopCloser(strings.NewReader("x")), WithDecompression(1024))
+	assert.ErrorIs(t, err, ErrUnsupportedContentEncoding)
+}
+
+func TestRequestContext(t *testing.T) {
+	req, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: a\r\n\r\n"))
+	require.NoError(t, err)
+
+	// Test: Requests outside a server get a background context
+	assert.Equal(t, context.Background(), req.Context())
+
+	// Test: WithContext copies the request
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req2 := req.WithContext(ctx)
+	assert.Equal(t, ctx, req2.Context())
+	assert.Equal(t, context.Background(), req.Context())
+	assert.Equal(t, req.RequestLine, req2.RequestLine)
+}
@@ -0,0 +1,227 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"main/internal/headers"
+	"main/internal/request"
+	"strconv"
+	"strings"
+)
+
+// Response is a response read off the wire by ResponseFromReader.
+type Response struct {
+	StatusLine StatusLine
+	Headers    headers.Headers
+	// Body is never nil. Responses without a body get request.NoBody.
+	Body io.ReadCloser
+	// Trailers holds the trailer fields of a chunked body. It is populated
+	// once Body has been read to EOF.
+	Trailers headers.Headers
+}
+
+type StatusLine struct {
+	HttpVersion string
+	StatusCode  StatusCode
+	// ReasonPhrase may be empty.
+	ReasonPhrase string
+}
+
+// bufferSize is the size of the reader ResponseFromReader wraps around
+// readers that are not already buffered.
+const bufferSize = 8192
+
+// defaultMaxHeaderBytes caps the response head unless WithMaxHeaderBytes
+// says otherwise, as request.Parser does for requests.
+const defaultMaxHeaderBytes = 64 << 10
+
+// ErrHeaderTooLarge is returned when the status line and header section
+// together exceed WithMaxHeaderBytes.
+var ErrHeaderTooLarge = errors.New("response header section too large")
+
+var (
+	crlf   = []byte("\r\n")
+	sp     = []byte(" ")
+	http1x = []byte("HTTP/1.")
+)
+
+// ReadOption configures ResponseFromReader.
+type ReadOption func(*responseParser)
+
+// WithRequestMethod names the method of the request being answered.
+// Responses to HEAD never have a body, whatever their headers say.
+func WithRequestMethod(method string) ReadOption {
+	return func(p *responseParser) {
+		p.method = method
+	}
+}
+
+// WithMaxHeaderBytes sets how large the status line and header section
+// may be together, 64 KiB by default. Larger heads fail with
+// ErrHeaderTooLarge. Zero removes the limit.
+func WithMaxHeaderBytes(n int) ReadOption {
+	return func(p *responseParser) {
+		p.maxHeaderBytes = n
+	}
+}
+
+// ResponseFromReader parses a response from reader and returns it once
+// the header block is complete; the body is then read lazily through
+// Response.Body. Interim 1xx responses are returned like any other, so
+// callers expecting a final response read again. When reader is a
+// *bufio.Reader, nothing past the end of the response is consumed from
+// it, so it can be reused on a keep-alive connection.
+func ResponseFromReader(reader io.Reader, opts ...ReadOption) (*Response, error) {
+	br, ok := reader.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReaderSize(reader, bufferSize)
+	}
+
+	p := &responseParser{
+		resp:           &Response{Headers: headers.NewHeaders(), Body: request.NoBody},
+		maxHeaderBytes: defaultMaxHeaderBytes,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if err := request.ReadHead(br, p); err != nil {
+		return nil, err
+	}
+
+	resp := p.resp
+	chunked, length, err := bodyFraming(p.method, resp)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body, resp.Trailers = request.NewBodyReader(br, chunked, length)
+	return resp, nil
+}
+
+type parserState int
+
+const (
+	parsingStatusLine parserState = iota
+	parsingHeaders
+	parsingDone
+)
+
+// responseParser parses a status line and header block, following the
+// same Feed contract as request.Parser.
+type responseParser struct {
+	resp           *Response
+	state          parserState
+	method         string
+	maxHeaderBytes int
+	headBytes      int
+}
+
+func (p *responseParser) Feed(data []byte) (int, error) {
+	total := 0
+	for p.state != parsingDone {
+		unparsed := data[total:]
+		// Bytes past the head limit are never looked at, so a head that
+		// outgrows it fails as soon as no whole line fits.
+		truncated := false
+		if room := p.maxHeaderBytes - p.headBytes; p.maxHeaderBytes > 0 && len(unparsed) > room {
+			unparsed, truncated = unparsed[:room], true
+		}
+		n, err := p.parseSingle(unparsed)
+		if err != nil {
+			return 0, fmt.Errorf("error parsing response: %w", err)
+		}
+		if n == 0 {
+			if truncated {
+				return 0, fmt.Errorf("error parsing response: %w", ErrHeaderTooLarge)
+			}
+			break
+		}
+		p.headBytes += n
+		total += n
+	}
+	return total, nil
+}
+
+func (p *responseParser) Done() bool {
+	return p.state == parsingDone
+}
+
+func (p *responseParser) parseSingle(data []byte) (int, error) {
+	switch p.state {
+
+	case parsingStatusLine:
+		idx := bytes.Index(data, crlf)
+		if idx == -1 {
+			return 0, nil
+		}
+		line, err := parseStatusLine(data[:idx])
+		if err != nil {
+			return 0, err
+		}
+		p.resp.StatusLine = line
+		p.state = parsingHeaders
+		return idx + len(crlf), nil
+
+	case parsingHeaders:
+		n, done, err := p.resp.Headers.Parse(data)
+		if err != nil {
+			return 0, fmt.Errorf("error parsing header field-lines: %w", err)
+		}
+		if done {
+			p.state = parsingDone
+		}
+		return n, nil
+
+	default:
+		return 0, errors.New("Error: Attempting to parse data in done state")
+
+	}
+}
+
+// parseStatusLine parses a line such as "HTTP/1.1 200 OK". Any HTTP/1.x
+// version is accepted, and the reason phrase may be missing.
+func parseStatusLine(line []byte) (StatusLine, error) {
+	version, rest, found := bytes.Cut(line, sp)
+	if !found || !bytes.HasPrefix(version, http1x) || len(version) != len(http1x)+1 {
+		return StatusLine{}, fmt.Errorf("invalid status line: %q", line)
+	}
+	code, reason, _ := bytes.Cut(rest, sp)
+	n, err := strconv.Atoi(string(code))
+	if len(code) != 3 || err != nil || n < 100 {
+		return StatusLine{}, fmt.Errorf("invalid status code: %q", code)
+	}
+	return StatusLine{
+		HttpVersion:  string(version[len("HTTP/"):]),
+		StatusCode:   StatusCode(n),
+		ReasonPhrase: string(reason),
+	}, nil
+}
+
+// bodyFraming reports how the body of resp, answering a request made with
+// method, is delimited (RFC 9112 6.3). length is -1 when the body runs
+// until the connection closes.
+func bodyFraming(method string, resp *Response) (chunked bool, length int64, err error) {
+	code := resp.StatusLine.StatusCode
+	if method == "HEAD" || code < 200 || code == StatusNoContent || code == StatusNotModified {
+		return false, 0, nil
+	}
+	h := resp.Headers
+	if te := h.Get("Transfer-Encoding"); te != "" {
+		if !strings.EqualFold(strings.TrimSpace(te), "chunked") {
+			return false, 0, fmt.Errorf("unsupported transfer-encoding: %q", te)
+		}
+		// Transfer-Encoding overrides Content-Length.
+		h.Delete("Content-Length")
+		return true, 0, nil
+	}
+	if cl := h.Get("Content-Length"); cl != "" {
+		length, err := strconv.ParseInt(cl, 10, 64)
+		if err != nil || length < 0 {
+			return false, 0, fmt.Errorf("invalid Content-Length: %q", cl)
+		}
+		return false, length, nil
+	}
+	return false, -1, nil
+}
@@ -0,0 +1,131 @@
+package response
+
+import (
+	"bufio"
+	"io"
+	"main/internal/request"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chunkReader returns at most numBytesPerRead bytes per Read, simulating
+// a response arriving in pieces off the network.
+type chunkReader struct {
+	data            string
+	numBytesPerRead int
+	pos             int
+}
+
+func (cr *chunkReader) Read(p []byte) (int, error) {
+	if cr.pos >= len(cr.data) {
+		return 0, io.EOF
+	}
+	end := min(cr.pos+cr.numBytesPerRead, len(cr.data))
+	n := copy(p, cr.data[cr.pos:end])
+	cr.pos += n
+	return n, nil
+}
+
+func readAll(t *testing.T, resp *Response) string {
+	t.Helper()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestResponseFromReader(t *testing.T) {
+	// Test: Status line, headers and a Content-Length body read a few
+	// bytes at a time
+	resp, err := ResponseFromReader(&chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 5\r\n\r\nhello",
+		numBytesPerRead: 3,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, StatusLine{HttpVersion: "1.1", StatusCode: StatusOK, ReasonPhrase: "OK"}, resp.StatusLine)
+	assert.Equal(t, "text/plain", resp.Headers.Get("Content-Type"))
+	assert.Equal(t, "hello", readAll(t, resp))
+
+	// Test: Chunked body with trailers
+	resp, err = ResponseFromReader(&chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nContent-Length: 99\r\n\r\n3\r\nabc\r\n2\r\nde\r\n0\r\nX-Sum: 5\r\n\r\n",
+		numBytesPerRead: 4,
+	})
+	require.NoError(t, err)
+	assert.Empty(t, resp.Headers.Get("Content-Length"))
+	assert.Equal(t, "abcde", readAll(t, resp))
+	assert.Equal(t, "5", resp.Trailers.Get("X-Sum"))
+
+	// Test: Without framing headers the body runs until EOF
+	resp, err = ResponseFromReader(strings.NewReader("HTTP/1.0 200 OK\r\n\r\nuntil close"))
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", resp.StatusLine.HttpVersion)
+	assert.Equal(t, "until close", readAll(t, resp))
+
+	// Test: A missing reason phrase is allowed
+	resp, err = ResponseFromReader(strings.NewReader("HTTP/1.1 204\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, StatusNoContent, resp.StatusLine.StatusCode)
+	assert.Empty(t, resp.StatusLine.ReasonPhrase)
+}
+
+func TestResponseWithoutBody(t *testing.T) {
+	// Test: HEAD, 1xx, 204 and 304 responses have no body whatever
+	// their headers say, and leave the next response on the reader
+	cases := []struct {
+		head   string
+		method string
+	}{
+		{"HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\n", "HEAD"},
+		{"HTTP/1.1 103 Early Hints\r\nLink: </a>\r\n\r\n", "GET"},
+		{"HTTP/1.1 204 No Content\r\nTransfer-Encoding: chunked\r\n\r\n", "GET"},
+		{"HTTP/1.1 304 Not Modified\r\nContent-Length: 10\r\n\r\n", "GET"},
+	}
+	for _, c := range cases {
+		br := bufio.NewReader(strings.NewReader(c.head + "HTTP/1.1 200 OK\r\nContent-Length: 4\r\n\r\nnext"))
+		resp, err := ResponseFromReader(br, WithRequestMethod(c.method))
+		require.NoError(t, err, c.head)
+		assert.Equal(t, request.NoBody, resp.Body, c.head)
+
+		resp, err = ResponseFromReader(br)
+		require.NoError(t, err, c.head)
+		assert.Equal(t, StatusOK, resp.StatusLine.StatusCode)
+		assert.Equal(t, "next", readAll(t, resp))
+	}
+}
+
+func TestResponseParsingErrors(t *testing.T) {
+	// Test: Invalid status lines
+	for _, line := range []string{"HTTP/2 200 OK", "HTTP/1.1 2000 OK", "HTTP/1.1 OK", "http/1.1 200 OK", "HTTP/1.1 099 Low", "HTTP/1.1"} {
+		_, err := ResponseFromReader(strings.NewReader(line + "\r\n\r\n"))
+		assert.Error(t, err, line)
+	}
+
+	// Test: Truncated heads are unexpected, an empty stream is plain EOF
+	_, err := ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nHost"))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	_, err = ResponseFromReader(strings.NewReader(""))
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: Oversized header lines
+	_, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nX-Big: " + strings.Repeat("a", 10000) + "\r\n\r\n"))
+	assert.ErrorIs(t, err, request.ErrLineTooLong)
+
+	// Test: Many short header lines still hit the head size limit
+	many := "HTTP/1.1 200 OK\r\n" + strings.Repeat("X-Many: "+strings.Repeat("a", 100)+"\r\n", 1000) + "\r\n"
+	_, err = ResponseFromReader(strings.NewReader(many))
+	assert.ErrorIs(t, err, ErrHeaderTooLarge)
+	_, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nX-A: 1\r\nX-B: 2\r\n\r\n"), WithMaxHeaderBytes(30))
+	assert.ErrorIs(t, err, ErrHeaderTooLarge)
+	resp, err := ResponseFromReader(strings.NewReader(many), WithMaxHeaderBytes(0))
+	require.NoError(t, err)
+	assert.Len(t, resp.Headers.Get("X-Many"), 1000*102-2)
+
+	// Test: Bad framing headers
+	_, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nTransfer-Encoding: gzip\r\n\r\n"))
+	assert.Error(t, err)
+	_, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: -1\r\n\r\n"))
+	assert.Error(t, err)
+}
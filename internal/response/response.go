@@ -0,0 +1,362 @@
+package response
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"main/internal/headers"
+	"main/internal/request"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Writer is what handlers use to send a response. Calls must follow the
+// order of the message: an optional WriteInterim, then WriteStatusLine,
+// WriteHeaders and the body, which is either written with WriteBody or
+// with WriteChunkedBody/WriteChunkedBodyDone and optional WriteTrailers.
+type Writer interface {
+	// WriteInterim sends a 1xx informational response, such as 103 Early
+	// Hints, ahead of the final one.
+	WriteInterim(code StatusCode, h headers.Headers) error
+	WriteStatusLine(code StatusCode) error
+	WriteHeaders(h headers.Headers) error
+	WriteBody(p []byte) (int, error)
+	WriteChunkedBody(p []byte) (int, error)
+	WriteChunkedBodyDone() (int, error)
+	WriteTrailers(h headers.Headers) error
+}
+
+// Hijacker is implemented by Writers that let a handler take over the
+// connection, as CONNECT tunnels and protocol upgrades need. After Hijack
+// the server no longer reads from, writes to or closes the connection.
+// Middleware wrapping a Writer should pass Hijack through. HTTP/2
+// streams share their connection and cannot be hijacked.
+type Hijacker interface {
+	// Hijack returns the connection together with a buffered reader that
+	// may already hold bytes the client sent after the request, and a
+	// writer that must be flushed.
+	Hijack() (net.Conn, *bufio.ReadWriter, error)
+}
+
+// NewBodyWriter returns an io.Writer that sends what is written to it
+// through w.WriteBody, for copying into a body of known length.
+func NewBodyWriter(w Writer) io.Writer {
+	return bodyWriter{w}
+}
+
+// NewChunkedBodyWriter is like NewBodyWriter for chunked bodies: each
+// write becomes a WriteChunkedBody call.
+func NewChunkedBodyWriter(w Writer) io.Writer {
+	return chunkedBodyWriter{w}
+}
+
+type bodyWriter struct {
+	w Writer
+}
+
+func (b bodyWriter) Write(p []byte) (int, error) {
+	return b.w.WriteBody(p)
+}
+
+type chunkedBodyWriter struct {
+	w Writer
+}
+
+func (c chunkedBodyWriter) Write(p []byte) (int, error) {
+	return c.w.WriteChunkedBody(p)
+}
+
+var (
+	ErrWriteOrder  = errors.New("response written out of order")
+	ErrBodyTooLong = errors.New("response body longer than Content-Length")
+	// ErrHijacked is returned by writes after the connection was hijacked.
+	ErrHijacked = errors.New("connection has been hijacked")
+	// ErrNotHijackable is returned by Hijack on writers that were not
+	// created over a connection.
+	ErrNotHijackable = errors.New("connection cannot be hijacked")
+)
+
+type writerState int
+
+const (
+	writingStatusLine writerState = iota
+	writingHeaders
+	writingBody
+	writingTrailers
+	writingDone
+)
+
+// ConnWriter writes an HTTP/1.1 response straight to a connection.
+type ConnWriter struct {
+	w     io.Writer
+	state writerState
+
+	status  StatusCode
+	chunked bool
+	// remaining is the unwritten part of a declared Content-Length, or -1
+	// when the body is not length-delimited.
+	remaining int64
+	trailers  bool
+	close     bool
+	// head drops everything after the header block, as the response to a
+	// HEAD request carries no body.
+	head bool
+
+	// conn and br are set for writers that can be hijacked.
+	conn     net.Conn
+	br       *bufio.Reader
+	hijacked bool
+}
+
+func NewConnWriter(w io.Writer) *ConnWriter {
+	return &ConnWriter{w: w, remaining: -1}
+}
+
+// NewServerConnWriter returns a writer over conn that can be hijacked. br
+// is the reader the request was parsed from.
+func NewServerConnWriter(conn net.Conn, br *bufio.Reader) *ConnWriter {
+	return &ConnWriter{w: conn, remaining: -1, conn: conn, br: br}
+}
+
+func (w *ConnWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.conn == nil {
+		return nil, nil, ErrNotHijackable
+	}
+	if w.hijacked {
+		return nil, nil, ErrHijacked
+	}
+	w.hijacked = true
+	w.state = writingDone
+	w.close = true
+	return w.conn, bufio.NewReadWriter(w.br, bufio.NewWriter(w.conn)), nil
+}
+
+// Hijacked reports whether Hijack has handed over the connection.
+func (w *ConnWriter) Hijacked() bool {
+	return w.hijacked
+}
+
+func (w *ConnWriter) orderErr() error {
+	if w.hijacked {
+		return ErrHijacked
+	}
+	return ErrWriteOrder
+}
+
+// GetDefaultHeaders returns the headers of a plain-text body of
+// contentLen bytes.
+func GetDefaultHeaders(contentLen int) headers.Headers {
+	h := headers.NewHeaders()
+	h.Set("Content-Length", strconv.Itoa(contentLen))
+	h.Set("Content-Type", "text/plain")
+	return h
+}
+
+// SuppressBody makes w send only the status line and headers, for
+// responses to HEAD requests. Body writes are still checked against the
+// declared framing, then discarded.
+func (w *ConnWriter) SuppressBody() {
+	w.head = true
+}
+
+func (w *ConnWriter) WriteInterim(code StatusCode, h headers.Headers) error {
+	if w.state != writingStatusLine {
+		return w.orderErr()
+	}
+	if code < 100 || code > 199 || code == StatusSwitchingProtocols {
+		return fmt.Errorf("not an interim status code: %d", code)
+	}
+	if err := w.writeStatusLine(code); err != nil {
+		return err
+	}
+	if h == nil {
+		h = headers.NewHeaders()
+	}
+	_, err := h.WriteTo(w.w)
+	return err
+}
+
+func (w *ConnWriter) WriteStatusLine(code StatusCode) error {
+	if w.state != writingStatusLine {
+		return w.orderErr()
+	}
+	if code < 100 || code > 999 || (code < 200 && code != StatusSwitchingProtocols) {
+		return fmt.Errorf("invalid final status code: %d", code)
+	}
+	if err := w.writeStatusLine(code); err != nil {
+		return err
+	}
+	w.status = code
+	w.state = writingHeaders
+	return nil
+}
+
+func (w *ConnWriter) writeStatusLine(code StatusCode) error {
+	_, err := fmt.Fprintf(w.w, "HTTP/1.1 %d %s\r\n", code, StatusText(code))
+	return err
+}
+
+func (w *ConnWriter) WriteHeaders(h headers.Headers) error {
+	if w.state != writingHeaders {
+		return w.orderErr()
+	}
+
+	w.chunked = strings.EqualFold(h.Get("Transfer-Encoding"), "chunked")
+	w.trailers = h.Get("Trailer") != ""
+	if v := h.Get("Content-Length"); v != "" && !w.chunked {
+		length, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || length < 0 {
+			return fmt.Errorf("invalid Content-Length: %q", v)
+		}
+		w.remaining = length
+	}
+	if h.HasToken("Connection", "close") {
+		w.close = true
+	}
+	if !w.chunked && w.remaining < 0 && w.hasBody() && !w.head {
+		// The body runs until the connection closes.
+		w.close = true
+	}
+
+	if _, err := h.WriteTo(w.w); err != nil {
+		return err
+	}
+	w.state = writingBody
+	if !w.hasBody() {
+		w.state = writingDone
+	}
+	return nil
+}
+
+func (w *ConnWriter) hasBody() bool {
+	return w.status >= 200 && w.status != StatusNoContent && w.status != StatusNotModified
+}
+
+func (w *ConnWriter) WriteBody(p []byte) (int, error) {
+	if w.state != writingBody || w.chunked {
+		return 0, w.orderErr()
+	}
+	if w.remaining >= 0 {
+		if int64(len(p)) > w.remaining {
+			return 0, ErrBodyTooLong
+		}
+		w.remaining -= int64(len(p))
+	}
+	if w.head {
+		return len(p), nil
+	}
+	return w.w.Write(p)
+}
+
+func (w *ConnWriter) WriteChunkedBody(p []byte) (int, error) {
+	if w.state != writingBody || !w.chunked {
+		return 0, w.orderErr()
+	}
+	if len(p) == 0 {
+		// A zero-length chunk would end the body.
+		return 0, nil
+	}
+	if w.head {
+		return len(p), nil
+	}
+	return request.NewChunkedWriter(w.w).Write(p)
+}
+
+// WriteChunkedBodyDone writes the last chunk. If the headers announced a
+// Trailer field, WriteTrailers must follow; otherwise the response is
+// complete.
+func (w *ConnWriter) WriteChunkedBodyDone() (int, error) {
+	if w.state != writingBody || !w.chunked {
+		return 0, w.orderErr()
+	}
+	if w.trailers {
+		w.state = writingTrailers
+	} else {
+		w.state = writingDone
+	}
+	switch {
+	case w.head:
+		return 0, nil
+	case w.trailers:
+		return io.WriteString(w.w, "0\r\n")
+	default:
+		return io.WriteString(w.w, "0\r\n\r\n")
+	}
+}
+
+func (w *ConnWriter) WriteTrailers(h headers.Headers) error {
+	if w.state != writingTrailers {
+		return w.orderErr()
+	}
+	// Checked before the body is considered done, so Finish can still
+	// end it with empty trailers.
+	if err := h.Validate(); err != nil {
+		return err
+	}
+	w.state = writingDone
+	if w.head {
+		return nil
+	}
+	if _, err := h.WriteTo(w.w); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Error writes a complete plain-text response with message as its body.
+func Error(w Writer, code StatusCode, message string) error {
+	if err := w.WriteStatusLine(code); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(GetDefaultHeaders(len(message))); err != nil {
+		return err
+	}
+	_, err := w.WriteBody([]byte(message))
+	return err
+}
+
+// Started reports whether the final status line has been written.
+func (w *ConnWriter) Started() bool {
+	return w.state != writingStatusLine
+}
+
+// Finish completes whatever the handler left unfinished: an unwritten
+// response becomes an empty 200, and an open chunked body is terminated.
+func (w *ConnWriter) Finish() error {
+	switch w.state {
+	case writingStatusLine:
+		if err := w.WriteStatusLine(StatusOK); err != nil {
+			return err
+		}
+		return w.WriteHeaders(GetDefaultHeaders(0))
+	case writingHeaders:
+		if !w.hasBody() {
+			return w.WriteHeaders(headers.NewHeaders())
+		}
+		return w.WriteHeaders(GetDefaultHeaders(0))
+	case writingBody:
+		if w.chunked {
+			_, err := w.WriteChunkedBodyDone()
+			if err == nil && w.state == writingTrailers {
+				err = w.WriteTrailers(headers.NewHeaders())
+			}
+			return err
+		}
+		if w.remaining > 0 && !w.head {
+			// The client is still waiting for bytes that will never come.
+			w.close = true
+		}
+		w.state = writingDone
+	case writingTrailers:
+		return w.WriteTrailers(headers.NewHeaders())
+	}
+	return nil
+}
+
+// KeepAlive reports whether the connection can carry another response
+// after this one.
+func (w *ConnWriter) KeepAlive() bool {
+	return !w.close
+}
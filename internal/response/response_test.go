@@ -0,0 +1,247 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"main/internal/headers"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnWriter(t *testing.T) {
+	// Test: Content-Length response
+	var buf bytes.Buffer
+	w := NewConnWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	n, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	require.NoError(t, w.Finish())
+	assert.True(t, w.KeepAlive())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ncontent-length: 5\r\ncontent-type: text/plain\r\n\r\nhello", buf.String())
+
+	// Test: Body longer than Content-Length
+	_, err = w.WriteBody([]byte("x"))
+	require.Error(t, err)
+
+	// Test: Out of order writes
+	buf.Reset()
+	w = NewConnWriter(&buf)
+	assert.ErrorIs(t, w.WriteHeaders(headers.NewHeaders()), ErrWriteOrder)
+	_, err = w.WriteBody([]byte("x"))
+	assert.ErrorIs(t, err, ErrWriteOrder)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	assert.ErrorIs(t, w.WriteStatusLine(StatusOK), ErrWriteOrder)
+
+	// Test: Chunked body with trailers
+	buf.Reset()
+	w = NewConnWriter(&buf)
+	h := headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Trailer", "X-Checksum")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteChunkedBody([]byte("hello world"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBody(nil)
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Checksum", "abc")
+	require.NoError(t, w.WriteTrailers(trailers))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ntrailer: X-Checksum\r\ntransfer-encoding: chunked\r\n\r\nb\r\nhello world\r\n0\r\nx-checksum: abc\r\n\r\n", buf.String())
+
+	// Test: Finish terminates an open chunked body
+	buf.Reset()
+	w = NewConnWriter(&buf)
+	h = headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteChunkedBody([]byte("hi"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ntransfer-encoding: chunked\r\n\r\n2\r\nhi\r\n0\r\n\r\n", buf.String())
+
+	// Test: Finish on an unwritten response
+	buf.Reset()
+	w = NewConnWriter(&buf)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ncontent-length: 0\r\ncontent-type: text/plain\r\n\r\n", buf.String())
+
+	// Test: Short body closes the connection
+	buf.Reset()
+	w = NewConnWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(10)))
+	require.NoError(t, w.Finish())
+	assert.False(t, w.KeepAlive())
+
+	// Test: No Content-Length means a close-delimited body
+	buf.Reset()
+	w = NewConnWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	assert.False(t, w.KeepAlive())
+
+	// Test: 204 has no body
+	buf.Reset()
+	w = NewConnWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusNoContent))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	_, err = w.WriteBody([]byte("x"))
+	assert.ErrorIs(t, err, ErrWriteOrder)
+	assert.True(t, w.KeepAlive())
+
+	// Test: A value with a line break is refused, and Finish still sends
+	// a well-formed response
+	buf.Reset()
+	w = NewConnWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusFound))
+	assert.ErrorIs(t, w.WriteHeaders(headers.Headers{"location": "/\r\nSet-Cookie: a=1"}), headers.ErrInvalidField)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 302 Found\r\ncontent-length: 0\r\ncontent-type: text/plain\r\n\r\n", buf.String())
+
+	// Test: So are bad trailers, and the chunked body is still ended
+	buf.Reset()
+	w = NewConnWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.Headers{"transfer-encoding": "chunked", "trailer": "X-Sum"}))
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	assert.ErrorIs(t, w.WriteTrailers(headers.Headers{"x-sum": "1\nX-Injected: 1"}), headers.ErrInvalidField)
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasSuffix(buf.String(), "0\r\n\r\n"), buf.String())
+}
+
+func TestBodyWriters(t *testing.T) {
+	// Test: NewBodyWriter copies into a body of known length
+	var buf bytes.Buffer
+	w := NewConnWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	_, err := io.Copy(NewBodyWriter(w), strings.NewReader("hello"))
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\nhello"), buf.String())
+	_, err = io.WriteString(NewBodyWriter(w), "x")
+	assert.ErrorIs(t, err, ErrBodyTooLong)
+
+	// Test: NewChunkedBodyWriter sends each write as a chunk
+	buf.Reset()
+	w = NewConnWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.Headers{"transfer-encoding": "chunked"}))
+	_, err = io.WriteString(NewChunkedBodyWriter(w), "hello")
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n5\r\nhello\r\n0\r\n\r\n"), buf.String())
+}
+
+func TestInterimResponses(t *testing.T) {
+	// Test: 103 Early Hints before the final response
+	var buf bytes.Buffer
+	w := NewConnWriter(&buf)
+	hints := headers.NewHeaders()
+	hints.Set("Link", "</style.css>; rel=preload; as=style")
+	require.NoError(t, w.WriteInterim(StatusEarlyHints, hints))
+	require.NoError(t, w.WriteInterim(StatusContinue, nil))
+	require.NoError(t, Error(w, StatusOK, "ok"))
+	assert.Equal(t, "HTTP/1.1 103 Early Hints\r\nlink: </style.css>; rel=preload; as=style\r\n\r\n"+
+		"HTTP/1.1 100 Continue\r\n\r\n"+
+		"HTTP/1.1 200 OK\r\ncontent-length: 2\r\ncontent-type: text/plain\r\n\r\nok", buf.String())
+
+	// Test: Interim after the final status line
+	assert.ErrorIs(t, w.WriteInterim(StatusEarlyHints, nil), ErrWriteOrder)
+
+	// Test: Non-1xx and 101 are not interim
+	w = NewConnWriter(&buf)
+	require.Error(t, w.WriteInterim(StatusOK, nil))
+	require.Error(t, w.WriteInterim(StatusSwitchingProtocols, nil))
+	require.Error(t, w.WriteStatusLine(StatusContinue))
+}
+
+func TestSuppressBody(t *testing.T) {
+	// Test: HEAD response keeps headers and drops the body
+	var buf bytes.Buffer
+	w := NewConnWriter(&buf)
+	w.SuppressBody()
+	require.NoError(t, Error(w, StatusOK, "hello"))
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ncontent-length: 5\r\ncontent-type: text/plain\r\n\r\n", buf.String())
+	assert.True(t, w.KeepAlive())
+
+	// Test: Body longer than Content-Length is still rejected
+	buf.Reset()
+	w = NewConnWriter(&buf)
+	w.SuppressBody()
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(2)))
+	_, err := w.WriteBody([]byte("too long"))
+	assert.ErrorIs(t, err, ErrBodyTooLong)
+
+	// Test: Unwritten body does not close the connection
+	require.NoError(t, w.Finish())
+	assert.True(t, w.KeepAlive())
+
+	// Test: Chunked body and trailers are dropped
+	buf.Reset()
+	w = NewConnWriter(&buf)
+	w.SuppressBody()
+	h := headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Trailer", "X-Checksum")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(h))
+	n, err := w.WriteChunkedBody([]byte("data"))
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(headers.Headers{"x-checksum": "abc"}))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ntrailer: X-Checksum\r\ntransfer-encoding: chunked\r\n\r\n", buf.String())
+
+	// Test: Close-delimited body does not force a close
+	w = NewConnWriter(&buf)
+	w.SuppressBody()
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	assert.True(t, w.KeepAlive())
+}
+
+func TestHijack(t *testing.T) {
+	// Test: Writers without a connection cannot be hijacked
+	var buf bytes.Buffer
+	_, _, err := NewConnWriter(&buf).Hijack()
+	assert.ErrorIs(t, err, ErrNotHijackable)
+
+	// Test: Hijack hands over buffered input and ends the response
+	client, srv := net.Pipe()
+	defer client.Close()
+	defer srv.Close()
+	br := bufio.NewReader(strings.NewReader("already read"))
+	_, _ = br.Peek(1)
+	w := NewServerConnWriter(srv, br)
+	conn, rw, err := w.Hijack()
+	require.NoError(t, err)
+	assert.Equal(t, srv, conn)
+	assert.True(t, w.Hijacked())
+	rest, err := io.ReadAll(rw)
+	require.NoError(t, err)
+	assert.Equal(t, "already read", string(rest))
+
+	// Test: The writer refuses further use
+	assert.ErrorIs(t, w.WriteStatusLine(StatusOK), ErrHijacked)
+	_, err = w.WriteBody([]byte("x"))
+	assert.ErrorIs(t, err, ErrHijacked)
+	_, _, err = w.Hijack()
+	assert.ErrorIs(t, err, ErrHijacked)
+	require.NoError(t, w.Finish())
+	assert.False(t, w.KeepAlive())
+}
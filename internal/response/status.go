@@ -0,0 +1,82 @@
+package response
+
+type StatusCode int
+
+const (
+	StatusContinue           StatusCode = 100
+	StatusSwitchingProtocols StatusCode = 101
+	StatusEarlyHints         StatusCode = 103
+
+	StatusOK             StatusCode = 200
+	StatusCreated        StatusCode = 201
+	StatusNoContent      StatusCode = 204
+	StatusPartialContent StatusCode = 206
+
+	StatusMovedPermanently StatusCode = 301
+	StatusFound            StatusCode = 302
+	StatusNotModified      StatusCode = 304
+
+	StatusBadRequest                   StatusCode = 400
+	StatusForbidden                    StatusCode = 403
+	StatusNotFound                     StatusCode = 404
+	StatusMethodNotAllowed             StatusCode = 405
+	StatusRequestTimeout               StatusCode = 408
+	StatusLengthRequired               StatusCode = 411
+	StatusPreconditionFailed           StatusCode = 412
+	StatusRequestEntityTooLarge        StatusCode = 413
+	StatusUnsupportedMediaType         StatusCode = 415
+	StatusRequestedRangeNotSatisfiable StatusCode = 416
+	StatusExpectationFailed            StatusCode = 417
+	StatusUpgradeRequired              StatusCode = 426
+	StatusTooManyRequests              StatusCode = 429
+	StatusRequestHeaderFieldsTooLarge  StatusCode = 431
+
+	StatusInternalServerError     StatusCode = 500
+	StatusNotImplemented          StatusCode = 501
+	StatusBadGateway              StatusCode = 502
+	StatusServiceUnavailable      StatusCode = 503
+	StatusGatewayTimeout          StatusCode = 504
+	StatusHTTPVersionNotSupported StatusCode = 505
+)
+
+var statusText = map[StatusCode]string{
+	StatusContinue:           "Continue",
+	StatusSwitchingProtocols: "Switching Protocols",
+	StatusEarlyHints:         "Early Hints",
+
+	StatusOK:             "OK",
+	StatusCreated:        "Created",
+	StatusNoContent:      "No Content",
+	StatusPartialContent: "Partial Content",
+
+	StatusMovedPermanently: "Moved Permanently",
+	StatusFound:            "Found",
+	StatusNotModified:      "Not Modified",
+
+	StatusBadRequest:                   "Bad Request",
+	StatusForbidden:                    "Forbidden",
+	StatusNotFound:                     "Not Found",
+	StatusMethodNotAllowed:             "Method Not Allowed",
+	StatusRequestTimeout:               "Request Timeout",
+	StatusLengthRequired:               "Length Required",
+	StatusPreconditionFailed:           "Precondition Failed",
+	StatusRequestEntityTooLarge:        "Content Too Large",
+	StatusUnsupportedMediaType:         "Unsupported Media Type",
+	StatusRequestedRangeNotSatisfiable: "Range Not Satisfiable",
+	StatusExpectationFailed:            "Expectation Failed",
+	StatusUpgradeRequired:              "Upgrade Required",
+	StatusTooManyRequests:              "Too Many Requests",
+	StatusRequestHeaderFieldsTooLarge:  "Request Header Fields Too Large",
+
+	StatusInternalServerError:     "Internal Server Error",
+	StatusNotImplemented:          "Not Implemented",
+	StatusBadGateway:              "Bad Gateway",
+	StatusServiceUnavailable:      "Service Unavailable",
+	StatusGatewayTimeout:          "Gateway Timeout",
+	StatusHTTPVersionNotSupported: "HTTP Version Not Supported",
+}
+
+// StatusText returns the reason phrase for code, or "" if it is unknown.
+func StatusText(code StatusCode) string {
+	return statusText[code]
+}
@@ -0,0 +1,1103 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"main/internal/headers"
+	"main/internal/hpack"
+	"main/internal/http2"
+	"main/internal/request"
+	"main/internal/response"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	h2MaxConcurrentStreams = 100
+	// h2StreamWindow is how much request body each stream may have
+	// buffered before its handler reads it.
+	h2StreamWindow = 1 << 17
+	// h2ConnWindow is the receive window of the whole connection.
+	h2ConnWindow = 1 << 20
+	// h2MaxHeaderBlock caps a header block across its CONTINUATION
+	// frames, and every field in it.
+	h2MaxHeaderBlock = 64 << 10
+	// h2MaxHeaderList is the SETTINGS_MAX_HEADER_LIST_SIZE advertised to
+	// clients. Requests over it are answered with a 431.
+	h2MaxHeaderList = 64 << 10
+	// h2MaxEncoderTable caps the dynamic table used for response headers,
+	// whatever the client allows.
+	h2MaxEncoderTable = 4096
+)
+
+var (
+	errStreamReset = errors.New("http2: stream reset")
+	errConnClosed  = errors.New("http2: connection closed")
+)
+
+// WithHTTP2 enables HTTP/2: offered through ALPN by ServeTLS, and on
+// plaintext connections accepted with prior knowledge or through an
+// Upgrade: h2c request. Each stream is dispatched to the same Handler as
+// HTTP/1.1 requests.
+func WithHTTP2() Option {
+	return func(s *Server) {
+		s.http2 = true
+	}
+}
+
+// hasH2Preface reports whether the client opened with the HTTP/2
+// connection preface, without consuming anything. HTTP/1.1 requests
+// differ from it within their first few bytes.
+func hasH2Preface(br *bufio.Reader) bool {
+	for n := 1; n <= len(http2.ClientPreface); n++ {
+		p, err := br.Peek(n)
+		if err != nil || p[n-1] != http2.ClientPreface[n-1] {
+			return false
+		}
+	}
+	return true
+}
+
+// h2cUpgrade returns the settings of a request asking to upgrade to
+// HTTP/2 (RFC 7540 3.2), and reports whether it is one. Requests with a
+// body are served over HTTP/1.1.
+func h2cUpgrade(req *request.Request) ([]http2.Setting, bool) {
+	h := req.Headers
+	if !h.HasToken("Upgrade", "h2c") || !h.HasToken("Connection", "Upgrade") ||
+		!h.HasToken("Connection", "HTTP2-Settings") || req.Body != request.NoBody {
+		return nil, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(h.Get("HTTP2-Settings"), "="))
+	if err != nil {
+		return nil, false
+	}
+	settings, err := http2.ParseSettings(payload)
+	if err != nil {
+		return nil, false
+	}
+	for _, s := range settings {
+		if s.Valid() != nil {
+			return nil, false
+		}
+	}
+	return settings, true
+}
+
+// h2Conn is a server-side HTTP/2 connection. A single goroutine reads
+// frames; each stream's handler runs in its own goroutine and writes its
+// frames directly.
+type h2Conn struct {
+	s      *Server
+	conn   net.Conn
+	tls    *tls.ConnectionState
+	framer *http2.Framer
+	dec    *hpack.Decoder
+
+	// wmu serializes frame writes. It also guards the encoder, whose
+	// state must follow the order header blocks are written in.
+	wmu      sync.Mutex
+	bw       *bufio.Writer
+	enc      *hpack.Encoder
+	hbuf     []byte
+	writeErr error
+
+	// mu guards the stream table and all flow-control state; cond is
+	// broadcast whenever a window grows, body data arrives or a stream
+	// or the connection ends.
+	mu            sync.Mutex
+	cond          *sync.Cond
+	streams       map[uint32]*h2Stream
+	lastStream    uint32
+	sendWindow    int64
+	initialWindow int64
+	maxFrameSize  uint32
+	closed        bool
+
+	// The rest is only used by the reading goroutine.
+	recvWindow   int64
+	recvUnacked  int64
+	headerStream uint32
+	headerFlags  http2.Flags
+	headerBlock  []byte
+
+	handlers sync.WaitGroup
+}
+
+// h2Stream is guarded by h2Conn.mu.
+type h2Stream struct {
+	id         uint32
+	sendWindow int64
+	recvWindow int64
+	// remoteClosed is set once the client can send nothing more, after
+	// END_STREAM or a reset.
+	remoteClosed bool
+	reset        bool
+	body         *h2Body
+	// contentLength is the declared request body length, or -1.
+	contentLength int64
+	received      int64
+	// cancel ends the request's context.
+	cancel context.CancelFunc
+}
+
+// serveHTTP2 runs an HTTP/2 connection until the client goes away or a
+// connection error occurs. For an h2c upgrade, upgrade is the request
+// that asked for it, answered as stream 1, and settings are the ones it
+// carried.
+func (s *Server) serveHTTP2(conn net.Conn, br *bufio.Reader, tlsState *tls.ConnectionState, upgrade *request.Request, settings []http2.Setting) {
+	c := &h2Conn{
+		s:             s,
+		conn:          conn,
+		tls:           tlsState,
+		bw:            bufio.NewWriterSize(conn, 8192),
+		enc:           hpack.NewEncoder(hpack.DefaultHeaderTableSize),
+		dec:           hpack.NewDecoder(hpack.DefaultHeaderTableSize, h2MaxHeaderBlock),
+		streams:       make(map[uint32]*h2Stream),
+		sendWindow:    http2.DefaultWindowSize,
+		initialWindow: http2.DefaultWindowSize,
+		maxFrameSize:  http2.DefaultMaxFrameSize,
+		recvWindow:    h2ConnWindow,
+	}
+	c.dec.SetMaxHeaderListSize(h2MaxHeaderList)
+	c.cond = sync.NewCond(&c.mu)
+	c.framer = http2.NewFramer(c.bw, br)
+	defer c.shutdown()
+
+	err := c.writeFrame(func(f *http2.Framer) error {
+		err := f.WriteSettings(
+			http2.Setting{ID: http2.SettingMaxConcurrentStreams, Value: h2MaxConcurrentStreams},
+			http2.Setting{ID: http2.SettingInitialWindowSize, Value: h2StreamWindow},
+			http2.Setting{ID: http2.SettingMaxHeaderListSize, Value: h2MaxHeaderList},
+		)
+		if err != nil {
+			return err
+		}
+		return f.WriteWindowUpdate(0, h2ConnWindow-http2.DefaultWindowSize)
+	})
+	if err != nil {
+		return
+	}
+	if upgrade != nil {
+		if err := c.applySettings(settings); err != nil {
+			return
+		}
+		for _, name := range []string{"Connection", "Upgrade", "HTTP2-Settings"} {
+			upgrade.Headers.Delete(name)
+		}
+		st := c.newStream(1, -1)
+		st.remoteClosed = true
+		c.lastStream = 1
+		c.startStream(st, upgrade)
+	}
+
+	preface := make([]byte, len(http2.ClientPreface))
+	if _, err := io.ReadFull(br, preface); err != nil || string(preface) != http2.ClientPreface {
+		s.logger.Debug("missing HTTP/2 client preface", "remote", conn.RemoteAddr())
+		return
+	}
+
+	err = c.readFrames()
+	var connErr http2.ConnError
+	if errors.As(err, &connErr) {
+		s.logger.Debug("HTTP/2 connection error", "remote", conn.RemoteAddr(), "error", err)
+		c.mu.Lock()
+		last := c.lastStream
+		c.mu.Unlock()
+		_ = c.writeFrame(func(f *http2.Framer) error {
+			return f.WriteGoAway(last, connErr.Code, []byte(connErr.Reason))
+		})
+	}
+}
+
+// shutdown fails every stream still waiting on the client, closes the
+// connection and waits for the handlers to return.
+func (c *h2Conn) shutdown() {
+	c.mu.Lock()
+	c.closed = true
+	for _, st := range c.streams {
+		if st.body != nil && st.body.err == nil {
+			st.body.err = errConnClosed
+		}
+		st.cancel()
+	}
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	c.conn.Close()
+	c.handlers.Wait()
+}
+
+func (c *h2Conn) readFrames() error {
+	first := true
+	for {
+		fr, err := c.framer.ReadFrame()
+		if err == nil && first && (fr.Type != http2.FrameSettings || fr.Flags.Has(http2.FlagAck)) {
+			err = http2.ConnError{Code: http2.ErrCodeProtocol, Reason: "first frame is not SETTINGS"}
+		}
+		first = false
+		if err == nil {
+			err = c.processFrame(fr)
+		}
+		var streamErr http2.StreamError
+		if errors.As(err, &streamErr) {
+			c.resetStream(streamErr.StreamID, streamErr.Code)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (c *h2Conn) processFrame(fr *http2.Frame) error {
+	if c.headerStream != 0 && fr.Type != http2.FrameContinuation {
+		return http2.ConnError{Code: http2.ErrCodeProtocol, Reason: fmt.Sprintf("%v frame inside a header block", fr.Type)}
+	}
+	switch fr.Type {
+	case http2.FrameData:
+		return c.processData(fr)
+	case http2.FrameHeaders:
+		return c.processHeaders(fr)
+	case http2.FrameContinuation:
+		return c.processContinuation(fr)
+	case http2.FrameRSTStream:
+		return c.processRSTStream(fr)
+	case http2.FrameSettings:
+		if fr.Flags.Has(http2.FlagAck) {
+			return nil
+		}
+		if err := c.applySettings(fr.Settings()); err != nil {
+			return err
+		}
+		return c.writeFrame(func(f *http2.Framer) error { return f.WriteSettingsAck() })
+	case http2.FramePing:
+		if fr.Flags.Has(http2.FlagAck) {
+			return nil
+		}
+		data := [8]byte(fr.Payload)
+		return c.writeFrame(func(f *http2.Framer) error { return f.WritePing(true, data) })
+	case http2.FrameWindowUpdate:
+		return c.processWindowUpdate(fr)
+	case http2.FramePushPromise:
+		return http2.ConnError{Code: http2.ErrCodeProtocol, Reason: "PUSH_PROMISE from client"}
+	default:
+		// PRIORITY and GOAWAY need no action: streams are served in
+		// arrival order, and a client going away simply closes the
+		// connection once its streams are done. Unknown frame types
+		// are ignored (RFC 9113 4.1).
+		return nil
+	}
+}
+
+func (c *h2Conn) applySettings(settings []http2.Setting) error {
+	for _, s := range settings {
+		if err := s.Valid(); err != nil {
+			return err
+		}
+		switch s.ID {
+		case http2.SettingHeaderTableSize:
+			c.wmu.Lock()
+			c.enc.SetMaxTableSize(min(s.Value, h2MaxEncoderTable))
+			c.wmu.Unlock()
+		case http2.SettingInitialWindowSize:
+			c.mu.Lock()
+			delta := int64(s.Value) - c.initialWindow
+			c.initialWindow = int64(s.Value)
+			for _, st := range c.streams {
+				st.sendWindow += delta
+				if st.sendWindow > http2.MaxWindowSize {
+					c.mu.Unlock()
+					return http2.ConnError{Code: http2.ErrCodeFlowControl, Reason: "stream window overflow"}
+				}
+			}
+			c.cond.Broadcast()
+			c.mu.Unlock()
+		case http2.SettingMaxFrameSize:
+			c.mu.Lock()
+			c.maxFrameSize = s.Value
+			c.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+func (c *h2Conn) processHeaders(fr *http2.Frame) error {
+	if fr.StreamID%2 == 0 {
+		return http2.ConnError{Code: http2.ErrCodeProtocol, Reason: "even stream ID from client"}
+	}
+	block, err := fr.HeaderBlock()
+	if err != nil {
+		return err
+	}
+	if !fr.Flags.Has(http2.FlagEndHeaders) {
+		c.headerStream = fr.StreamID
+		c.headerFlags = fr.Flags
+		c.headerBlock = append(c.headerBlock[:0], block...)
+		return nil
+	}
+	return c.processHeaderBlock(fr.StreamID, fr.Flags.Has(http2.FlagEndStream), block)
+}
+
+func (c *h2Conn) processContinuation(fr *http2.Frame) error {
+	if c.headerStream == 0 || fr.StreamID != c.headerStream {
+		return http2.ConnError{Code: http2.ErrCodeProtocol, Reason: "unexpected CONTINUATION frame"}
+	}
+	c.headerBlock = append(c.headerBlock, fr.Payload...)
+	if len(c.headerBlock) > h2MaxHeaderBlock {
+		return http2.ConnError{Code: http2.ErrCodeEnhanceYourCalm, Reason: "header block too large"}
+	}
+	if !fr.Flags.Has(http2.FlagEndHeaders) {
+		return nil
+	}
+	id := c.headerStream
+	c.headerStream = 0
+	return c.processHeaderBlock(id, c.headerFlags.Has(http2.FlagEndStream), c.headerBlock)
+}
+
+// processHeaderBlock handles a complete header block, which either opens
+// a stream or carries the trailers of its request body.
+func (c *h2Conn) processHeaderBlock(id uint32, endStream bool, block []byte) error {
+	// Every block is decoded, even on streams about to be refused, to
+	// keep the decoder in step with the client's encoder.
+	fields, err := c.dec.Decode(block)
+	tooLarge := errors.Is(err, hpack.ErrHeaderListTooLarge)
+	if err != nil && !tooLarge {
+		return http2.ConnError{Code: http2.ErrCodeCompression, Reason: err.Error()}
+	}
+
+	c.mu.Lock()
+	st := c.streams[id]
+	active := len(c.streams)
+	lastStream := c.lastStream
+	c.mu.Unlock()
+	if st != nil {
+		if tooLarge {
+			return http2.StreamError{StreamID: id, Code: http2.ErrCodeEnhanceYourCalm, Reason: "trailers too large"}
+		}
+		return c.processTrailers(st, endStream, fields)
+	}
+	if id <= lastStream {
+		return http2.ConnError{Code: http2.ErrCodeStreamClosed, Reason: fmt.Sprintf("HEADERS on closed stream %d", id)}
+	}
+	c.mu.Lock()
+	c.lastStream = id
+	c.mu.Unlock()
+	if active >= h2MaxConcurrentStreams {
+		return http2.StreamError{StreamID: id, Code: http2.ErrCodeRefusedStream, Reason: "too many streams"}
+	}
+
+	var line request.RequestLine
+	h := headers.NewHeaders()
+	if !tooLarge {
+		if line, h, err = requestHead(fields); err != nil {
+			return http2.StreamError{StreamID: id, Code: http2.ErrCodeProtocol, Reason: err.Error()}
+		}
+	}
+	contentLength := int64(-1)
+	if v := h.Get("Content-Length"); v != "" {
+		if contentLength, err = strconv.ParseInt(v, 10, 64); err != nil || contentLength < 0 {
+			return http2.StreamError{StreamID: id, Code: http2.ErrCodeProtocol, Reason: "invalid content-length"}
+		}
+	}
+	if endStream && contentLength > 0 {
+		return http2.StreamError{StreamID: id, Code: http2.ErrCodeProtocol, Reason: "missing request body"}
+	}
+
+	st = c.newStream(id, contentLength)
+	var body io.ReadCloser = request.NoBody
+	trailers := headers.NewHeaders()
+	if endStream {
+		st.remoteClosed = true
+	} else {
+		st.body = &h2Body{c: c, st: st, trailers: trailers}
+		body = st.body
+	}
+	var req *request.Request
+	if tooLarge {
+		err = request.ErrHeaderTooLarge
+	} else {
+		req, err = request.NewRequest(line, h, body, c.s.reqOpts...)
+	}
+	if err != nil {
+		// Answered like a request the HTTP/1.1 parser rejects.
+		status := parseErrorStatus(err)
+		c.startStream(st, &request.Request{RequestLine: line, Headers: h, Body: body}, func(w response.Writer, _ *request.Request) {
+			_ = response.Error(w, status, err.Error()+"\n")
+		})
+		return nil
+	}
+	if st.body != nil {
+		req.Trailers = trailers
+	}
+	c.startStream(st, req)
+	return nil
+}
+
+// newStream registers a stream with the current initial windows.
+func (c *h2Conn) newStream(id uint32, contentLength int64) *h2Stream {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := &h2Stream{
+		id:            id,
+		sendWindow:    c.initialWindow,
+		recvWindow:    h2StreamWindow,
+		contentLength: contentLength,
+		cancel:        func() {},
+	}
+	c.streams[id] = st
+	return st
+}
+
+// connectionFields may not appear in HTTP/2 messages (RFC 9113 8.2.2).
+var connectionFields = []string{"connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade"}
+
+// requestHead turns the fields of a request header block into a request
+// line and headers, rejecting malformed requests (RFC 9113 8.3.1).
+func requestHead(fields []hpack.HeaderField) (request.RequestLine, headers.Headers, error) {
+	var line request.RequestLine
+	pseudo := make(map[string]string)
+	var regular []hpack.HeaderField
+	for _, f := range fields {
+		if strings.HasPrefix(f.Name, ":") {
+			if len(regular) > 0 {
+				return line, nil, fmt.Errorf("pseudo-header %s after regular fields", f.Name)
+			}
+			switch f.Name {
+			case ":method", ":scheme", ":path", ":authority":
+			default:
+				return line, nil, fmt.Errorf("unknown pseudo-header %s", f.Name)
+			}
+			if _, dup := pseudo[f.Name]; dup {
+				return line, nil, fmt.Errorf("duplicate pseudo-header %s", f.Name)
+			}
+			pseudo[f.Name] = f.Value
+			continue
+		}
+		if err := checkField(f); err != nil {
+			return line, nil, err
+		}
+		if f.Name == "te" && f.Value != "trailers" {
+			return line, nil, errors.New("te other than trailers")
+		}
+		regular = append(regular, f)
+	}
+	h := headers.NewHeaders()
+	hpack.AddFields(h, regular)
+
+	method, authority := pseudo[":method"], pseudo[":authority"]
+	if method == "CONNECT" {
+		if authority == "" || pseudo[":scheme"] != "" || pseudo[":path"] != "" {
+			return line, nil, errors.New("malformed CONNECT request")
+		}
+		line.RequestTarget = authority
+	} else {
+		if !headers.IsToken(method) || pseudo[":scheme"] == "" || pseudo[":path"] == "" {
+			return line, nil, errors.New("missing or invalid pseudo-headers")
+		}
+		line.RequestTarget = pseudo[":path"]
+	}
+	line.Method = method
+	line.HttpVersion = "2"
+	if authority != "" && h.Get("Host") == "" {
+		h.Set("Host", authority)
+	}
+	return line, h, nil
+}
+
+// checkField rejects field names that are not lowercase tokens, values
+// with line breaks, and connection-specific fields.
+func checkField(f hpack.HeaderField) error {
+	if !headers.IsToken(f.Name) || strings.ToLower(f.Name) != f.Name {
+		return fmt.Errorf("invalid field name %q", f.Name)
+	}
+	if strings.ContainsAny(f.Value, "\r\n\x00") {
+		return fmt.Errorf("invalid value for %s", f.Name)
+	}
+	for _, name := range connectionFields {
+		if f.Name == name {
+			return fmt.Errorf("connection-specific field %s", f.Name)
+		}
+	}
+	return nil
+}
+
+func (c *h2Conn) processTrailers(st *h2Stream, endStream bool, fields []hpack.HeaderField) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if st.remoteClosed {
+		return http2.StreamError{StreamID: st.id, Code: http2.ErrCodeStreamClosed, Reason: "HEADERS after END_STREAM"}
+	}
+	if !endStream {
+		return http2.StreamError{StreamID: st.id, Code: http2.ErrCodeProtocol, Reason: "trailers without END_STREAM"}
+	}
+	for _, f := range fields {
+		if strings.HasPrefix(f.Name, ":") || checkField(f) != nil {
+			return http2.StreamError{StreamID: st.id, Code: http2.ErrCodeProtocol, Reason: "invalid trailer field"}
+		}
+	}
+	hpack.AddFields(st.body.trailers, fields)
+	return c.endRequestBody(st)
+}
+
+// endRequestBody handles END_STREAM on a stream with a body. c.mu must be
+// held.
+func (c *h2Conn) endRequestBody(st *h2Stream) error {
+	st.remoteClosed = true
+	if st.contentLength >= 0 && st.received != st.contentLength {
+		return http2.StreamError{StreamID: st.id, Code: http2.ErrCodeProtocol, Reason: "body shorter than content-length"}
+	}
+	if st.body.err == nil {
+		st.body.err = io.EOF
+	}
+	c.cond.Broadcast()
+	return nil
+}
+
+func (c *h2Conn) processData(fr *http2.Frame) error {
+	data, err := fr.DataPayload()
+	if err != nil {
+		return err
+	}
+	n := int64(len(fr.Payload))
+	if n > c.recvWindow {
+		return http2.ConnError{Code: http2.ErrCodeFlowControl, Reason: "connection window exceeded"}
+	}
+	c.recvWindow -= n
+	// Buffered data is bounded by the stream windows, so the connection
+	// window is given back right away.
+	if err := c.creditConn(n); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := c.streams[fr.StreamID]
+	if st == nil || st.remoteClosed {
+		if fr.StreamID > c.lastStream {
+			return http2.ConnError{Code: http2.ErrCodeProtocol, Reason: "DATA on idle stream"}
+		}
+		return http2.StreamError{StreamID: fr.StreamID, Code: http2.ErrCodeStreamClosed, Reason: "DATA on closed stream"}
+	}
+	if n > st.recvWindow {
+		return http2.StreamError{StreamID: st.id, Code: http2.ErrCodeFlowControl, Reason: "stream window exceeded"}
+	}
+	st.recvWindow -= n
+	st.received += int64(len(data))
+	if st.contentLength >= 0 && st.received > st.contentLength {
+		return http2.StreamError{StreamID: st.id, Code: http2.ErrCodeProtocol, Reason: "body longer than content-length"}
+	}
+
+	b := st.body
+	// Padding, and data the handler no longer wants, count as consumed.
+	b.unacked += n - int64(len(data))
+	if b.closed {
+		b.unacked += int64(len(data))
+	} else {
+		b.buf = append(b.buf, data...)
+	}
+	c.cond.Broadcast()
+	if fr.Flags.Has(http2.FlagEndStream) {
+		return c.endRequestBody(st)
+	}
+	return nil
+}
+
+// creditConn returns n bytes to the connection receive window, sending a
+// WINDOW_UPDATE once half the window is used up.
+func (c *h2Conn) creditConn(n int64) error {
+	c.recvUnacked += n
+	if c.recvUnacked < h2ConnWindow/2 {
+		return nil
+	}
+	increment := c.recvUnacked
+	c.recvWindow += increment
+	c.recvUnacked = 0
+	return c.writeFrame(func(f *http2.Framer) error { return f.WriteWindowUpdate(0, uint32(increment)) })
+}
+
+func (c *h2Conn) processRSTStream(fr *http2.Frame) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if fr.StreamID > c.lastStream {
+		return http2.ConnError{Code: http2.ErrCodeProtocol, Reason: "RST_STREAM on idle stream"}
+	}
+	if st := c.streams[fr.StreamID]; st != nil {
+		c.markReset(st)
+	}
+	return nil
+}
+
+// markReset ends st in both directions. c.mu must be held.
+func (c *h2Conn) markReset(st *h2Stream) {
+	st.reset = true
+	st.remoteClosed = true
+	if st.body != nil && st.body.err == nil {
+		st.body.err = errStreamReset
+	}
+	st.cancel()
+	c.cond.Broadcast()
+}
+
+// resetStream sends RST_STREAM for id, unless the stream was already
+// reset.
+func (c *h2Conn) resetStream(id uint32, code http2.ErrCode) {
+	c.mu.Lock()
+	st := c.streams[id]
+	if st != nil && st.reset {
+		c.mu.Unlock()
+		return
+	}
+	if st != nil {
+		c.markReset(st)
+	}
+	c.mu.Unlock()
+	_ = c.writeFrame(func(f *http2.Framer) error { return f.WriteRSTStream(id, code) })
+}
+
+func (c *h2Conn) processWindowUpdate(fr *http2.Frame) error {
+	increment := int64(fr.WindowIncrement())
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if fr.StreamID == 0 {
+		if increment == 0 {
+			return http2.ConnError{Code: http2.ErrCodeProtocol, Reason: "zero window increment"}
+		}
+		c.sendWindow += increment
+		if c.sendWindow > http2.MaxWindowSize {
+			return http2.ConnError{Code: http2.ErrCodeFlowControl, Reason: "connection window overflow"}
+		}
+		c.cond.Broadcast()
+		return nil
+	}
+
+	st := c.streams[fr.StreamID]
+	if st == nil {
+		if fr.StreamID > c.lastStream {
+			return http2.ConnError{Code: http2.ErrCodeProtocol, Reason: "WINDOW_UPDATE on idle stream"}
+		}
+		return nil
+	}
+	if increment == 0 {
+		return http2.StreamError{StreamID: st.id, Code: http2.ErrCodeProtocol, Reason: "zero window increment"}
+	}
+	st.sendWindow += increment
+	if st.sendWindow > http2.MaxWindowSize {
+		return http2.StreamError{StreamID: st.id, Code: http2.ErrCodeFlowControl, Reason: "stream window overflow"}
+	}
+	c.cond.Broadcast()
+	return nil
+}
+
+// writeFrame runs write with exclusive use of the framer and flushes.
+// After a write error every later write fails with it.
+func (c *h2Conn) writeFrame(write func(f *http2.Framer) error) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.writeErr != nil {
+		return c.writeErr
+	}
+	err := write(c.framer)
+	if err == nil {
+		err = c.bw.Flush()
+	}
+	c.writeErr = err
+	return err
+}
+
+func (c *h2Conn) writeHeaders(st *h2Stream, fields []hpack.HeaderField, endStream bool) error {
+	c.mu.Lock()
+	maxFrameSize, reset := c.maxFrameSize, st.reset
+	c.mu.Unlock()
+	if reset {
+		return errStreamReset
+	}
+	return c.writeFrame(func(f *http2.Framer) error {
+		c.hbuf = c.enc.AppendBlock(c.hbuf[:0], fields...)
+		return f.WriteHeaders(st.id, endStream, c.hbuf, maxFrameSize)
+	})
+}
+
+// writeData sends p as DATA frames as the send windows allow, ending the
+// stream with the last one if end is set.
+func (c *h2Conn) writeData(st *h2Stream, p []byte, end bool) error {
+	for {
+		c.mu.Lock()
+		for len(p) > 0 && !st.reset && !c.closed && (st.sendWindow <= 0 || c.sendWindow <= 0) {
+			c.cond.Wait()
+		}
+		if st.reset {
+			c.mu.Unlock()
+			return errStreamReset
+		}
+		if c.closed {
+			c.mu.Unlock()
+			return errConnClosed
+		}
+		n := min(int64(len(p)), int64(c.maxFrameSize), st.sendWindow, c.sendWindow)
+		st.sendWindow -= n
+		c.sendWindow -= n
+		c.mu.Unlock()
+
+		chunk := p[:n]
+		p = p[n:]
+		last := end && len(p) == 0
+		err := c.writeFrame(func(f *http2.Framer) error { return f.WriteData(st.id, last, chunk) })
+		if err != nil || len(p) == 0 {
+			return err
+		}
+	}
+}
+
+// startStream runs handler, or the server's handler, for req on st.
+func (c *h2Conn) startStream(st *h2Stream, req *request.Request, handler ...Handler) {
+	h := c.s.handler
+	if len(handler) > 0 {
+		h = handler[0]
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c.mu.Lock()
+	st.cancel = cancel
+	c.mu.Unlock()
+	req = req.WithContext(ctx)
+	req.RemoteAddr = c.conn.RemoteAddr().String()
+	req.TLS = c.tls
+
+	w := &h2Writer{c: c, st: st, remaining: -1}
+	if req.RequestLine.Method == "HEAD" {
+		w.head = true
+	}
+	if req.ExpectsContinue() && req.Body != request.NoBody {
+		req.Body = &continueReader{ReadCloser: req.Body, w: w, pending: true}
+	}
+
+	c.handlers.Add(1)
+	go func() {
+		defer c.handlers.Done()
+		defer cancel()
+		ok := c.s.callHandler(h, w, req)
+		if ok {
+			if err := w.Finish(); err != nil {
+				ok = false
+			}
+		}
+		if !ok && !w.ended {
+			c.resetStream(st.id, http2.ErrCodeInternal)
+		}
+		_ = req.Body.Close()
+
+		c.mu.Lock()
+		delete(c.streams, st.id)
+		// The client may still be sending a body nobody will read.
+		stillSending := !st.remoteClosed
+		c.mu.Unlock()
+		if stillSending {
+			_ = c.writeFrame(func(f *http2.Framer) error { return f.WriteRSTStream(st.id, http2.ErrCodeNo) })
+		}
+	}()
+}
+
+// h2Body is a request body fed by DATA frames. Its fields are guarded by
+// h2Conn.mu.
+type h2Body struct {
+	c        *h2Conn
+	st       *h2Stream
+	buf      []byte
+	trailers headers.Headers
+	// err is returned once buf is drained: io.EOF after END_STREAM.
+	err    error
+	closed bool
+	// unacked is consumed data not yet given back to the stream window.
+	unacked int64
+}
+
+func (b *h2Body) Read(p []byte) (int, error) {
+	c := b.c
+	c.mu.Lock()
+	for len(b.buf) == 0 && b.err == nil && !b.closed {
+		c.cond.Wait()
+	}
+	if b.closed {
+		c.mu.Unlock()
+		return 0, request.ErrBodyClosed
+	}
+	if len(b.buf) == 0 {
+		err := b.err
+		c.mu.Unlock()
+		return 0, err
+	}
+	n := copy(p, b.buf)
+	b.buf = b.buf[n:]
+	if len(b.buf) == 0 {
+		b.buf = nil
+	}
+	b.unacked += int64(n)
+	var increment int64
+	if b.unacked >= h2StreamWindow/2 && !b.st.remoteClosed {
+		increment = b.unacked
+		b.st.recvWindow += increment
+		b.unacked = 0
+	}
+	c.mu.Unlock()
+
+	if increment > 0 {
+		_ = c.writeFrame(func(f *http2.Framer) error { return f.WriteWindowUpdate(b.st.id, uint32(increment)) })
+	}
+	return n, nil
+}
+
+// Close discards the rest of the body. Data still arriving is dropped.
+func (b *h2Body) Close() error {
+	b.c.mu.Lock()
+	defer b.c.mu.Unlock()
+	b.closed = true
+	b.buf = nil
+	b.c.cond.Broadcast()
+	return nil
+}
+
+type h2WriterState int
+
+const (
+	h2WritingStatus h2WriterState = iota
+	h2WritingHeaders
+	h2WritingBody
+	h2WritingTrailers
+	h2WritingDone
+)
+
+// h2Writer is the response.Writer of a stream. It follows the same call
+// order and framing checks as response.ConnWriter, mapping the status
+// line and headers to a HEADERS frame, the body to DATA frames and
+// trailers to a final HEADERS frame. Chunked framing has no HTTP/2
+// equivalent, so Transfer-Encoding is dropped and chunks become DATA.
+type h2Writer struct {
+	c     *h2Conn
+	st    *h2Stream
+	state h2WriterState
+
+	status    response.StatusCode
+	chunked   bool
+	trailers  bool
+	head      bool
+	remaining int64
+	// ended is set once END_STREAM has been sent.
+	ended bool
+}
+
+// responseFields converts headers to HTTP/2 fields, after the given
+// pseudo-header fields. Connection-specific fields are dropped, and
+// headers that Validate rejects are refused.
+func responseFields(h headers.Headers, pseudo ...hpack.HeaderField) ([]hpack.HeaderField, error) {
+	if err := h.Validate(); err != nil {
+		return nil, err
+	}
+	fields := pseudo
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		name := strings.ToLower(key)
+		if name == "te" || strings.HasPrefix(name, ":") || checkField(hpack.HeaderField{Name: name}) != nil {
+			continue
+		}
+		for _, v := range h.Values(key) {
+			fields = append(fields, hpack.HeaderField{Name: name, Value: v})
+		}
+	}
+	return fields, nil
+}
+
+func statusField(code response.StatusCode) hpack.HeaderField {
+	return hpack.HeaderField{Name: ":status", Value: strconv.Itoa(int(code))}
+}
+
+func (w *h2Writer) WriteInterim(code response.StatusCode, h headers.Headers) error {
+	if w.state != h2WritingStatus {
+		return response.ErrWriteOrder
+	}
+	if code < 100 || code > 199 || code == response.StatusSwitchingProtocols {
+		return fmt.Errorf("not an interim status code: %d", code)
+	}
+	fields, err := responseFields(h, statusField(code))
+	if err != nil {
+		return err
+	}
+	return w.c.writeHeaders(w.st, fields, false)
+}
+
+func (w *h2Writer) WriteStatusLine(code response.StatusCode) error {
+	if w.state != h2WritingStatus {
+		return response.ErrWriteOrder
+	}
+	if code < 200 || code > 999 {
+		// 101 Switching Protocols is forbidden in HTTP/2 too.
+		return fmt.Errorf("invalid final status code: %d", code)
+	}
+	w.status = code
+	w.state = h2WritingHeaders
+	return nil
+}
+
+func (w *h2Writer) hasBody() bool {
+	return w.status != response.StatusNoContent && w.status != response.StatusNotModified
+}
+
+func (w *h2Writer) WriteHeaders(h headers.Headers) error {
+	if w.state != h2WritingHeaders {
+		return response.ErrWriteOrder
+	}
+	w.chunked = strings.EqualFold(h.Get("Transfer-Encoding"), "chunked")
+	w.trailers = h.Get("Trailer") != ""
+	if v := h.Get("Content-Length"); v != "" && !w.chunked {
+		length, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || length < 0 {
+			return fmt.Errorf("invalid Content-Length: %q", v)
+		}
+		w.remaining = length
+	}
+
+	fields, err := responseFields(h, statusField(w.status))
+	if err != nil {
+		return err
+	}
+	end := !w.hasBody() || w.head || w.remaining == 0
+	if err := w.c.writeHeaders(w.st, fields, end); err != nil {
+		return err
+	}
+	w.ended = end
+	w.state = h2WritingBody
+	if !w.hasBody() {
+		w.state = h2WritingDone
+	}
+	return nil
+}
+
+func (w *h2Writer) WriteBody(p []byte) (int, error) {
+	if w.state != h2WritingBody || w.chunked {
+		return 0, response.ErrWriteOrder
+	}
+	if w.remaining >= 0 {
+		if int64(len(p)) > w.remaining {
+			return 0, response.ErrBodyTooLong
+		}
+		w.remaining -= int64(len(p))
+	}
+	if w.ended || len(p) == 0 && w.remaining != 0 {
+		return len(p), nil
+	}
+	end := w.remaining == 0
+	if err := w.c.writeData(w.st, p, end); err != nil {
+		return 0, err
+	}
+	w.ended = end
+	return len(p), nil
+}
+
+func (w *h2Writer) WriteChunkedBody(p []byte) (int, error) {
+	if w.state != h2WritingBody || !w.chunked {
+		return 0, response.ErrWriteOrder
+	}
+	if len(p) == 0 || w.ended {
+		return len(p), nil
+	}
+	if err := w.c.writeData(w.st, p, false); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// WriteChunkedBodyDone ends the body. If the headers announced a Trailer
+// field, WriteTrailers must follow and ends the stream instead.
+func (w *h2Writer) WriteChunkedBodyDone() (int, error) {
+	if w.state != h2WritingBody || !w.chunked {
+		return 0, response.ErrWriteOrder
+	}
+	if w.trailers {
+		w.state = h2WritingTrailers
+		return 0, nil
+	}
+	w.state = h2WritingDone
+	return 0, w.end()
+}
+
+func (w *h2Writer) WriteTrailers(h headers.Headers) error {
+	if w.state != h2WritingTrailers {
+		return response.ErrWriteOrder
+	}
+	fields, err := responseFields(h)
+	if err != nil {
+		return err
+	}
+	w.state = h2WritingDone
+	if w.ended || len(fields) == 0 {
+		return w.end()
+	}
+	if err := w.c.writeHeaders(w.st, fields, true); err != nil {
+		return err
+	}
+	w.ended = true
+	return nil
+}
+
+// end sends END_STREAM on an empty DATA frame, unless it was sent
+// already.
+func (w *h2Writer) end() error {
+	if w.ended {
+		return nil
+	}
+	if err := w.c.writeData(w.st, nil, true); err != nil {
+		return err
+	}
+	w.ended = true
+	return nil
+}
+
+// Started reports whether the final status has been written.
+func (w *h2Writer) Started() bool {
+	return w.state != h2WritingStatus
+}
+
+// Finish completes whatever the handler left unfinished, as
+// ConnWriter.Finish does. A body shorter than its Content-Length is an
+// error, which resets the stream.
+func (w *h2Writer) Finish() error {
+	switch w.state {
+	case h2WritingStatus:
+		if err := w.WriteStatusLine(response.StatusOK); err != nil {
+			return err
+		}
+		return w.WriteHeaders(response.GetDefaultHeaders(0))
+	case h2WritingHeaders:
+		if !w.hasBody() {
+			return w.WriteHeaders(headers.NewHeaders())
+		}
+		return w.WriteHeaders(response.GetDefaultHeaders(0))
+	case h2WritingBody:
+		if w.chunked {
+			if _, err := w.WriteChunkedBodyDone(); err != nil {
+				return err
+			}
+			if w.state == h2WritingTrailers {
+				return w.WriteTrailers(headers.NewHeaders())
+			}
+			return nil
+		}
+		w.state = h2WritingDone
+		if w.remaining > 0 && !w.ended {
+			return errors.New("response body shorter than Content-Length")
+		}
+		return w.end()
+	case h2WritingTrailers:
+		return w.WriteTrailers(headers.NewHeaders())
+	}
+	return nil
+}
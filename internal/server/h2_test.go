@@ -0,0 +1,465 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"main/internal/headers"
+	"main/internal/hpack"
+	"main/internal/http2"
+	"main/internal/request"
+	"main/internal/response"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// h2Handler echoes the request body and describes the request in headers.
+func h2Handler(w response.Writer, req *request.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		_ = response.Error(w, response.StatusBadRequest, err.Error())
+		return
+	}
+	h := response.GetDefaultHeaders(len(body))
+	h.Set("X-Version", req.RequestLine.HttpVersion)
+	h.Set("X-Host", req.Headers.Get("Host"))
+	h.Set("X-TLS", map[bool]string{true: "yes", false: "no"}[req.TLS != nil])
+	_ = w.WriteStatusLine(response.StatusOK)
+	_ = w.WriteHeaders(h)
+	_, _ = w.WriteBody(body)
+}
+
+// h2cClient returns a client speaking HTTP/2 over plain TCP with prior
+// knowledge.
+func h2cClient(t *testing.T) *http.Client {
+	t.Helper()
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	transport := &http.Transport{Protocols: protocols}
+	t.Cleanup(transport.CloseIdleConnections)
+	return &http.Client{Transport: transport, Timeout: 5 * time.Second}
+}
+
+func startH2CServer(t *testing.T, handler Handler, opts ...Option) string {
+	t.Helper()
+	s, err := Serve(0, handler, append([]Option{WithHTTP2()}, opts...)...)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s.Addr().(*net.TCPAddr).AddrPort().String()
+}
+
+// Test: ServeTLS negotiates h2 through ALPN and serves requests with
+// bodies larger than the flow-control windows, while HTTP/1.1 clients on
+// the same listener keep working.
+func TestServeHTTP2TLS(t *testing.T) {
+	ca := newTestCA(t)
+	certPEM, keyPEM := ca.issue(t, "a", "a.test")
+	certs, err := LoadCertificates(writePair(t, t.TempDir(), "a", certPEM, keyPEM))
+	require.NoError(t, err)
+	addr := startTLSServer(t, h2Handler, certs, WithHTTP2())
+
+	transport := &http.Transport{
+		TLSClientConfig:   &tls.Config{ServerName: "a.test", RootCAs: ca.pool},
+		ForceAttemptHTTP2: true,
+	}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport, Timeout: 5 * time.Second}
+
+	payload := bytes.Repeat([]byte("0123456789"), 50000)
+	resp, err := client.Post("https://"+addr+"/upload", "text/plain", bytes.NewReader(payload))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	got, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, 2, resp.ProtoMajor)
+	assert.Equal(t, "2", resp.Header.Get("X-Version"))
+	assert.Equal(t, "yes", resp.Header.Get("X-TLS"))
+	assert.Equal(t, addr, resp.Header.Get("X-Host"))
+	assert.Equal(t, payload, got)
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: "a.test", RootCAs: ca.pool, NextProtos: []string{"http/1.1"}})
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Contains(t, get(t, conn), "x-version: 1.1")
+}
+
+// Test: without WithHTTP2, ALPN offers nothing and the preface is just a
+// bad HTTP/1.1 request.
+func TestServeHTTP2Disabled(t *testing.T) {
+	conn := startServer(t, h2Handler)
+	_, err := io.WriteString(conn, http2.ClientPreface)
+	require.NoError(t, err)
+	status, _ := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, "HTTP/1.1 400 Bad Request", status)
+}
+
+// Test: with prior knowledge, concurrent streams share one connection and
+// chunked responses become DATA frames followed by trailers.
+func TestServeHTTP2PriorKnowledge(t *testing.T) {
+	addr := startH2CServer(t, func(w response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget != "/chunked" {
+			h2Handler(w, req)
+			return
+		}
+		h := headers.NewHeaders()
+		h.Set("Transfer-Encoding", "chunked")
+		h.Set("Trailer", "X-Sum")
+		_ = w.WriteStatusLine(response.StatusOK)
+		_ = w.WriteHeaders(h)
+		_, _ = w.WriteChunkedBody([]byte("hello "))
+		_, _ = w.WriteChunkedBody([]byte("world"))
+		_, _ = w.WriteChunkedBodyDone()
+		trailers := headers.NewHeaders()
+		trailers.Set("X-Sum", "42")
+		_ = w.WriteTrailers(trailers)
+	}, WithRequestOptions(request.WithMethods("HEAD")))
+	client := h2cClient(t)
+
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body := strings.Repeat("x", i*1000)
+			resp, err := client.Post("http://"+addr+"/echo", "text/plain", strings.NewReader(body))
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer resp.Body.Close()
+			got, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, 2, resp.ProtoMajor)
+			assert.Equal(t, "no", resp.Header.Get("X-TLS"))
+			assert.Equal(t, body, string(got))
+		}()
+	}
+	wg.Wait()
+
+	resp, err := client.Get("http://" + addr + "/chunked")
+	require.NoError(t, err)
+	got, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "hello world", string(got))
+	assert.Equal(t, "42", resp.Trailer.Get("X-Sum"))
+	assert.Empty(t, resp.Header.Get("Transfer-Encoding"))
+
+	resp, err = client.Head("http://" + addr + "/echo")
+	require.NoError(t, err)
+	got, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, got)
+}
+
+// Test: a handler panic becomes a 500, and ErrAbortHandler or a body
+// shorter than its Content-Length resets the stream.
+func TestServeHTTP2HandlerFailures(t *testing.T) {
+	addr := startH2CServer(t, func(w response.Writer, req *request.Request) {
+		switch req.RequestLine.RequestTarget {
+		case "/panic":
+			panic("boom")
+		case "/abort":
+			panic(ErrAbortHandler)
+		case "/short":
+			_ = w.WriteStatusLine(response.StatusOK)
+			_ = w.WriteHeaders(response.GetDefaultHeaders(10))
+			_, _ = w.WriteBody([]byte("abc"))
+		}
+	})
+	client := h2cClient(t)
+
+	resp, err := client.Get("http://" + addr + "/panic")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+
+	_, err = client.Get("http://" + addr + "/abort")
+	assert.ErrorContains(t, err, "INTERNAL_ERROR")
+
+	resp, err = client.Get("http://" + addr + "/short")
+	if err == nil {
+		_, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	assert.Error(t, err)
+}
+
+// Test: a request body arriving after the handler asked for it through
+// Expect: 100-continue.
+func TestServeHTTP2ExpectContinue(t *testing.T) {
+	addr := startH2CServer(t, h2Handler)
+	client := h2cClient(t)
+	client.Transport.(*http.Transport).ExpectContinueTimeout = 5 * time.Second
+
+	req, err := http.NewRequestWithContext(context.Background(), "POST", "http://"+addr+"/", strings.NewReader("late body"))
+	require.NoError(t, err)
+	req.Header.Set("Expect", "100-continue")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	got, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "late body", string(got))
+}
+
+// rawH2 is a bare HTTP/2 client for driving the server frame by frame.
+type rawH2 struct {
+	t      *testing.T
+	conn   net.Conn
+	framer *http2.Framer
+	enc    *hpack.Encoder
+	dec    *hpack.Decoder
+}
+
+func newRawH2(t *testing.T, conn net.Conn, br *bufio.Reader) *rawH2 {
+	t.Helper()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+	return &rawH2{
+		t:      t,
+		conn:   conn,
+		framer: http2.NewFramer(conn, br),
+		enc:    hpack.NewEncoder(hpack.DefaultHeaderTableSize),
+		dec:    hpack.NewDecoder(hpack.DefaultHeaderTableSize, 1<<16),
+	}
+}
+
+// handshake sends the preface and an empty SETTINGS frame.
+func (c *rawH2) handshake() {
+	c.t.Helper()
+	_, err := io.WriteString(c.conn, http2.ClientPreface)
+	require.NoError(c.t, err)
+	require.NoError(c.t, c.framer.WriteSettings())
+}
+
+func (c *rawH2) request(id uint32, endStream bool, fields ...hpack.HeaderField) {
+	c.t.Helper()
+	block := c.enc.AppendBlock(nil, fields...)
+	require.NoError(c.t, c.framer.WriteHeaders(id, endStream, block, http2.DefaultMaxFrameSize))
+}
+
+// next returns the next frame that is not SETTINGS or WINDOW_UPDATE, or
+// nil once the connection is closed.
+func (c *rawH2) next() *http2.Frame {
+	c.t.Helper()
+	for {
+		fr, err := c.framer.ReadFrame()
+		if err == io.EOF {
+			return nil
+		}
+		require.NoError(c.t, err)
+		if fr.Type != http2.FrameSettings && fr.Type != http2.FrameWindowUpdate {
+			return fr
+		}
+	}
+}
+
+func (c *rawH2) headers(fr *http2.Frame) headers.Headers {
+	c.t.Helper()
+	require.Equal(c.t, http2.FrameHeaders, fr.Type)
+	block, err := fr.HeaderBlock()
+	require.NoError(c.t, err)
+	h, err := c.dec.DecodeHeaders(block)
+	require.NoError(c.t, err)
+	return h
+}
+
+func getFields(path string) []hpack.HeaderField {
+	return []hpack.HeaderField{
+		{Name: ":method", Value: "GET"},
+		{Name: ":scheme", Value: "http"},
+		{Name: ":path", Value: path},
+		{Name: ":authority", Value: "a.test"},
+	}
+}
+
+// Test: an HTTP/1.1 request with Upgrade: h2c is answered as stream 1
+// after a 101, and later streams follow on the same connection.
+func TestServeH2CUpgrade(t *testing.T) {
+	conn := startServer(t, h2Handler, WithHTTP2())
+	_, err := io.WriteString(conn, "GET /first HTTP/1.1\r\nHost: a.test\r\n"+
+		"Connection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: AAMAAABk\r\n\r\n")
+	require.NoError(t, err)
+	br := bufio.NewReader(conn)
+	status, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols\r\n", status)
+	for line := ""; line != "\r\n"; {
+		line, err = br.ReadString('\n')
+		require.NoError(t, err)
+	}
+
+	c := newRawH2(t, conn, br)
+	c.handshake()
+	fr := c.next()
+	assert.Equal(t, uint32(1), fr.StreamID)
+	// The body is empty, so HEADERS ends the stream.
+	assert.True(t, fr.Flags.Has(http2.FlagEndStream))
+	fields := c.headers(fr)
+	assert.Equal(t, "200", fields[":status"])
+	assert.Equal(t, "1.1", fields["x-version"])
+	assert.Equal(t, "a.test", fields["x-host"])
+
+	c.request(3, true, getFields("/second")...)
+	fr = c.next()
+	assert.Equal(t, uint32(3), fr.StreamID)
+	assert.Equal(t, "2", c.headers(fr)["x-version"])
+}
+
+// Test: malformed requests reset their stream, and protocol violations
+// end the connection with GOAWAY.
+func TestServeHTTP2ProtocolErrors(t *testing.T) {
+	addr := startH2CServer(t, h2Handler)
+	dial := func() *rawH2 {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		c := newRawH2(t, conn, bufio.NewReader(conn))
+		c.handshake()
+		return c
+	}
+	streamReset := func(c *rawH2, id uint32, code http2.ErrCode) {
+		t.Helper()
+		fr := c.next()
+		require.NotNil(t, fr)
+		require.Equal(t, http2.FrameRSTStream, fr.Type)
+		assert.Equal(t, id, fr.StreamID)
+		assert.Equal(t, code, fr.ErrCode())
+	}
+	goAway := func(c *rawH2, code http2.ErrCode) {
+		t.Helper()
+		fr := c.next()
+		require.NotNil(t, fr)
+		require.Equal(t, http2.FrameGoAway, fr.Type)
+		assert.Equal(t, code, fr.ErrCode())
+		assert.Nil(t, c.next())
+	}
+
+	c := dial()
+	c.request(1, true, append(getFields("/"), hpack.HeaderField{Name: "Upper", Value: "x"})...)
+	streamReset(c, 1, http2.ErrCodeProtocol)
+	c.request(3, true, append(getFields("/"), hpack.HeaderField{Name: "connection", Value: "close"})...)
+	streamReset(c, 3, http2.ErrCodeProtocol)
+	c.request(5, true, getFields("/")[1:]...)
+	streamReset(c, 5, http2.ErrCodeProtocol)
+	c.request(7, true, append([]hpack.HeaderField{{Name: "accept", Value: "*/*"}}, getFields("/")...)...)
+	streamReset(c, 7, http2.ErrCodeProtocol)
+	c.request(9, false, append(getFields("/"), hpack.HeaderField{Name: "content-length", Value: "2"})...)
+	require.NoError(t, c.framer.WriteData(9, true, []byte("abc")))
+	streamReset(c, 9, http2.ErrCodeProtocol)
+	// The connection survived all of the above.
+	c.request(11, true, getFields("/ok")...)
+	assert.Equal(t, "200", c.headers(c.next())[":status"])
+
+	c = dial()
+	require.NoError(t, c.framer.WriteData(1, true, []byte("x")))
+	goAway(c, http2.ErrCodeProtocol)
+
+	c = dial()
+	c.request(1, true, getFields("/")...)
+	c.next()
+	c.request(1, true, getFields("/")...)
+	goAway(c, http2.ErrCodeStreamClosed)
+
+	c = dial()
+	require.NoError(t, c.framer.WriteWindowUpdate(0, 0))
+	goAway(c, http2.ErrCodeProtocol)
+
+	c = dial()
+	require.NoError(t, c.framer.WriteFrame(http2.FrameHeaders, 0, 1, c.enc.AppendBlock(nil, getFields("/")...)))
+	require.NoError(t, c.framer.WritePing(false, [8]byte{}))
+	goAway(c, http2.ErrCodeProtocol)
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	c = newRawH2(t, conn, bufio.NewReader(conn))
+	_, err = io.WriteString(conn, http2.ClientPreface)
+	require.NoError(t, err)
+	require.NoError(t, c.framer.WritePing(false, [8]byte{}))
+	goAway(c, http2.ErrCodeProtocol)
+}
+
+// Test: PING is acknowledged with the same data.
+func TestServeHTTP2Ping(t *testing.T) {
+	conn, err := net.Dial("tcp", startH2CServer(t, h2Handler))
+	require.NoError(t, err)
+	defer conn.Close()
+	c := newRawH2(t, conn, bufio.NewReader(conn))
+	c.handshake()
+	require.NoError(t, c.framer.WritePing(false, [8]byte{1, 2, 3}))
+	for {
+		fr := c.next()
+		require.NotNil(t, fr)
+		if fr.Type == http2.FramePing {
+			assert.True(t, fr.Flags.Has(http2.FlagAck))
+			assert.Equal(t, []byte{1, 2, 3, 0, 0, 0, 0, 0}, fr.Payload)
+			return
+		}
+	}
+}
+
+// Test: a stream's request context is cancelled when the client resets
+// the stream, and when the connection goes away.
+func TestServeHTTP2ContextCancel(t *testing.T) {
+	cancelled := make(chan string, 2)
+	conn, err := net.Dial("tcp", startH2CServer(t, func(w response.Writer, req *request.Request) {
+		<-req.Context().Done()
+		cancelled <- req.RequestLine.RequestTarget
+	}))
+	require.NoError(t, err)
+	defer conn.Close()
+	c := newRawH2(t, conn, bufio.NewReader(conn))
+	c.handshake()
+
+	c.request(1, true, getFields("/reset")...)
+	c.request(3, true, getFields("/close")...)
+	require.NoError(t, c.framer.WriteRSTStream(1, http2.ErrCodeCancel))
+	assert.Equal(t, "/reset", <-cancelled)
+	require.NoError(t, conn.Close())
+	assert.Equal(t, "/close", <-cancelled)
+}
+
+// Test: the header list limit is advertised, and a request over it is
+// answered with a 431 while the connection carries on.
+func TestServeHTTP2HeaderListSize(t *testing.T) {
+	conn, err := net.Dial("tcp", startH2CServer(t, h2Handler))
+	require.NoError(t, err)
+	defer conn.Close()
+	c := newRawH2(t, conn, bufio.NewReader(conn))
+	c.handshake()
+	fr, err := c.framer.ReadFrame()
+	require.NoError(t, err)
+	require.Equal(t, http2.FrameSettings, fr.Type)
+	assert.Contains(t, fr.Settings(), http2.Setting{ID: http2.SettingMaxHeaderListSize, Value: h2MaxHeaderList})
+
+	// Indexed repeats keep the block small while the list grows past the
+	// limit.
+	big := hpack.HeaderField{Name: "x-big", Value: strings.Repeat("v", 1000)}
+	fields := getFields("/big")
+	for range h2MaxHeaderList / 1000 {
+		fields = append(fields, big)
+	}
+	c.request(1, true, fields...)
+	assert.Equal(t, "431", c.headers(c.next())[":status"])
+
+	c.request(3, true, append(getFields("/ok"), big)...)
+	for {
+		fr := c.next()
+		require.NotNil(t, fr)
+		if fr.Type == http2.FrameHeaders && fr.StreamID == 3 {
+			assert.Equal(t, "200", c.headers(fr)[":status"])
+			return
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// ConnStats counts the connections a server has seen.
+type ConnStats struct {
+	// Active is the number of connections open now, including those
+	// taken over by handlers.
+	Active int64
+	// Accepted is the number of connections admitted since the start.
+	Accepted uint64
+	// RejectedFull is the number of connections closed because
+	// WithMaxConnections was reached and WithRejectWhenFull was set.
+	RejectedFull uint64
+	// RejectedPerIP is the number of connections closed because their
+	// address already had WithMaxConnectionsPerIP open.
+	RejectedPerIP uint64
+}
+
+// WithMaxConnections limits how many connections are open at once. When
+// all are in use, new connections wait in the listen backlog until one
+// closes, unless WithRejectWhenFull is set. Connections taken over with
+// Hijack count until they are closed.
+func WithMaxConnections(n int) Option {
+	return func(s *Server) {
+		s.maxConns = n
+	}
+}
+
+// WithRejectWhenFull makes a server at WithMaxConnections accept and
+// close new connections straight away instead of leaving them queued.
+func WithRejectWhenFull() Option {
+	return func(s *Server) {
+		s.rejectWhenFull = true
+	}
+}
+
+// WithMaxConnectionsPerIP limits how many connections one client address
+// may have open. Connections over the limit are closed straight away.
+func WithMaxConnectionsPerIP(n int) Option {
+	return func(s *Server) {
+		s.maxConnsPerIP = n
+	}
+}
+
+// WithMaxRequestsPerConn closes HTTP/1 connections after n requests. The
+// last response carries Connection: close so the client does not try to
+// reuse the connection.
+func WithMaxRequestsPerConn(n int) Option {
+	return func(s *Server) {
+		s.maxRequestsPerConn = n
+	}
+}
+
+// ConnStats returns the server's connection counters.
+func (s *Server) ConnStats() ConnStats {
+	l := s.limits
+	return ConnStats{
+		Active:        l.active.Load(),
+		Accepted:      l.accepted.Load(),
+		RejectedFull:  l.rejectedFull.Load(),
+		RejectedPerIP: l.rejectedPerIP.Load(),
+	}
+}
+
+// limitListener enforces the connection limits below the TLS layer, so
+// rejected clients cost no handshake, and counts connections until they
+// are closed.
+type limitListener struct {
+	net.Listener
+	// slots holds a token per open connection, when the total is
+	// limited.
+	slots  chan struct{}
+	reject bool
+	perIP  int
+	done   chan struct{}
+	once   sync.Once
+
+	mu  sync.Mutex
+	ips map[string]int
+
+	active        atomic.Int64
+	accepted      atomic.Uint64
+	rejectedFull  atomic.Uint64
+	rejectedPerIP atomic.Uint64
+}
+
+func (s *Server) limitListener(listener net.Listener) *limitListener {
+	l := &limitListener{
+		Listener: listener,
+		reject:   s.rejectWhenFull,
+		perIP:    s.maxConnsPerIP,
+		done:     make(chan struct{}),
+		ips:      make(map[string]int),
+	}
+	if s.maxConns > 0 {
+		l.slots = make(chan struct{}, s.maxConns)
+	}
+	s.limits = l
+	return l
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		queued := l.slots != nil && !l.reject
+		if queued {
+			select {
+			case l.slots <- struct{}{}:
+			case <-l.done:
+				return nil, net.ErrClosed
+			}
+		}
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			if queued {
+				<-l.slots
+			}
+			return nil, err
+		}
+		if l.slots != nil && !queued {
+			select {
+			case l.slots <- struct{}{}:
+			default:
+				l.rejectedFull.Add(1)
+				conn.Close()
+				continue
+			}
+		}
+		ip := remoteIP(conn)
+		if !l.admitIP(ip) {
+			l.rejectedPerIP.Add(1)
+			l.releaseSlot()
+			conn.Close()
+			continue
+		}
+		l.active.Add(1)
+		l.accepted.Add(1)
+		return &limitedConn{Conn: conn, l: l, ip: ip}, nil
+	}
+}
+
+func (l *limitListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// admitIP counts a connection from ip, if it is under its limit.
+func (l *limitListener) admitIP(ip string) bool {
+	if l.perIP <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.ips[ip] >= l.perIP {
+		return false
+	}
+	l.ips[ip]++
+	return true
+}
+
+func (l *limitListener) release(ip string) {
+	l.active.Add(-1)
+	l.releaseSlot()
+	if l.perIP <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.ips[ip]--; l.ips[ip] <= 0 {
+		delete(l.ips, ip)
+	}
+}
+
+func (l *limitListener) releaseSlot() {
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+func remoteIP(conn net.Conn) string {
+	addr := conn.RemoteAddr().String()
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// limitedConn gives its connection's place back when closed.
+type limitedConn struct {
+	net.Conn
+	l    *limitListener
+	ip   string
+	once sync.Once
+}
+
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() { c.l.release(c.ip) })
+	return err
+}
+
+// CloseWrite half-closes the connection where the underlying one can,
+// as tunnels through hijacked connections rely on.
+func (c *limitedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Close()
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"main/internal/request"
+	"main/internal/response"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startLimitedServer runs handler with opts and returns the server.
+func startLimitedServer(t *testing.T, handler Handler, opts ...Option) *Server {
+	t.Helper()
+	s, err := Serve(0, handler, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func dial(t *testing.T, s *Server) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+	return conn, bufio.NewReader(conn)
+}
+
+// fetch sends a GET for path on conn and returns the response body.
+func fetch(t *testing.T, conn net.Conn, br *bufio.Reader, path string) string {
+	t.Helper()
+	_, err := io.WriteString(conn, "GET "+path+" HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+	status, body := readResponse(t, br)
+	require.Equal(t, "HTTP/1.1 200 OK", status)
+	return body
+}
+
+// assertClosed checks that the server closed conn without answering.
+func assertClosed(t *testing.T, conn net.Conn, br *bufio.Reader) {
+	t.Helper()
+	_, _ = io.WriteString(conn, "GET / HTTP/1.1\r\n\r\n")
+	_, err := br.ReadByte()
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, os.ErrDeadlineExceeded), "connection left open")
+}
+
+func TestMaxConnectionsQueue(t *testing.T) {
+	s := startLimitedServer(t, echoHandler, WithMaxConnections(1))
+	first, firstBr := dial(t, s)
+	assert.Equal(t, "/one ", fetch(t, first, firstBr, "/one"))
+
+	// Test: A connection over the limit waits unanswered
+	second, secondBr := dial(t, s)
+	_, err := io.WriteString(second, "GET /two HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+	require.NoError(t, second.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+	_, err = secondBr.Peek(1)
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+	// Test: It is served once the first one closes
+	require.NoError(t, second.SetReadDeadline(time.Now().Add(5*time.Second)))
+	first.Close()
+	status, body := readResponse(t, secondBr)
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, "/two ", body)
+	assert.Equal(t, ConnStats{Active: 1, Accepted: 2}, s.ConnStats())
+}
+
+func TestMaxConnectionsReject(t *testing.T) {
+	s := startLimitedServer(t, echoHandler, WithMaxConnections(1), WithRejectWhenFull())
+	first, firstBr := dial(t, s)
+	fetch(t, first, firstBr, "/")
+
+	// Test: A connection over the limit is closed and counted
+	rejected, rejectedBr := dial(t, s)
+	assertClosed(t, rejected, rejectedBr)
+	assert.Equal(t, ConnStats{Active: 1, Accepted: 1, RejectedFull: 1}, s.ConnStats())
+
+	// Test: Its place is free again once the first one closes
+	first.Close()
+	assert.Eventually(t, func() bool { return s.ConnStats().Active == 0 }, time.Second, time.Millisecond)
+	conn, br := dial(t, s)
+	fetch(t, conn, br, "/")
+}
+
+func TestMaxConnectionsPerIP(t *testing.T) {
+	hijacked := make(chan net.Conn, 1)
+	s := startLimitedServer(t, func(w response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/hijack" {
+			conn, _, err := w.(response.Hijacker).Hijack()
+			if err == nil {
+				hijacked <- conn
+			}
+			return
+		}
+		echoHandler(w, req)
+	}, WithMaxConnectionsPerIP(2))
+
+	// Test: Connections over the cap are closed, and hijacked ones count
+	// until the handler closes them
+	first, firstBr := dial(t, s)
+	fetch(t, first, firstBr, "/")
+	second, _ := dial(t, s)
+	_, err := io.WriteString(second, "GET /hijack HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+	conn := <-hijacked
+	rejected, rejectedBr := dial(t, s)
+	assertClosed(t, rejected, rejectedBr)
+	assert.Equal(t, ConnStats{Active: 2, Accepted: 2, RejectedPerIP: 1}, s.ConnStats())
+
+	conn.Close()
+	assert.Eventually(t, func() bool { return s.ConnStats().Active == 1 }, time.Second, time.Millisecond)
+	third, thirdBr := dial(t, s)
+	fetch(t, third, thirdBr, "/")
+}
+
+func TestMaxRequestsPerConn(t *testing.T) {
+	s := startLimitedServer(t, echoHandler, WithMaxRequestsPerConn(2))
+	conn, br := dial(t, s)
+
+	// Test: The last allowed response says so, and the connection closes
+	fetch(t, conn, br, "/one")
+	_, err := io.WriteString(conn, "GET /two HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+	var head strings.Builder
+	for line := ""; line != "\r\n"; {
+		line, err = br.ReadString('\n')
+		require.NoError(t, err)
+		head.WriteString(line)
+	}
+	assert.Contains(t, head.String(), "connection: close\r\n")
+	_, err = io.ReadAll(io.LimitReader(br, int64(len("/two "))))
+	require.NoError(t, err)
+	_, err = br.ReadByte()
+	assert.Equal(t, io.EOF, err)
+}
@@ -0,0 +1,368 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"main/internal/headers"
+	"main/internal/request"
+	"main/internal/response"
+	"maps"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Handler func(w response.Writer, req *request.Request)
+
+// ErrAbortHandler can be used as a panic value to abort a handler. The
+// connection is closed without finishing the response, so the client
+// sees it as incomplete, and nothing is logged.
+var ErrAbortHandler = errors.New("server: abort handler")
+
+type Server struct {
+	listener net.Listener
+	handler  Handler
+	logger   *slog.Logger
+	reqOpts  []request.Option
+	http2    bool
+	closed   atomic.Bool
+
+	// Connection limits, enforced by limits.
+	maxConns           int
+	rejectWhenFull     bool
+	maxConnsPerIP      int
+	maxRequestsPerConn int
+	limits             *limitListener
+
+	// TLS settings, used by ServeTLS only.
+	minTLSVersion  uint16
+	clientAuth     tls.ClientAuthType
+	clientCAs      *x509.CertPool
+	reloadInterval time.Duration
+	stopReload     func()
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithLogger sets the logger for connection errors and, at debug level,
+// request parser traces. Servers are silent by default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Server) {
+		if logger != nil {
+			s.logger = logger
+		}
+	}
+}
+
+// WithRequestOptions passes opts to the parser of every request, for
+// example request.WithDecompression.
+func WithRequestOptions(opts ...request.Option) Option {
+	return func(s *Server) {
+		s.reqOpts = append(s.reqOpts, opts...)
+	}
+}
+
+// Serve listens on port and serves each connection with handler in its
+// own goroutine until Close is called.
+func Serve(port int, handler Handler, opts ...Option) (*Server, error) {
+	s := newServer(handler, opts)
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, fmt.Errorf("error listening for connection: %w", err)
+	}
+	s.listener = s.limitListener(listener)
+
+	go s.listen()
+	return s, nil
+}
+
+func newServer(handler Handler, opts []Option) *Server {
+	s := &Server{
+		handler:        handler,
+		logger:         slog.New(slog.DiscardHandler),
+		minTLSVersion:  tls.VersionTLS12,
+		reloadInterval: defaultReloadInterval,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Addr returns the address the server is listening on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+func (s *Server) Close() error {
+	s.closed.Store(true)
+	if s.stopReload != nil {
+		s.stopReload()
+	}
+	return s.listener.Close()
+}
+
+func (s *Server) listen() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if s.closed.Load() {
+				return
+			}
+			s.logger.Error("error accepting connection", "error", err)
+			continue
+		}
+		go s.handle(conn)
+	}
+}
+
+// handle serves requests on conn until either side asks to close it or a
+// request leaves it in an unknown state.
+func (s *Server) handle(conn net.Conn) {
+	hijacked := false
+	defer func() {
+		if !hijacked {
+			conn.Close()
+		}
+	}()
+	var tlsState *tls.ConnectionState
+	if tc, ok := conn.(*tls.Conn); ok {
+		state, err := handshake(tc)
+		if err != nil {
+			s.logger.Debug("TLS handshake failed", "remote", conn.RemoteAddr(), "error", err)
+			return
+		}
+		tlsState = state
+	}
+	br := bufio.NewReaderSize(conn, 8192)
+	if s.http2 && (tlsState != nil && tlsState.NegotiatedProtocol == "h2" || tlsState == nil && hasH2Preface(br)) {
+		s.serveHTTP2(conn, br, tlsState, nil, nil)
+		return
+	}
+	opts := append([]request.Option{request.WithLogger(s.logger)}, s.reqOpts...)
+
+	for n := 1; ; n++ {
+		req, err := request.RequestFromReader(br, opts...)
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				s.logger.Debug("error reading request", "remote", conn.RemoteAddr(), "error", err)
+				w := response.NewConnWriter(conn)
+				_ = response.Error(w, parseErrorStatus(err), err.Error()+"\n")
+			}
+			return
+		}
+		req.TLS = tlsState
+		if settings, ok := h2cUpgrade(req); ok && s.http2 && tlsState == nil {
+			w := response.NewConnWriter(conn)
+			h := headers.NewHeaders()
+			h.Set("Connection", "Upgrade")
+			h.Set("Upgrade", "h2c")
+			if w.WriteStatusLine(response.StatusSwitchingProtocols) == nil && w.WriteHeaders(h) == nil {
+				s.serveHTTP2(conn, br, nil, req, settings)
+			}
+			return
+		}
+		last := s.maxRequestsPerConn > 0 && n >= s.maxRequestsPerConn
+		var keepAlive bool
+		keepAlive, hijacked = s.serve(conn, br, req, last)
+		if !keepAlive || last {
+			return
+		}
+	}
+}
+
+// serve runs the handler for one request and reports whether the
+// connection can be reused, or whether the handler took it over. last
+// marks the final request the connection may carry.
+func (s *Server) serve(conn net.Conn, br *bufio.Reader, req *request.Request, last bool) (keepAlive, hijacked bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watcher := &disconnectWatcher{conn: conn, br: br, cancel: cancel}
+	w := &connWriter{ConnWriter: response.NewServerConnWriter(conn, br), watcher: watcher, last: last}
+	req = req.WithContext(ctx)
+	req.RemoteAddr = conn.RemoteAddr().String()
+	if req.RequestLine.Method == "HEAD" {
+		w.SuppressBody()
+	}
+
+	if req.Headers.Get("Expect") != "" && !req.ExpectsContinue() {
+		_ = response.Error(w, response.StatusExpectationFailed, "Unsupported expectation\n")
+		return false, false
+	}
+	body := &continueReader{
+		ReadCloser: req.Body,
+		w:          w,
+		pending:    req.ExpectsContinue() && req.Body != request.NoBody,
+		onEOF:      watcher.start,
+	}
+	if req.Body != request.NoBody {
+		// NoBody stays recognizable to handlers.
+		req.Body = body
+	} else {
+		watcher.start()
+	}
+
+	ok := s.callHandler(s.handler, w, req)
+	watcher.stop()
+	if w.Hijacked() {
+		return false, true
+	}
+	if !ok {
+		return false, false
+	}
+	if err := w.Finish(); err != nil {
+		s.logger.Debug("error finishing response", "remote", conn.RemoteAddr(), "error", err)
+		return false, false
+	}
+
+	if body.pending {
+		// The handler answered without asking for the body, so the client
+		// may or may not send it. Closing is the only safe way to resync.
+		return false, false
+	}
+	if err := body.Close(); err != nil {
+		return false, false
+	}
+	return w.KeepAlive() && !req.Headers.HasToken("Connection", "close"), false
+}
+
+// connWriter stops watching for a disconnect before handing the
+// connection over, so the handler is its only reader, and tells the
+// client when a response is the last on the connection.
+type connWriter struct {
+	*response.ConnWriter
+	watcher *disconnectWatcher
+	last    bool
+}
+
+func (w *connWriter) WriteHeaders(h headers.Headers) error {
+	if w.last && !h.HasToken("Connection", "close") {
+		h = maps.Clone(h)
+		h.Set("Connection", "close")
+	}
+	return w.ConnWriter.WriteHeaders(h)
+}
+
+func (w *connWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.watcher.stop()
+	return w.ConnWriter.Hijack()
+}
+
+// disconnectWatcher cancels a request's context when the client closes
+// the connection while the handler runs. It reads ahead only once the
+// request body is done, so it never competes with the handler, and a
+// pipelined request it sees stays buffered for the next round.
+type disconnectWatcher struct {
+	conn   net.Conn
+	br     *bufio.Reader
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	started bool
+	stopped bool
+	done    chan struct{}
+}
+
+func (d *disconnectWatcher) start() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.started || d.stopped {
+		return
+	}
+	d.started = true
+	d.done = make(chan struct{})
+	go func() {
+		defer close(d.done)
+		_, err := d.br.Peek(1)
+		var netErr net.Error
+		if err != nil && !(errors.As(err, &netErr) && netErr.Timeout()) {
+			d.cancel()
+		}
+	}()
+}
+
+// stop ends the read-ahead, interrupting it with an expired deadline, so
+// the connection can be read again.
+func (d *disconnectWatcher) stop() {
+	d.mu.Lock()
+	started := d.started && !d.stopped
+	d.stopped = true
+	d.mu.Unlock()
+	if !started {
+		return
+	}
+	_ = d.conn.SetReadDeadline(time.Unix(1, 0))
+	<-d.done
+	_ = d.conn.SetReadDeadline(time.Time{})
+}
+
+// startedWriter is a response.Writer that reports whether the final
+// status has been written.
+type startedWriter interface {
+	response.Writer
+	Started() bool
+}
+
+func (s *Server) callHandler(handler Handler, w startedWriter, req *request.Request) (ok bool) {
+	defer func() {
+		if v := recover(); v != nil {
+			if v == ErrAbortHandler {
+				ok = false
+				return
+			}
+			s.logger.Error("handler panic", "target", req.RequestLine.RequestTarget, "panic", v)
+			if !w.Started() {
+				_ = response.Error(w, response.StatusInternalServerError, "Internal Server Error\n")
+			}
+			ok = false
+		}
+	}()
+	handler(w, req)
+	return true
+}
+
+func parseErrorStatus(err error) response.StatusCode {
+	switch {
+	case errors.Is(err, request.ErrLineTooLong), errors.Is(err, request.ErrHeaderTooLarge):
+		return response.StatusRequestHeaderFieldsTooLarge
+	case errors.Is(err, request.ErrUnsupportedTransferEncoding), errors.Is(err, request.ErrUnsupportedMethod):
+		return response.StatusNotImplemented
+	case errors.Is(err, request.ErrUnsupportedContentEncoding):
+		return response.StatusUnsupportedMediaType
+	default:
+		return response.StatusBadRequest
+	}
+}
+
+// continueReader sends 100 Continue the first time the handler reads the
+// body of a request that asked for it, unless a final response has
+// already been started. onEOF, if set, runs once the body has been read
+// to the end.
+type continueReader struct {
+	io.ReadCloser
+	w       startedWriter
+	pending bool
+	onEOF   func()
+}
+
+func (c *continueReader) Read(p []byte) (int, error) {
+	if c.pending && !c.w.Started() {
+		if err := c.w.WriteInterim(response.StatusContinue, nil); err != nil {
+			return 0, err
+		}
+		c.pending = false
+	}
+	n, err := c.ReadCloser.Read(p)
+	if err == io.EOF && c.onEOF != nil {
+		c.onEOF()
+	}
+	return n, err
+}
@@ -0,0 +1,339 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"main/internal/request"
+	"main/internal/response"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startServer(t *testing.T, handler Handler, opts ...Option) net.Conn {
+	t.Helper()
+	s, err := Serve(0, handler, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+	return conn
+}
+
+// readResponse reads one response with a Content-Length body.
+func readResponse(t *testing.T, br *bufio.Reader) (status string, body string) {
+	t.Helper()
+	status, err := br.ReadString('\n')
+	require.NoError(t, err)
+	length := 0
+	for {
+		line, err := br.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+		if name, value, _ := strings.Cut(line, ": "); name == "content-length" {
+			length, err = strconv.Atoi(strings.TrimSpace(value))
+			require.NoError(t, err)
+		}
+	}
+	buf := make([]byte, length)
+	_, err = io.ReadFull(br, buf)
+	require.NoError(t, err)
+	return strings.TrimSpace(status), string(buf)
+}
+
+func echoHandler(w response.Writer, req *request.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		_ = response.Error(w, response.StatusBadRequest, err.Error())
+		return
+	}
+	_ = response.Error(w, response.StatusOK, req.RequestLine.RequestTarget+" "+string(body))
+}
+
+func TestServeKeepAlive(t *testing.T) {
+	conn := startServer(t, echoHandler)
+	br := bufio.NewReader(conn)
+
+	// Test: Several requests on one connection
+	_, err := io.WriteString(conn, "GET /one HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	status, body := readResponse(t, br)
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, "/one ", body)
+
+	_, err = io.WriteString(conn, "POST /two HTTP/1.1\r\nContent-Length: 4\r\n\r\nping")
+	require.NoError(t, err)
+	status, body = readResponse(t, br)
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, "/two ping", body)
+
+	// Test: Connection: close ends the connection after the response
+	_, err = io.WriteString(conn, "GET /three HTTP/1.1\r\nConnection: close\r\n\r\n")
+	require.NoError(t, err)
+	_, body = readResponse(t, br)
+	assert.Equal(t, "/three ", body)
+	_, err = br.ReadByte()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestServeBadRequest(t *testing.T) {
+	conn := startServer(t, echoHandler)
+	br := bufio.NewReader(conn)
+
+	// Test: Malformed request line
+	_, err := io.WriteString(conn, "GET/path HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+	status, _ := readResponse(t, br)
+	assert.Equal(t, "HTTP/1.1 400 Bad Request", status)
+	_, err = br.ReadByte()
+	assert.Equal(t, io.EOF, err)
+
+	// Test: A header section over the limit
+	conn = startServer(t, echoHandler, WithRequestOptions(request.WithMaxHeaderBytes(64)))
+	br = bufio.NewReader(conn)
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\n"+strings.Repeat("X-A: 1\r\n", 10)+"\r\n")
+	require.NoError(t, err)
+	status, _ = readResponse(t, br)
+	assert.Equal(t, "HTTP/1.1 431 Request Header Fields Too Large", status)
+}
+
+func TestServeExpectContinue(t *testing.T) {
+	// Test: 100 Continue is sent when the handler reads the body
+	conn := startServer(t, echoHandler)
+	br := bufio.NewReader(conn)
+	_, err := io.WriteString(conn, "POST /upload HTTP/1.1\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\n")
+	require.NoError(t, err)
+	line, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 100 Continue\r\n", line)
+	line, err = br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "\r\n", line)
+	_, err = io.WriteString(conn, "hello")
+	require.NoError(t, err)
+	status, body := readResponse(t, br)
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, "/upload hello", body)
+
+	// Test: Handler rejects without reading, no 100 is sent
+	conn = startServer(t, func(w response.Writer, req *request.Request) {
+		_ = response.Error(w, response.StatusRequestEntityTooLarge, "too big")
+	})
+	br = bufio.NewReader(conn)
+	_, err = io.WriteString(conn, "POST /upload HTTP/1.1\r\nContent-Length: 999999\r\nExpect: 100-continue\r\n\r\n")
+	require.NoError(t, err)
+	status, body = readResponse(t, br)
+	assert.Equal(t, "HTTP/1.1 413 Content Too Large", status)
+	assert.Equal(t, "too big", body)
+	_, err = br.ReadByte()
+	assert.Equal(t, io.EOF, err)
+
+	// Test: Unknown expectations get 417
+	conn = startServer(t, echoHandler)
+	br = bufio.NewReader(conn)
+	_, err = io.WriteString(conn, "POST /upload HTTP/1.1\r\nContent-Length: 5\r\nExpect: something-else\r\n\r\n")
+	require.NoError(t, err)
+	status, _ = readResponse(t, br)
+	assert.Equal(t, "HTTP/1.1 417 Expectation Failed", status)
+}
+
+func TestServeHandlerPanic(t *testing.T) {
+	// Test: A panicking handler gets a 500 and the connection closes
+	conn := startServer(t, func(w response.Writer, req *request.Request) {
+		panic("boom")
+	})
+	br := bufio.NewReader(conn)
+	_, err := io.WriteString(conn, "GET / HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+	status, _ := readResponse(t, br)
+	assert.Equal(t, "HTTP/1.1 500 Internal Server Error", status)
+	_, err = br.ReadByte()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestServeDecompression(t *testing.T) {
+	conn := startServer(t, echoHandler, WithRequestOptions(request.WithDecompression(1024)))
+	br := bufio.NewReader(conn)
+
+	// Test: gzip request body reaches the handler decoded
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write([]byte("ping"))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	_, err = io.WriteString(conn, "POST /gz HTTP/1.1\r\nContent-Encoding: gzip\r\nContent-Length: "+strconv.Itoa(buf.Len())+"\r\n\r\n"+buf.String())
+	require.NoError(t, err)
+	status, body := readResponse(t, br)
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, "/gz ping", body)
+
+	// Test: Unsupported encodings get 415
+	_, err = io.WriteString(conn, "POST /br HTTP/1.1\r\nContent-Encoding: br\r\nContent-Length: 2\r\n\r\nxx")
+	require.NoError(t, err)
+	status, _ = readResponse(t, br)
+	assert.Equal(t, "HTTP/1.1 415 Unsupported Media Type", status)
+	_, err = br.ReadByte()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestServeHead(t *testing.T) {
+	conn := startServer(t, echoHandler, WithRequestOptions(request.WithMethods("HEAD")))
+	br := bufio.NewReader(conn)
+
+	// Test: HEAD gets the headers of the GET response without its body
+	_, err := io.WriteString(conn, "HEAD /page HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+	status, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", status)
+	for {
+		line, err := br.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+		if name, value, _ := strings.Cut(line, ": "); name == "content-length" {
+			assert.Equal(t, "6\r\n", value)
+		}
+	}
+
+	// Test: The connection stays usable after a HEAD response
+	_, err = io.WriteString(conn, "GET /next HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+	status, body := readResponse(t, br)
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, "/next ", body)
+
+	// Test: Methods that were not enabled get 501
+	_, err = io.WriteString(conn, "DELETE / HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+	status, _ = readResponse(t, br)
+	assert.Equal(t, "HTTP/1.1 501 Not Implemented", status)
+}
+
+func TestServeAbortHandler(t *testing.T) {
+	// Test: ErrAbortHandler drops the connection mid-response
+	conn := startServer(t, func(w response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(10)
+		_ = w.WriteStatusLine(response.StatusOK)
+		_ = w.WriteHeaders(h)
+		_, _ = w.WriteBody([]byte("part"))
+		panic(ErrAbortHandler)
+	})
+	_, err := io.WriteString(conn, "GET / HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(string(data), "\r\n\r\npart"))
+	assert.Contains(t, string(data), "HTTP/1.1 200 OK")
+}
+
+func TestServeRemoteAddr(t *testing.T) {
+	// Test: Handlers see the client address
+	conn := startServer(t, func(w response.Writer, req *request.Request) {
+		_ = response.Error(w, response.StatusOK, req.RemoteAddr)
+	})
+	_, err := io.WriteString(conn, "GET / HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+	_, body := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, conn.LocalAddr().String(), body)
+}
+
+func TestServeHijack(t *testing.T) {
+	// Test: A hijacked connection is left to the handler, with the bytes
+	// already buffered after the request
+	conn := startServer(t, func(w response.Writer, req *request.Request) {
+		c, rw, err := w.(response.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer c.Close()
+			line, _ := rw.ReadString('\n')
+			_, _ = rw.WriteString("echo: " + line)
+			_ = rw.Flush()
+		}()
+	})
+	_, err := io.WriteString(conn, "GET /raw HTTP/1.1\r\n\r\nearly bytes\n")
+	require.NoError(t, err)
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "echo: early bytes\n", string(data))
+}
+
+func TestServeContextCancel(t *testing.T) {
+	cancelled := make(chan error, 1)
+	conn := startServer(t, func(w response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/wait" {
+			<-req.Context().Done()
+			cancelled <- req.Context().Err()
+			return
+		}
+		echoHandler(w, req)
+	})
+
+	// Test: A pipelined request is not mistaken for a disconnect, and
+	// both are answered
+	_, err := io.WriteString(conn, "POST /a HTTP/1.1\r\nContent-Length: 2\r\n\r\nhiGET /b HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+	br := bufio.NewReader(conn)
+	status, body := readResponse(t, br)
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, "/a hi", body)
+	status, _ = readResponse(t, br)
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+
+	// Test: Closing the connection cancels the context of the running
+	// request
+	_, err = io.WriteString(conn, "GET /wait HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, conn.Close())
+	select {
+	case err := <-cancelled:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("context not cancelled")
+	}
+}
+
+func TestServeHijackWhileWatching(t *testing.T) {
+	// Test: Hijacking stops the read-ahead, so data sent afterwards
+	// reaches the handler and its context stays alive
+	conn := startServer(t, func(w response.Writer, req *request.Request) {
+		c, rw, err := w.(response.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		_, _ = rw.WriteString("ready\n")
+		_ = rw.Flush()
+		line, _ := rw.ReadString('\n')
+		_, _ = rw.WriteString(fmt.Sprintf("echo: %s err: %v\n", strings.TrimSpace(line), req.Context().Err()))
+		_ = rw.Flush()
+	})
+	_, err := io.WriteString(conn, "GET /raw HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+	br := bufio.NewReader(conn)
+	line, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "ready\n", line)
+	_, err = io.WriteString(conn, "late bytes\n")
+	require.NoError(t, err)
+	line, err = br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "echo: late bytes err: <nil>\n", line)
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CertFiles names a PEM certificate chain and its private key.
+type CertFiles struct {
+	CertFile string
+	KeyFile  string
+}
+
+// CertStore holds the certificates a TLS server presents, chosen by the
+// server name the client asks for (SNI). Certificates are loaded from
+// files and can be reloaded while the server runs.
+type CertStore struct {
+	files []CertFiles
+
+	mu     sync.RWMutex
+	stamps []fileStamp
+	certs  []*tls.Certificate
+	// names maps each DNS name in the certificates, including wildcards
+	// such as "*.example.com", to the first certificate that covers it.
+	names map[string]*tls.Certificate
+}
+
+// fileStamp identifies a version of a file without reading it.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// LoadCertificates loads each certificate and key pair. The first pair
+// is presented to clients that send no server name, or one no
+// certificate covers.
+func LoadCertificates(files ...CertFiles) (*CertStore, error) {
+	if len(files) == 0 {
+		return nil, errors.New("no certificates given")
+	}
+	s := &CertStore{files: files}
+	stamps, err := s.stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.load(stamps); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload loads the certificates again if any of the files changed since
+// they were last loaded, and reports whether it did. On error the
+// certificates in use are kept, so a half-written pair never reaches
+// clients; the next Reload tries again.
+func (s *CertStore) Reload() (bool, error) {
+	stamps, err := s.stat()
+	if err != nil {
+		return false, err
+	}
+	s.mu.RLock()
+	unchanged := slices.Equal(stamps, s.stamps)
+	s.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+	if err := s.load(stamps); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *CertStore) stat() ([]fileStamp, error) {
+	stamps := make([]fileStamp, 0, 2*len(s.files))
+	for _, f := range s.files {
+		for _, name := range []string{f.CertFile, f.KeyFile} {
+			info, err := os.Stat(name)
+			if err != nil {
+				return nil, err
+			}
+			stamps = append(stamps, fileStamp{info.ModTime(), info.Size()})
+		}
+	}
+	return stamps, nil
+}
+
+func (s *CertStore) load(stamps []fileStamp) error {
+	certs := make([]*tls.Certificate, 0, len(s.files))
+	names := make(map[string]*tls.Certificate)
+	for _, f := range s.files {
+		cert, err := tls.LoadX509KeyPair(f.CertFile, f.KeyFile)
+		if err != nil {
+			return fmt.Errorf("error loading %s: %w", f.CertFile, err)
+		}
+		certs = append(certs, &cert)
+
+		leafNames := cert.Leaf.DNSNames
+		if len(leafNames) == 0 && cert.Leaf.Subject.CommonName != "" {
+			leafNames = []string{cert.Leaf.Subject.CommonName}
+		}
+		for _, name := range leafNames {
+			name = strings.ToLower(name)
+			if _, ok := names[name]; !ok {
+				names[name] = &cert
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stamps = stamps
+	s.certs = certs
+	s.names = names
+	return nil
+}
+
+// GetCertificate picks the certificate for a handshake, for use as
+// tls.Config.GetCertificate. An exact name match wins over a wildcard.
+func (s *CertStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if cert, ok := s.names[name]; ok {
+		return cert, nil
+	}
+	if _, parent, found := strings.Cut(name, "."); found {
+		if cert, ok := s.names["*."+parent]; ok {
+			return cert, nil
+		}
+	}
+	return s.certs[0], nil
+}
+
+// watch calls Reload every interval until the returned function is
+// called.
+func (s *CertStore) watch(interval time.Duration, logger *slog.Logger) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				reloaded, err := s.Reload()
+				switch {
+				case err != nil:
+					logger.Warn("error reloading certificates", "error", err)
+				case reloaded:
+					logger.Info("reloaded certificates")
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+const (
+	defaultReloadInterval = 10 * time.Second
+	handshakeTimeout      = 10 * time.Second
+)
+
+// WithMinTLSVersion sets the oldest TLS version ServeTLS accepts, such as
+// tls.VersionTLS13. The default is TLS 1.2.
+func WithMinTLSVersion(version uint16) Option {
+	return func(s *Server) {
+		s.minTLSVersion = version
+	}
+}
+
+// WithClientCertificates makes ServeTLS ask clients for a certificate
+// and verify it against cas. If required is false, clients without one
+// are still served. The verified chains are on Request.TLS.
+func WithClientCertificates(cas *x509.CertPool, required bool) Option {
+	return func(s *Server) {
+		s.clientCAs = cas
+		s.clientAuth = tls.VerifyClientCertIfGiven
+		if required {
+			s.clientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+}
+
+// WithCertReloadInterval sets how often ServeTLS checks the certificate
+// files for changes. Zero disables the checks, leaving reloads to
+// explicit CertStore.Reload calls.
+func WithCertReloadInterval(d time.Duration) Option {
+	return func(s *Server) {
+		s.reloadInterval = d
+	}
+}
+
+// ServeTLS is like Serve, but serves HTTPS with the certificates in
+// certs, reloading them when their files change.
+func ServeTLS(port int, handler Handler, certs *CertStore, opts ...Option) (*Server, error) {
+	s := newServer(handler, opts)
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, fmt.Errorf("error listening for connection: %w", err)
+	}
+	config := &tls.Config{
+		GetCertificate: certs.GetCertificate,
+		MinVersion:     s.minTLSVersion,
+		ClientAuth:     s.clientAuth,
+		ClientCAs:      s.clientCAs,
+	}
+	if s.http2 {
+		config.NextProtos = []string{"h2", "http/1.1"}
+	}
+	s.listener = tls.NewListener(s.limitListener(listener), config)
+	if s.reloadInterval > 0 {
+		s.stopReload = certs.watch(s.reloadInterval, s.logger)
+	}
+
+	go s.listen()
+	return s, nil
+}
+
+// handshake completes the TLS handshake up front, so a client that never
+// finishes it cannot hold the connection open, and returns the state
+// requests are tagged with.
+func handshake(conn *tls.Conn) (*tls.ConnectionState, error) {
+	if err := conn.SetDeadline(time.Now().Add(handshakeTimeout)); err != nil {
+		return nil, err
+	}
+	if err := conn.Handshake(); err != nil {
+		return nil, err
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	state := conn.ConnectionState()
+	return &state, nil
+}
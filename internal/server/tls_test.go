@@ -0,0 +1,277 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"main/internal/request"
+	"main/internal/response"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCA signs certificates for the tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue returns a PEM certificate and key for commonName and dnsNames.
+func (ca *testCA) issue(t *testing.T, commonName string, dnsNames ...string) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+}
+
+// pairStamp is the modification time of the last pair written. Each
+// write moves it on, so rewrites within the file system's timestamp
+// granularity are still noticed.
+var pairStamp = time.Now()
+
+// writePair writes a certificate and key under dir and returns their
+// paths.
+func writePair(t *testing.T, dir, name string, certPEM, keyPEM []byte) CertFiles {
+	t.Helper()
+	files := CertFiles{
+		CertFile: filepath.Join(dir, name+".crt"),
+		KeyFile:  filepath.Join(dir, name+".key"),
+	}
+	pairStamp = pairStamp.Add(time.Second)
+	for path, data := range map[string][]byte{files.CertFile: certPEM, files.KeyFile: keyPEM} {
+		require.NoError(t, os.WriteFile(path, data, 0o600))
+		require.NoError(t, os.Chtimes(path, pairStamp, pairStamp))
+	}
+	return files
+}
+
+// startTLSServer returns the address of a TLS server running handler.
+func startTLSServer(t *testing.T, handler Handler, certs *CertStore, opts ...Option) string {
+	t.Helper()
+	s, err := ServeTLS(0, handler, certs, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(s.Addr().(*net.TCPAddr).Port))
+}
+
+// peerName handshakes with addr as serverName and returns the common
+// name of the certificate the server presented.
+func peerName(t *testing.T, addr, serverName string, pool *x509.CertPool) string {
+	t.Helper()
+	conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: serverName, RootCAs: pool, InsecureSkipVerify: serverName == ""})
+	require.NoError(t, err)
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+}
+
+// get sends a GET over a TLS connection and returns the response body.
+func get(t *testing.T, conn *tls.Conn) string {
+	t.Helper()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+	_, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: a.test\r\nConnection: close\r\n\r\n")
+	require.NoError(t, err)
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	return string(out)
+}
+
+func TestServeTLSCertificateSelection(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certA, keyA := ca.issue(t, "a", "a.test")
+	certB, keyB := ca.issue(t, "b", "*.b.test", "exact.b.test")
+	certC, keyC := ca.issue(t, "c", "c.b.test")
+	certs, err := LoadCertificates(
+		writePair(t, dir, "a", certA, keyA),
+		writePair(t, dir, "b", certB, keyB),
+		writePair(t, dir, "c", certC, keyC),
+	)
+	require.NoError(t, err)
+	addr := startTLSServer(t, echoHandler, certs)
+
+	// Test: SNI picks the certificate covering the name
+	assert.Equal(t, "a", peerName(t, addr, "a.test", ca.pool))
+	assert.Equal(t, "b", peerName(t, addr, "exact.b.test", ca.pool))
+	assert.Equal(t, "b", peerName(t, addr, "any.b.test", ca.pool))
+
+	// Test: An exact name wins over a wildcard listed earlier
+	assert.Equal(t, "c", peerName(t, addr, "c.b.test", ca.pool))
+
+	// Test: Without SNI the first certificate is used
+	assert.Equal(t, "a", peerName(t, addr, "", nil))
+
+	// Test: Requests carry the connection state
+	var state *tls.ConnectionState
+	addr = startTLSServer(t, func(w response.Writer, req *request.Request) {
+		state = req.TLS
+		_ = response.Error(w, response.StatusOK, "secure")
+	}, certs)
+	conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: "a.test", RootCAs: ca.pool})
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Contains(t, get(t, conn), "secure")
+	require.NotNil(t, state)
+	assert.Equal(t, "a.test", state.ServerName)
+	assert.Empty(t, state.PeerCertificates)
+}
+
+func TestServeTLSReload(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	cert, key := ca.issue(t, "first", "a.test")
+	files := writePair(t, dir, "a", cert, key)
+	certs, err := LoadCertificates(files)
+	require.NoError(t, err)
+	addr := startTLSServer(t, echoHandler, certs, WithCertReloadInterval(10*time.Millisecond))
+	assert.Equal(t, "first", peerName(t, addr, "a.test", ca.pool))
+
+	// Test: Unchanged files are not reloaded
+	reloaded, err := certs.Reload()
+	require.NoError(t, err)
+	assert.False(t, reloaded)
+
+	// Test: A certificate that does not match its key is not used
+	next, nextKey := ca.issue(t, "second", "a.test")
+	writePair(t, dir, "a", next, key)
+	reloaded, err = certs.Reload()
+	assert.Error(t, err)
+	assert.False(t, reloaded)
+	assert.Equal(t, "first", peerName(t, addr, "a.test", ca.pool))
+
+	// Test: Changed files are picked up without a restart
+	writePair(t, dir, "a", next, nextKey)
+	assert.Eventually(t, func() bool {
+		return peerName(t, addr, "a.test", ca.pool) == "second"
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestServeTLSMinVersion(t *testing.T) {
+	ca := newTestCA(t)
+	cert, key := ca.issue(t, "a", "a.test")
+	certs, err := LoadCertificates(writePair(t, t.TempDir(), "a", cert, key))
+	require.NoError(t, err)
+	addr := startTLSServer(t, echoHandler, certs, WithMinTLSVersion(tls.VersionTLS13))
+
+	// Test: Clients below the minimum version are refused
+	_, err = tls.Dial("tcp", addr, &tls.Config{ServerName: "a.test", RootCAs: ca.pool, MaxVersion: tls.VersionTLS12})
+	assert.Error(t, err)
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: "a.test", RootCAs: ca.pool})
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, uint16(tls.VersionTLS13), conn.ConnectionState().Version)
+}
+
+func TestServeTLSClientCertificates(t *testing.T) {
+	ca := newTestCA(t)
+	cert, key := ca.issue(t, "server", "a.test")
+	certs, err := LoadCertificates(writePair(t, t.TempDir(), "a", cert, key))
+	require.NoError(t, err)
+	clientPEM, clientKey := ca.issue(t, "alice")
+	clientCert, err := tls.X509KeyPair(clientPEM, clientKey)
+	require.NoError(t, err)
+
+	peer := func(w response.Writer, req *request.Request) {
+		name := "anonymous"
+		if len(req.TLS.PeerCertificates) > 0 {
+			name = req.TLS.PeerCertificates[0].Subject.CommonName
+		}
+		_ = response.Error(w, response.StatusOK, "hello "+name)
+	}
+	dial := func(addr string, certs ...tls.Certificate) string {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: "a.test", RootCAs: ca.pool, Certificates: certs})
+		if err != nil {
+			return err.Error()
+		}
+		defer conn.Close()
+		require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+		_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nConnection: close\r\n\r\n")
+		if err != nil {
+			return err.Error()
+		}
+		out, err := io.ReadAll(conn)
+		if err != nil {
+			return err.Error()
+		}
+		return string(out)
+	}
+
+	// Test: Verified client certificates are exposed on the request
+	required := startTLSServer(t, peer, certs, WithClientCertificates(ca.pool, true))
+	assert.Contains(t, dial(required, clientCert), "hello alice")
+
+	// Test: Clients without a certificate are refused when one is required
+	assert.NotContains(t, dial(required), "hello")
+
+	// Test: Optional verification serves anonymous clients
+	optional := startTLSServer(t, peer, certs, WithClientCertificates(ca.pool, false))
+	assert.Contains(t, dial(optional), "hello anonymous")
+	assert.Contains(t, dial(optional, clientCert), "hello alice")
+
+	// Test: Certificates from an unknown CA are refused
+	otherPEM, otherKey := newTestCA(t).issue(t, "mallory")
+	other, err := tls.X509KeyPair(otherPEM, otherKey)
+	require.NoError(t, err)
+	assert.NotContains(t, dial(optional, other), "hello")
+}
+
+func TestLoadCertificatesErrors(t *testing.T) {
+	// Test: Missing files and empty lists are errors
+	_, err := LoadCertificates()
+	assert.Error(t, err)
+	_, err = LoadCertificates(CertFiles{CertFile: "missing.crt", KeyFile: "missing.key"})
+	assert.Error(t, err)
+}
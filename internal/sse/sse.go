@@ -0,0 +1,228 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"main/internal/headers"
+	"main/internal/request"
+	"main/internal/response"
+	"main/internal/server"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultHeartbeat = 15 * time.Second
+
+var (
+	// ErrClosed is returned by writes after Close.
+	ErrClosed = errors.New("sse: stream closed")
+	// ErrInvalidField is returned for an ID or event type containing a
+	// line break, which would end the field early.
+	ErrInvalidField = errors.New("sse: line break in id or event field")
+)
+
+// Event is one server-sent event.
+type Event struct {
+	// ID becomes the client's last event ID, sent back in Last-Event-ID
+	// when it reconnects.
+	ID string
+	// Event is the event type. Clients treat an empty one as "message".
+	Event string
+	// Data is the payload. Each of its lines is sent as a data field and
+	// joined again by the client. Empty data sends no data field, so
+	// the client only updates its ID and retry delay.
+	Data string
+	// Retry, if positive, sets how long the client waits before
+	// reconnecting.
+	Retry time.Duration
+}
+
+// appendTo appends the wire form of e, ended by a blank line.
+func (e Event) appendTo(b []byte) ([]byte, error) {
+	if strings.ContainsAny(e.ID, "\r\n\x00") || strings.ContainsAny(e.Event, "\r\n") {
+		return nil, ErrInvalidField
+	}
+	if e.Event != "" {
+		b = append(append(append(b, "event: "...), e.Event...), '\n')
+	}
+	if e.ID != "" {
+		b = append(append(append(b, "id: "...), e.ID...), '\n')
+	}
+	if e.Retry > 0 {
+		b = strconv.AppendInt(append(b, "retry: "...), e.Retry.Milliseconds(), 10)
+		b = append(b, '\n')
+	}
+	if e.Data != "" {
+		for _, line := range splitLines(e.Data) {
+			b = append(append(append(b, "data: "...), line...), '\n')
+		}
+	}
+	return append(b, '\n'), nil
+}
+
+// splitLines splits s at CRLF, CR or LF, the line ends the event stream
+// format accepts.
+func splitLines(s string) []string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.Split(strings.ReplaceAll(s, "\r", "\n"), "\n")
+}
+
+type config struct {
+	heartbeat time.Duration
+}
+
+// Option configures NewStream and Handler.
+type Option func(*config)
+
+// WithHeartbeat sets how often a comment is sent while no events are,
+// which keeps proxies from timing the connection out and notices a
+// departed client. Zero disables heartbeats.
+func WithHeartbeat(d time.Duration) Option {
+	return func(c *config) {
+		c.heartbeat = d
+	}
+}
+
+// Stream writes events to one client. Its methods may be called from
+// any goroutine.
+type Stream struct {
+	w   response.Writer
+	ctx context.Context
+
+	mu     sync.Mutex
+	err    error
+	closed bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewStream answers req with an event stream. The response is chunked
+// and marked uncacheable and unbuffered, so every event reaches the
+// client as it is sent.
+func NewStream(w response.Writer, req *request.Request, opts ...Option) (*Stream, error) {
+	cfg := &config{heartbeat: defaultHeartbeat}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	h := headers.NewHeaders()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	// Asks reverse proxies such as nginx not to buffer the stream.
+	h.Set("X-Accel-Buffering", "no")
+	h.Set("Transfer-Encoding", "chunked")
+	if err := w.WriteStatusLine(response.StatusOK); err != nil {
+		return nil, err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return nil, err
+	}
+
+	s := &Stream{
+		w:    w,
+		ctx:  req.Context(),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	if cfg.heartbeat > 0 {
+		go s.heartbeat(cfg.heartbeat)
+	} else {
+		close(s.done)
+	}
+	return s, nil
+}
+
+// Handler returns a server.Handler that starts a stream for each request
+// and runs fn with it, closing the stream when fn returns. fn should
+// return once the stream's context is done.
+func Handler(fn func(s *Stream, req *request.Request), opts ...Option) server.Handler {
+	return func(w response.Writer, req *request.Request) {
+		s, err := NewStream(w, req, opts...)
+		if err != nil {
+			return
+		}
+		defer s.Close()
+		fn(s, req)
+	}
+}
+
+// Context returns the request's context, which is done once the client
+// has disconnected.
+func (s *Stream) Context() context.Context {
+	return s.ctx
+}
+
+// Send writes ev. It fails once the client has gone away.
+func (s *Stream) Send(ev Event) error {
+	b, err := ev.appendTo(nil)
+	if err != nil {
+		return err
+	}
+	return s.write(b)
+}
+
+// Comment writes a comment, which clients ignore.
+func (s *Stream) Comment(text string) error {
+	var b []byte
+	for _, line := range splitLines(text) {
+		b = append(append(append(b, ": "...), line...), '\n')
+	}
+	return s.write(append(b, '\n'))
+}
+
+// Close stops the heartbeats and ends the response.
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.stop)
+	s.mu.Unlock()
+	<-s.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	_, err := s.w.WriteChunkedBodyDone()
+	return err
+}
+
+func (s *Stream) write(b []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if s.err == nil {
+		s.err = s.ctx.Err()
+	}
+	if s.err == nil {
+		_, s.err = s.w.WriteChunkedBody(b)
+	}
+	return s.err
+}
+
+func (s *Stream) heartbeat(interval time.Duration) {
+	defer close(s.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if s.write([]byte(": heartbeat\n\n")) != nil {
+				return
+			}
+		case <-s.ctx.Done():
+			return
+		case <-s.stop:
+			return
+		}
+	}
+}
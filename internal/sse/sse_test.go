@@ -0,0 +1,142 @@
+package sse
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"main/internal/request"
+	"main/internal/response"
+	"main/internal/server"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startServer runs handler and returns its base URL.
+func startServer(t *testing.T, handler server.Handler) string {
+	t.Helper()
+	s, err := server.Serve(0, handler)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return "http://" + s.Addr().String()
+}
+
+func get(t *testing.T, url string) *http.Response {
+	t.Helper()
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestEventEncoding(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		ev   Event
+		want string
+	}{
+		{"data only", Event{Data: "hello"}, "data: hello\n\n"},
+		{"all fields", Event{ID: "7", Event: "update", Data: "x", Retry: 2500 * time.Millisecond},
+			"event: update\nid: 7\nretry: 2500\ndata: x\n\n"},
+		{"multi-line data", Event{Data: "a\nb\r\nc\rd"}, "data: a\ndata: b\ndata: c\ndata: d\n\n"},
+		{"trailing newline", Event{Data: "a\n"}, "data: a\ndata: \n\n"},
+		{"no data", Event{ID: "8"}, "id: 8\n\n"},
+	} {
+		// Test: Each line of data becomes its own field
+		b, err := tc.ev.appendTo(nil)
+		require.NoError(t, err, tc.name)
+		assert.Equal(t, tc.want, string(b), tc.name)
+	}
+
+	// Test: Line breaks cannot end the id or event field early
+	for _, ev := range []Event{{ID: "1\n2"}, {ID: "1\x002"}, {Event: "a\rb"}} {
+		_, err := ev.appendTo(nil)
+		assert.ErrorIs(t, err, ErrInvalidField)
+	}
+}
+
+func TestStream(t *testing.T) {
+	url := startServer(t, Handler(func(s *Stream, req *request.Request) {
+		_ = s.Send(Event{Event: "greeting", Data: "hello\nworld"})
+		_ = s.Comment("note")
+		_ = s.Send(Event{ID: "2", Data: "bye"})
+	}, WithHeartbeat(0)))
+
+	// Test: The stream headers, and the events in order once it closes
+	resp := get(t, url)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "no-cache", resp.Header.Get("Cache-Control"))
+	assert.Equal(t, "no", resp.Header.Get("X-Accel-Buffering"))
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "event: greeting\ndata: hello\ndata: world\n\n: note\n\nid: 2\ndata: bye\n\n", string(body))
+}
+
+func TestStreamHeartbeat(t *testing.T) {
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	url := startServer(t, Handler(func(s *Stream, req *request.Request) {
+		_ = s.Send(Event{Data: "first"})
+		select {
+		case <-release:
+		case <-s.Context().Done():
+		}
+	}, WithHeartbeat(20*time.Millisecond)))
+
+	// Test: Comments keep an idle stream alive, each arriving as it is
+	// sent
+	br := bufio.NewReader(get(t, url).Body)
+	for _, want := range []string{"data: first\n", "\n", ": heartbeat\n", "\n", ": heartbeat\n"} {
+		line, err := br.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, want, line)
+	}
+}
+
+func TestStreamClientDisconnect(t *testing.T) {
+	errs := make(chan error, 1)
+	url := startServer(t, Handler(func(s *Stream, req *request.Request) {
+		<-s.Context().Done()
+		errs <- s.Send(Event{Data: "too late"})
+	}))
+
+	// Test: Closing the connection cancels the stream's context, and
+	// later sends fail
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	cancel()
+	select {
+	case err := <-errs:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream not stopped")
+	}
+}
+
+func TestStreamClose(t *testing.T) {
+	errs := make(chan error, 1)
+	url := startServer(t, func(w response.Writer, req *request.Request) {
+		s, err := NewStream(w, req)
+		if err != nil {
+			errs <- err
+			return
+		}
+		_ = s.Close()
+		errs <- s.Comment("late")
+	})
+
+	// Test: Nothing can be written once the stream is closed
+	body, err := io.ReadAll(get(t, url).Body)
+	require.NoError(t, err)
+	assert.Empty(t, body)
+	assert.ErrorIs(t, <-errs, ErrClosed)
+}
@@ -0,0 +1,420 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// MessageType is the type of a data message.
+type MessageType int
+
+const (
+	TextMessage   MessageType = 1
+	BinaryMessage MessageType = 2
+)
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+
+	finBit  = 0x80
+	maskBit = 0x80
+	// maxControlPayload is the limit on control frame payloads, which
+	// may not be fragmented either.
+	maxControlPayload = 125
+	// maxPayloadPrealloc is the largest payload allocated up front. Longer
+	// ones grow as their bytes arrive, so a frame header cannot claim
+	// memory the peer never sends.
+	maxPayloadPrealloc = 64 << 10
+)
+
+// CloseCode is the status code of a close frame (RFC 6455 7.4).
+type CloseCode uint16
+
+const (
+	CloseNormalClosure    CloseCode = 1000
+	CloseGoingAway        CloseCode = 1001
+	CloseProtocolError    CloseCode = 1002
+	CloseUnsupportedData  CloseCode = 1003
+	CloseNoStatusReceived CloseCode = 1005
+	CloseInvalidPayload   CloseCode = 1007
+	ClosePolicyViolation  CloseCode = 1008
+	CloseMessageTooBig    CloseCode = 1009
+	CloseInternalError    CloseCode = 1011
+)
+
+// validCloseCode reports whether code may be sent in a close frame.
+// 1005 and 1006 only describe closes locally, and 1004 and 1015 are
+// reserved.
+func validCloseCode(code CloseCode) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1014:
+		return true
+	default:
+		return code >= 3000 && code <= 4999
+	}
+}
+
+// CloseError is returned by ReadMessage once the peer has sent a close
+// frame. The close has already been answered.
+type CloseError struct {
+	Code   CloseCode
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("websocket: closed by peer with %d", e.Code)
+	}
+	return fmt.Sprintf("websocket: closed by peer with %d: %s", e.Code, e.Reason)
+}
+
+var (
+	ErrProtocol      = errors.New("websocket: protocol error")
+	ErrInvalidUTF8   = errors.New("websocket: invalid UTF-8 in text message")
+	ErrMessageTooBig = errors.New("websocket: message too big")
+	// ErrClosed is returned by writes once a close frame has been sent.
+	ErrClosed = errors.New("websocket: close frame already sent")
+)
+
+// Conn is the server side of a WebSocket connection. One goroutine may
+// read while others write: frames are written whole, and pings are
+// answered from within ReadMessage.
+type Conn struct {
+	conn           net.Conn
+	br             *bufio.Reader
+	maxMessageSize int64
+	subprotocol    string
+	// readErr is returned by every read after the first failure.
+	readErr error
+
+	wmu       sync.Mutex
+	bw        *bufio.Writer
+	closeSent bool
+}
+
+func newConn(conn net.Conn, br *bufio.Reader, maxMessageSize int64, subprotocol string) *Conn {
+	return &Conn{
+		conn:           conn,
+		br:             br,
+		bw:             bufio.NewWriter(conn),
+		maxMessageSize: maxMessageSize,
+		subprotocol:    subprotocol,
+	}
+}
+
+// Subprotocol returns the subprotocol selected during the handshake, or
+// "".
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// frameHeader is the part of a frame before its payload.
+type frameHeader struct {
+	fin    bool
+	opcode byte
+	length uint64
+	mask   [4]byte
+}
+
+// ReadMessage returns the next data message, reassembled from its
+// fragments. Pings are answered and pongs skipped on the way. When the
+// peer closes, the close is echoed and a *CloseError returned. Protocol
+// violations send a close frame with the matching status; after any
+// error the connection can only be closed.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	if c.readErr != nil {
+		return 0, nil, c.readErr
+	}
+	typ, msg, err := c.readMessage()
+	if err != nil {
+		c.readErr = err
+	}
+	return typ, msg, err
+}
+
+func (c *Conn) readMessage() (MessageType, []byte, error) {
+	var typ MessageType
+	var msg []byte
+	for {
+		h, err := c.readHeader()
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+		switch h.opcode {
+		case opText, opBinary:
+			if typ != 0 {
+				return 0, nil, c.fail(fmt.Errorf("%w: new message before the last one ended", ErrProtocol))
+			}
+			typ = MessageType(h.opcode)
+		case opContinuation:
+			if typ == 0 {
+				return 0, nil, c.fail(fmt.Errorf("%w: continuation frame outside a message", ErrProtocol))
+			}
+		case opPing, opPong, opClose:
+		default:
+			return 0, nil, c.fail(fmt.Errorf("%w: unknown opcode %#x", ErrProtocol, h.opcode))
+		}
+		if h.opcode < opClose && c.maxMessageSize > 0 && h.length > uint64(c.maxMessageSize)-uint64(len(msg)) {
+			return 0, nil, c.fail(ErrMessageTooBig)
+		}
+
+		payload, err := c.readPayload(h)
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+		switch h.opcode {
+		case opPing:
+			// Once our close is sent, pings go unanswered while we wait
+			// for the peer's.
+			if err := c.writeFrame(opPong, true, payload); err != nil && err != ErrClosed {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			return 0, nil, c.handleClose(payload)
+		}
+		msg = append(msg, payload...)
+		if h.fin {
+			break
+		}
+	}
+	if typ == TextMessage && !utf8.Valid(msg) {
+		return 0, nil, c.fail(ErrInvalidUTF8)
+	}
+	if msg == nil {
+		msg = []byte{}
+	}
+	return typ, msg, nil
+}
+
+func (c *Conn) readHeader() (frameHeader, error) {
+	var h frameHeader
+	var b [8]byte
+	if _, err := io.ReadFull(c.br, b[:2]); err != nil {
+		return h, err
+	}
+	h.fin = b[0]&finBit != 0
+	h.opcode = b[0] & 0x0f
+	if b[0]&0x70 != 0 {
+		return h, fmt.Errorf("%w: reserved bits set", ErrProtocol)
+	}
+	if b[1]&maskBit == 0 {
+		return h, fmt.Errorf("%w: unmasked client frame", ErrProtocol)
+	}
+	switch h.length = uint64(b[1] & 0x7f); h.length {
+	case 126:
+		if _, err := io.ReadFull(c.br, b[:2]); err != nil {
+			return h, err
+		}
+		h.length = uint64(binary.BigEndian.Uint16(b[:2]))
+	case 127:
+		if _, err := io.ReadFull(c.br, b[:8]); err != nil {
+			return h, err
+		}
+		h.length = binary.BigEndian.Uint64(b[:8])
+		if h.length>>63 != 0 {
+			return h, fmt.Errorf("%w: invalid payload length", ErrProtocol)
+		}
+	}
+	if h.opcode >= opClose && (!h.fin || h.length > maxControlPayload) {
+		return h, fmt.Errorf("%w: fragmented or oversized control frame", ErrProtocol)
+	}
+	_, err := io.ReadFull(c.br, h.mask[:])
+	return h, err
+}
+
+func (c *Conn) readPayload(h frameHeader) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Grow(int(min(h.length, maxPayloadPrealloc)))
+	if _, err := io.CopyN(&buf, c.br, int64(h.length)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	payload := buf.Bytes()
+	for i := range payload {
+		payload[i] ^= h.mask[i%4]
+	}
+	return payload, nil
+}
+
+// fail sends a close frame with the status matching err, when the peer
+// broke the protocol, and returns err. Read errors are returned as they
+// are.
+func (c *Conn) fail(err error) error {
+	var code CloseCode
+	switch {
+	case errors.Is(err, ErrProtocol):
+		code = CloseProtocolError
+	case errors.Is(err, ErrInvalidUTF8):
+		code = CloseInvalidPayload
+	case errors.Is(err, ErrMessageTooBig):
+		code = CloseMessageTooBig
+	default:
+		return err
+	}
+	_ = c.WriteClose(code, "")
+	return err
+}
+
+// handleClose answers a close frame with the same status and returns the
+// CloseError describing it.
+func (c *Conn) handleClose(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatusReceived}
+	switch {
+	case len(payload) == 1:
+		return c.fail(fmt.Errorf("%w: truncated close frame", ErrProtocol))
+	case len(payload) >= 2:
+		closeErr.Code = CloseCode(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+		if !validCloseCode(closeErr.Code) {
+			return c.fail(fmt.Errorf("%w: invalid close code %d", ErrProtocol, closeErr.Code))
+		}
+		if !utf8.ValidString(closeErr.Reason) {
+			return c.fail(ErrInvalidUTF8)
+		}
+	}
+	var echo []byte
+	if len(payload) >= 2 {
+		echo = payload[:2]
+	}
+	if err := c.writeFrame(opClose, true, echo); err != nil && err != ErrClosed {
+		return err
+	}
+	return closeErr
+}
+
+// WriteMessage sends data as a single-frame message.
+func (c *Conn) WriteMessage(typ MessageType, data []byte) error {
+	if typ != TextMessage && typ != BinaryMessage {
+		return fmt.Errorf("websocket: invalid message type %d", typ)
+	}
+	return c.writeFrame(byte(typ), true, data)
+}
+
+// NextWriter returns a writer for a message sent in fragments: each
+// Write sends one frame, and Close ends the message. Control frames may
+// be sent in between, but no other message until Close.
+func (c *Conn) NextWriter(typ MessageType) (io.WriteCloser, error) {
+	if typ != TextMessage && typ != BinaryMessage {
+		return nil, fmt.Errorf("websocket: invalid message type %d", typ)
+	}
+	return &messageWriter{c: c, opcode: byte(typ)}, nil
+}
+
+type messageWriter struct {
+	c      *Conn
+	opcode byte
+	closed bool
+}
+
+func (w *messageWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, ErrClosed
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err := w.c.writeFrame(w.opcode, false, p); err != nil {
+		return 0, err
+	}
+	w.opcode = opContinuation
+	return len(p), nil
+}
+
+func (w *messageWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.c.writeFrame(w.opcode, true, nil)
+}
+
+// Ping sends a ping with up to 125 bytes of data.
+func (c *Conn) Ping(data []byte) error {
+	if len(data) > maxControlPayload {
+		return fmt.Errorf("websocket: ping data longer than %d bytes", maxControlPayload)
+	}
+	return c.writeFrame(opPing, true, data)
+}
+
+// WriteClose sends a close frame. Nothing can be written afterwards, but
+// ReadMessage keeps returning messages until the peer's close arrives.
+func (c *Conn) WriteClose(code CloseCode, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	payload = append(payload, reason...)
+	if len(payload) > maxControlPayload {
+		return fmt.Errorf("websocket: close reason longer than %d bytes", maxControlPayload-2)
+	}
+	return c.writeFrame(opClose, true, payload)
+}
+
+// Close sends a normal close frame, unless one was sent already, and
+// closes the connection.
+func (c *Conn) Close() error {
+	_ = c.WriteClose(CloseNormalClosure, "")
+	return c.conn.Close()
+}
+
+// writeFrame writes one unmasked frame, as servers send them.
+func (c *Conn) writeFrame(opcode byte, fin bool, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return ErrClosed
+	}
+	if opcode == opClose {
+		c.closeSent = true
+	}
+
+	b0 := opcode
+	if fin {
+		b0 |= finBit
+	}
+	header := []byte{b0, 0}
+	switch n := len(payload); {
+	case n <= 125:
+		header[1] = byte(n)
+	case n <= 0xffff:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	if _, err := c.bw.Write(header); err != nil {
+		return err
+	}
+	if _, err := c.bw.Write(payload); err != nil {
+		return err
+	}
+	return c.bw.Flush()
+}
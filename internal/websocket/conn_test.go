@@ -0,0 +1,264 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"main/internal/request"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// echo returns each message it reads, and reports how reading ended.
+func echo(errs chan<- error) func(conn *Conn, req *request.Request) {
+	return func(conn *Conn, req *request.Request) {
+		for {
+			typ, msg, err := conn.ReadMessage()
+			if err != nil {
+				errs <- err
+				return
+			}
+			if err := conn.WriteMessage(typ, msg); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}
+}
+
+// dial connects to a server running fn and completes the handshake.
+func dial(t *testing.T, fn func(conn *Conn, req *request.Request), opts ...Option) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	head, conn, br := handshake(t, startServer(t, Handler(fn, opts...)))
+	require.True(t, strings.HasPrefix(head, "HTTP/1.1 101"), head)
+	return conn, br
+}
+
+// writeFrame sends a masked frame, as clients must.
+func writeFrame(t *testing.T, w io.Writer, fin bool, opcode byte, payload []byte) {
+	t.Helper()
+	b0 := opcode
+	if fin {
+		b0 |= finBit
+	}
+	frame := []byte{b0}
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xffff:
+		frame = binary.BigEndian.AppendUint16(append(frame, maskBit|126), uint16(n))
+	default:
+		frame = binary.BigEndian.AppendUint64(append(frame, maskBit|127), uint64(n))
+	}
+	mask := [4]byte{0x12, 0x34, 0x56, 0x78}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, err := w.Write(frame)
+	require.NoError(t, err)
+}
+
+type frame struct {
+	fin     bool
+	opcode  byte
+	payload []byte
+}
+
+// readFrame reads an unmasked frame from the server.
+func readFrame(t *testing.T, br *bufio.Reader) frame {
+	t.Helper()
+	var b [8]byte
+	_, err := io.ReadFull(br, b[:2])
+	require.NoError(t, err)
+	require.Zero(t, b[1]&maskBit, "server frames are not masked")
+	b0, length := b[0], uint64(b[1]&0x7f)
+	switch length {
+	case 126:
+		_, err = io.ReadFull(br, b[:2])
+		length = uint64(binary.BigEndian.Uint16(b[:2]))
+	case 127:
+		_, err = io.ReadFull(br, b[:8])
+		length = binary.BigEndian.Uint64(b[:8])
+	}
+	require.NoError(t, err)
+	payload := make([]byte, length)
+	_, err = io.ReadFull(br, payload)
+	require.NoError(t, err)
+	return frame{fin: b0&finBit != 0, opcode: b0 & 0x0f, payload: payload}
+}
+
+func closePayload(code CloseCode, reason string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(code)), reason...)
+}
+
+func TestConnEcho(t *testing.T) {
+	errs := make(chan error, 1)
+	conn, br := dial(t, echo(errs))
+
+	// Test: Text and binary messages, with every payload length encoding
+	for _, payload := range [][]byte{[]byte("hello"), bytes.Repeat([]byte{0xfe}, 300), bytes.Repeat([]byte("x"), 70000)} {
+		opcode := byte(opBinary)
+		if utf8Text := payload[0] != 0xfe; utf8Text {
+			opcode = opText
+		}
+		writeFrame(t, conn, true, opcode, payload)
+		got := readFrame(t, br)
+		assert.Equal(t, frame{fin: true, opcode: opcode, payload: payload}, got)
+	}
+
+	// Test: A fragmented message with a ping in between, which is answered
+	// first
+	writeFrame(t, conn, false, opText, []byte("frag"))
+	writeFrame(t, conn, true, opPing, []byte("are you there"))
+	writeFrame(t, conn, false, opContinuation, []byte("men"))
+	writeFrame(t, conn, true, opContinuation, []byte("ted"))
+	assert.Equal(t, frame{fin: true, opcode: opPong, payload: []byte("are you there")}, readFrame(t, br))
+	assert.Equal(t, frame{fin: true, opcode: opText, payload: []byte("fragmented")}, readFrame(t, br))
+
+	// Test: A close is echoed and reported to the handler
+	writeFrame(t, conn, true, opClose, closePayload(CloseGoingAway, "bye"))
+	assert.Equal(t, frame{fin: true, opcode: opClose, payload: closePayload(CloseGoingAway, "")}, readFrame(t, br))
+	var closeErr *CloseError
+	require.ErrorAs(t, <-errs, &closeErr)
+	assert.Equal(t, &CloseError{Code: CloseGoingAway, Reason: "bye"}, closeErr)
+	_, err := br.ReadByte()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestConnCloseHandshake(t *testing.T) {
+	// reads gets each message, then the error that ended reading.
+	reads := make(chan any, 2)
+	conn, br := dial(t, func(conn *Conn, req *request.Request) {
+		_ = conn.WriteClose(CloseNormalClosure, "bye")
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				reads <- err
+				return
+			}
+			reads <- string(msg)
+		}
+	})
+	assert.Equal(t, frame{fin: true, opcode: opClose, payload: closePayload(CloseNormalClosure, "bye")}, readFrame(t, br))
+
+	// Test: After sending its close, the server reads on past pings until
+	// the peer's close arrives, and answers neither
+	writeFrame(t, conn, true, opPing, []byte("still there?"))
+	writeFrame(t, conn, true, opText, []byte("last words"))
+	require.Equal(t, "last words", <-reads)
+	writeFrame(t, conn, true, opClose, closePayload(CloseNormalClosure, ""))
+	err, _ := (<-reads).(error)
+	var closeErr *CloseError
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, CloseNormalClosure, closeErr.Code)
+	_, err = br.ReadByte()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestConnUnlimitedMessageSize(t *testing.T) {
+	// Test: A zero limit accepts messages of any size
+	errs := make(chan error, 1)
+	conn, br := dial(t, echo(errs), WithMaxMessageSize(0))
+	payload := bytes.Repeat([]byte("x"), 2*defaultMaxMessageSize)
+	writeFrame(t, conn, true, opBinary, payload)
+	assert.Equal(t, frame{fin: true, opcode: opBinary, payload: payload}, readFrame(t, br))
+}
+
+func TestConnDeclaredLength(t *testing.T) {
+	// Test: A frame claiming a huge payload only costs what actually
+	// arrives, even without a message size limit
+	errs := make(chan error, 1)
+	conn, _ := dial(t, echo(errs), WithMaxMessageSize(0))
+	header := binary.BigEndian.AppendUint64([]byte{finBit | opBinary, maskBit | 127}, 1<<62)
+	_, err := conn.Write(append(append(header, 0x12, 0x34, 0x56, 0x78), "only this"...))
+	require.NoError(t, err)
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+	assert.ErrorIs(t, <-errs, io.ErrUnexpectedEOF)
+}
+
+func TestConnProtocolErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		send   func(t *testing.T, w io.Writer)
+		code   CloseCode
+		target error
+	}{
+		{"unmasked frame", func(t *testing.T, w io.Writer) {
+			_, err := w.Write([]byte{finBit | opText, 2, 'h', 'i'})
+			require.NoError(t, err)
+		}, CloseProtocolError, ErrProtocol},
+		{"reserved bits", func(t *testing.T, w io.Writer) {
+			writeFrame(t, w, true, opText|0x40, []byte("hi"))
+		}, CloseProtocolError, ErrProtocol},
+		{"unknown opcode", func(t *testing.T, w io.Writer) {
+			writeFrame(t, w, true, 0x3, nil)
+		}, CloseProtocolError, ErrProtocol},
+		{"continuation without message", func(t *testing.T, w io.Writer) {
+			writeFrame(t, w, true, opContinuation, []byte("hi"))
+		}, CloseProtocolError, ErrProtocol},
+		{"message inside a message", func(t *testing.T, w io.Writer) {
+			writeFrame(t, w, false, opText, []byte("a"))
+			writeFrame(t, w, true, opText, []byte("b"))
+		}, CloseProtocolError, ErrProtocol},
+		{"fragmented ping", func(t *testing.T, w io.Writer) {
+			writeFrame(t, w, false, opPing, nil)
+		}, CloseProtocolError, ErrProtocol},
+		{"invalid close code", func(t *testing.T, w io.Writer) {
+			writeFrame(t, w, true, opClose, closePayload(1005, ""))
+		}, CloseProtocolError, ErrProtocol},
+		{"invalid UTF-8", func(t *testing.T, w io.Writer) {
+			writeFrame(t, w, false, opText, []byte{0xe2, 0x82})
+			writeFrame(t, w, true, opContinuation, []byte{0x28})
+		}, CloseInvalidPayload, ErrInvalidUTF8},
+		{"message too big", func(t *testing.T, w io.Writer) {
+			writeFrame(t, w, false, opBinary, make([]byte, 60))
+			writeFrame(t, w, true, opContinuation, make([]byte, 60))
+		}, CloseMessageTooBig, ErrMessageTooBig},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// Test: The connection is closed with the matching status
+			errs := make(chan error, 1)
+			conn, br := dial(t, echo(errs), WithMaxMessageSize(100))
+			tc.send(t, conn)
+			assert.Equal(t, frame{fin: true, opcode: opClose, payload: closePayload(tc.code, "")}, readFrame(t, br))
+			assert.ErrorIs(t, <-errs, tc.target)
+		})
+	}
+}
+
+func TestConnWrite(t *testing.T) {
+	errs := make(chan error, 1)
+	conn, br := dial(t, func(conn *Conn, req *request.Request) {
+		w, err := conn.NextWriter(TextMessage)
+		if err != nil {
+			errs <- err
+			return
+		}
+		_, _ = io.WriteString(w, "live ")
+		_ = conn.Ping([]byte("p"))
+		_, _ = io.WriteString(w, "update")
+		_ = w.Close()
+		_ = conn.WriteClose(ClosePolicyViolation, "done")
+		errs <- conn.WriteMessage(TextMessage, []byte("late"))
+	})
+
+	// Test: Fragments, an interleaved ping, and a final empty frame
+	assert.Equal(t, frame{fin: false, opcode: opText, payload: []byte("live ")}, readFrame(t, br))
+	assert.Equal(t, frame{fin: true, opcode: opPing, payload: []byte("p")}, readFrame(t, br))
+	assert.Equal(t, frame{fin: false, opcode: opContinuation, payload: []byte("update")}, readFrame(t, br))
+	assert.Equal(t, frame{fin: true, opcode: opContinuation, payload: []byte{}}, readFrame(t, br))
+
+	// Test: Nothing is written after a close frame
+	assert.Equal(t, frame{fin: true, opcode: opClose, payload: closePayload(ClosePolicyViolation, "done")}, readFrame(t, br))
+	assert.True(t, errors.Is(<-errs, ErrClosed))
+	_, err := br.ReadByte()
+	assert.Equal(t, io.EOF, err)
+	conn.Close()
+}
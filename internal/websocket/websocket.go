@@ -0,0 +1,175 @@
+package websocket
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"main/internal/headers"
+	"main/internal/request"
+	"main/internal/response"
+	"main/internal/server"
+	"net/url"
+	"strings"
+)
+
+// acceptGUID is appended to the client's key to derive
+// Sec-WebSocket-Accept (RFC 6455 4.2.2).
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const defaultMaxMessageSize = 1 << 20
+
+// ErrBadHandshake is returned by Upgrade when the request is not a valid
+// WebSocket opening handshake. The client has already been answered.
+var ErrBadHandshake = errors.New("websocket: bad handshake")
+
+type config struct {
+	maxMessageSize int64
+	subprotocols   []string
+	checkOrigin    func(req *request.Request) bool
+}
+
+// Option configures Upgrade and Handler.
+type Option func(*config)
+
+// WithMaxMessageSize sets the largest message a connection accepts, after
+// reassembling fragments. Larger messages close the connection with
+// status 1009. Zero removes the limit.
+func WithMaxMessageSize(n int64) Option {
+	return func(c *config) {
+		c.maxMessageSize = n
+	}
+}
+
+// WithSubprotocols sets the subprotocols the server speaks, in order of
+// preference. The first one the client also offers is selected.
+func WithSubprotocols(protocols ...string) Option {
+	return func(c *config) {
+		c.subprotocols = protocols
+	}
+}
+
+// WithCheckOrigin replaces the origin check, which by default accepts
+// requests without an Origin header and requests whose Origin host
+// matches Host, so other sites' pages cannot connect with the user's
+// cookies.
+func WithCheckOrigin(check func(req *request.Request) bool) Option {
+	return func(c *config) {
+		c.checkOrigin = check
+	}
+}
+
+func newConfig(opts []Option) *config {
+	cfg := &config{
+		maxMessageSize: defaultMaxMessageSize,
+		checkOrigin:    sameOrigin,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+func sameOrigin(req *request.Request) bool {
+	origin := req.Headers.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, req.Headers.Get("Host"))
+}
+
+// Handler returns a server.Handler that upgrades each request and runs fn
+// with the connection, closing it when fn returns.
+func Handler(fn func(conn *Conn, req *request.Request), opts ...Option) server.Handler {
+	return func(w response.Writer, req *request.Request) {
+		conn, err := Upgrade(w, req, opts...)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fn(conn, req)
+	}
+}
+
+// Upgrade completes the opening handshake (RFC 6455 4.2) and takes over
+// the connection. Invalid handshakes are answered with an error status
+// and ErrBadHandshake.
+func Upgrade(w response.Writer, req *request.Request, opts ...Option) (*Conn, error) {
+	cfg := newConfig(opts)
+	h := req.Headers
+	if req.RequestLine.Method != "GET" || req.RequestLine.HttpVersion != "1.1" ||
+		!h.HasToken("Upgrade", "websocket") || !h.HasToken("Connection", "Upgrade") {
+		return nil, reject(w, response.StatusBadRequest, "Not a WebSocket handshake")
+	}
+	if h.Get("Sec-WebSocket-Version") != "13" {
+		rh := response.GetDefaultHeaders(0)
+		rh.Set("Sec-WebSocket-Version", "13")
+		_ = w.WriteStatusLine(response.StatusUpgradeRequired)
+		_ = w.WriteHeaders(rh)
+		return nil, fmt.Errorf("%w: unsupported version %q", ErrBadHandshake, h.Get("Sec-WebSocket-Version"))
+	}
+	key := h.Get("Sec-WebSocket-Key")
+	if nonce, err := base64.StdEncoding.DecodeString(key); err != nil || len(nonce) != 16 {
+		return nil, reject(w, response.StatusBadRequest, "Invalid Sec-WebSocket-Key")
+	}
+	if !cfg.checkOrigin(req) {
+		return nil, reject(w, response.StatusForbidden, "Origin not allowed")
+	}
+	hj, ok := w.(response.Hijacker)
+	if !ok {
+		return nil, reject(w, response.StatusInternalServerError, "Connection cannot be upgraded")
+	}
+
+	rh := headers.NewHeaders()
+	rh.Set("Upgrade", "websocket")
+	rh.Set("Connection", "Upgrade")
+	rh.Set("Sec-WebSocket-Accept", acceptKey(key))
+	protocol := selectSubprotocol(cfg.subprotocols, h.Get("Sec-WebSocket-Protocol"))
+	if protocol != "" {
+		rh.Set("Sec-WebSocket-Protocol", protocol)
+	}
+
+	netConn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, reject(w, response.StatusInternalServerError, "Connection cannot be upgraded")
+	}
+	// Written by hand: the writer is gone once the connection is
+	// hijacked.
+	_, err = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	if err == nil {
+		_, err = rh.WriteTo(rw)
+	}
+	if err == nil {
+		err = rw.Flush()
+	}
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	return newConn(netConn, rw.Reader, cfg.maxMessageSize, protocol), nil
+}
+
+func reject(w response.Writer, code response.StatusCode, msg string) error {
+	_ = response.Error(w, code, msg+"\n")
+	return fmt.Errorf("%w: %s", ErrBadHandshake, strings.ToLower(msg))
+}
+
+// acceptKey derives Sec-WebSocket-Accept from Sec-WebSocket-Key.
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// selectSubprotocol returns the first of supported that the client
+// offered, or "".
+func selectSubprotocol(supported []string, offered string) string {
+	for _, p := range supported {
+		for _, o := range strings.Split(offered, ",") {
+			if strings.TrimSpace(o) == p {
+				return p
+			}
+		}
+	}
+	return ""
+}
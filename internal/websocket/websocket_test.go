@@ -0,0 +1,119 @@
+package websocket
+
+import (
+	"bufio"
+	"io"
+	"main/internal/request"
+	"main/internal/server"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testKey = "dGhlIHNhbXBsZSBub25jZQ=="
+
+// startServer runs handler and returns the server address.
+func startServer(t *testing.T, handler server.Handler) string {
+	t.Helper()
+	s, err := server.Serve(0, handler)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s.Addr().String()
+}
+
+// handshake sends an opening handshake with the given extra header lines
+// and returns the response head and the connection.
+func handshake(t *testing.T, addr string, extra ...string) (string, net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	lines := []string{
+		"GET /ws HTTP/1.1",
+		"Host: example.test",
+		"Upgrade: websocket",
+		"Connection: keep-alive, Upgrade",
+		"Sec-WebSocket-Key: " + testKey,
+		"Sec-WebSocket-Version: 13",
+	}
+	_, err = io.WriteString(conn, strings.Join(append(lines, extra...), "\r\n")+"\r\n\r\n")
+	require.NoError(t, err)
+
+	br := bufio.NewReader(conn)
+	var head strings.Builder
+	for {
+		line, err := br.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+		head.WriteString(line)
+	}
+	return head.String(), conn, br
+}
+
+func TestUpgrade(t *testing.T) {
+	protocols := make(chan string, 1)
+	addr := startServer(t, Handler(func(conn *Conn, req *request.Request) {
+		protocols <- conn.Subprotocol()
+	}, WithSubprotocols("v2.chat", "chat")))
+
+	// Test: The accept key of the RFC 6455 example, and the first
+	// supported subprotocol the client offers
+	head, _, _ := handshake(t, addr, "Sec-WebSocket-Protocol: chat, v2.chat", "Origin: http://example.test")
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 101 Switching Protocols\r\n"))
+	assert.Contains(t, head, "sec-websocket-accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=\r\n")
+	assert.Contains(t, head, "upgrade: websocket\r\n")
+	assert.Contains(t, head, "sec-websocket-protocol: v2.chat\r\n")
+	assert.Equal(t, "v2.chat", <-protocols)
+
+	// Test: No subprotocol in common
+	head, _, _ = handshake(t, addr, "Sec-WebSocket-Protocol: mqtt")
+	assert.NotContains(t, head, "sec-websocket-protocol")
+	assert.Equal(t, "", <-protocols)
+}
+
+func TestUpgradeRejected(t *testing.T) {
+	addr := startServer(t, Handler(func(conn *Conn, req *request.Request) {}))
+
+	for _, tc := range []struct {
+		name   string
+		extra  []string
+		status string
+	}{
+		{"unsupported version", []string{"Sec-WebSocket-Version: 8"}, "HTTP/1.1 426 Upgrade Required"},
+		{"short key", []string{"Sec-WebSocket-Key: c2hvcnQ="}, "HTTP/1.1 400 Bad Request"},
+		{"foreign origin", []string{"Origin: https://evil.test"}, "HTTP/1.1 403 Forbidden"},
+	} {
+		// Test: Each header replaces the valid one sent first
+		head, _, _ := handshake(t, addr, tc.extra...)
+		assert.True(t, strings.HasPrefix(head, tc.status), tc.name)
+	}
+
+	// Test: A request without Upgrade is not a handshake
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: example.test\r\n\r\n")
+	require.NoError(t, err)
+	status, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 400 Bad Request\r\n", status)
+}
+
+func TestCheckOrigin(t *testing.T) {
+	addr := startServer(t, Handler(func(conn *Conn, req *request.Request) {},
+		WithCheckOrigin(func(req *request.Request) bool { return req.Headers.Get("Origin") == "https://app.test" })))
+
+	// Test: A custom check replaces the same-origin default
+	head, _, _ := handshake(t, addr, "Origin: https://app.test")
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 101"))
+	head, _, _ = handshake(t, addr, "Origin: http://example.test")
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 403"))
+}